@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/graph"
+)
+
+func connectPlayers(env environment, args []string) error {
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return errors.New("expected two player names")
+	}
+
+	repo := env.decorate(env.newRepository(env.download(), false))
+	defer repo.Close()
+
+	from, err := selectPlayer(repo, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	to, err := selectPlayer(repo, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	links, err := graph.New(repo).Path(from.Id, to.Id)
+	if err != nil {
+		return errors.Wrapf(err, "connecting %s and %s", from.Name, to.Name)
+	}
+
+	fmt.Printf("%s (#%s)\n", links[0].Player.Name, links[0].Player.Id)
+	for _, l := range links[1:] {
+		fmt.Printf("  %s (#%d, %s): %s (#%s)\n", l.Team.Name, l.Team.Id, teamKind(l.Team.IsNational), l.Player.Name, l.Player.Id)
+	}
+
+	fmt.Printf("Degrees of separation: %d\n", len(links)-1)
+
+	return nil
+}
+
+func listTeammates(env environment, args []string) error {
+	fs := flag.NewFlagSet("teammates", flag.ExitOnError)
+	fs.Parse(args)
+
+	name := strings.Join(fs.Args(), " ")
+	if name == "" {
+		return errors.New("no player name given")
+	}
+
+	repo := env.decorate(env.newRepository(env.download(), false))
+	defer repo.Close()
+
+	player, err := selectPlayer(repo, name)
+	if err != nil {
+		return err
+	}
+
+	teammates, err := graph.New(repo).Teammates(player.Id)
+	if err != nil {
+		return errors.Wrapf(err, "getting teammates of %s", player.Name)
+	}
+
+	entries := make([]string, len(teammates))
+	for i, tm := range teammates {
+		teams := make([]string, len(tm.Teams))
+		for j, t := range tm.Teams {
+			teams[j] = t.Name
+		}
+
+		entries[i] = fmt.Sprintf("%s (#%s); %s", tm.Player.Name, tm.Player.Id, strings.Join(teams, ", "))
+	}
+
+	collator := collate.New(language.English, collate.Loose)
+	collator.SortStrings(entries)
+
+	for i, e := range entries {
+		fmt.Printf("%d. %s\n", i+1, e)
+	}
+
+	return nil
+}
+
+func listConnectedClubs(env environment, args []string) error {
+	fs := flag.NewFlagSet("clubs", flag.ExitOnError)
+	limit := fs.Int("limit", 10, "maximum number of listed clubs, or 0 for all")
+	fs.Parse(args)
+
+	repo := env.decorate(env.newRepository(env.download(), false))
+	defer repo.Close()
+
+	conns, err := graph.New(repo).MostConnected(*limit)
+	if err != nil {
+		return errors.Wrap(err, "getting most connected clubs")
+	}
+
+	for i, c := range conns {
+		fmt.Printf("%d. %s (#%d); %d teams; %d players\n", i+1, c.Team.Name, c.Team.Id, c.Teams, c.Players)
+	}
+
+	return nil
+}
+
+// selectPlayer looks for the player given either a name or an id prefixed by
+// '#'. Players sharing the name have to be selected by id.
+func selectPlayer(repo football.TeamRepository, name string) (football.Player, error) {
+	if strings.HasPrefix(name, "#") {
+		return repo.GetPlayer(football.PlayerId(name[1:]))
+	}
+
+	players, err := storage.SearchPlayers(repo, name, 10)
+	if err != nil {
+		return football.Player{}, errors.Wrap(err, "searching players")
+	}
+
+	exact := exactPlayers(players, name)
+
+	switch {
+	case len(exact) == 1:
+		return exact[0], nil
+	case len(exact) == 0 && len(players) == 0:
+		return football.Player{}, storage.NotFound(storage.EntityPlayer, name, nil)
+	case len(exact) == 0:
+		names := make([]string, len(players))
+		for i, p := range players {
+			names[i] = fmt.Sprintf("%q", p.Name)
+		}
+
+		return football.Player{}, storage.NotFound(storage.EntityPlayer, name, errors.Errorf("did you mean %s?", strings.Join(names, ", ")))
+	}
+
+	desc := make([]string, len(exact))
+	for i, p := range exact {
+		desc[i] = fmt.Sprintf("#%s %s (%d)", p.Id, p.Name, p.Age)
+	}
+
+	return football.Player{}, storage.Ambiguous(storage.EntityPlayer, name, errors.Errorf(
+		"use one of: %s", strings.Join(desc, ", ")))
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/goleveldb"
+)
+
+// dbCommands are the subcommands of the db command, which maintain the
+// goleveldb storage.
+var dbCommands = map[string]func(env environment, args []string) error{
+	"check":     checkDatabase,
+	"compact":   compactDatabase,
+	"prune":     pruneDatabase,
+	"reencrypt": reencryptDatabase,
+	"repair":    repairDatabase,
+	"stats":     databaseStats,
+}
+
+func database(env environment, args []string) error {
+	if leveldbPath == "" {
+		return errors.New("the db command requires -leveldb-path")
+	}
+
+	names := make([]string, 0, len(dbCommands))
+	for name := range dbCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(args) == 0 {
+		return errors.Errorf("expected one of the db commands: %s", strings.Join(names, ", "))
+	}
+
+	command, ok := dbCommands[args[0]]
+	if !ok {
+		return errors.Errorf("unknown db command %s, expected one of: %s", args[0], strings.Join(names, ", "))
+	}
+
+	return command(env, args[1:])
+}
+
+func checkDatabase(env environment, args []string) error {
+	fs := flag.NewFlagSet("db check", flag.ExitOnError)
+	fs.Parse(args)
+
+	env.logger.Printf("Checking goleveldb database %s\n", leveldbPath)
+
+	issues, err := goleveldb.Check(dbOptions(env)...)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range issues {
+		fmt.Println(i)
+	}
+
+	if len(issues) > 0 {
+		return storage.Corrupt("", nil, errors.Errorf("found %d issues, use db repair to fix them", len(issues)))
+	}
+
+	fmt.Println("No issues found")
+
+	return nil
+}
+
+func repairDatabase(env environment, args []string) error {
+	fs := flag.NewFlagSet("db repair", flag.ExitOnError)
+	fs.Parse(args)
+
+	env.logger.Printf("Repairing goleveldb database %s\n", leveldbPath)
+
+	issues, err := goleveldb.Repair(dbOptions(env)...)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range issues {
+		fmt.Println(i)
+	}
+
+	fmt.Printf("Repaired %d issues\n", len(issues))
+
+	return nil
+}
+
+func databaseStats(env environment, args []string) error {
+	fs := flag.NewFlagSet("db stats", flag.ExitOnError)
+	fs.Parse(args)
+
+	stats, err := goleveldb.GetStats(dbOptions(env)...)
+	if err != nil {
+		return err
+	}
+
+	updated := "never"
+	if !stats.UpdatedAt.IsZero() {
+		updated = stats.UpdatedAt.Format(time.RFC3339)
+	}
+
+	version := stats.IndexVersion
+	if version == "" {
+		version = "none"
+	}
+
+	fmt.Printf("Path: %s\n", leveldbPath)
+	fmt.Printf("Generation: %s\n", filepath.Base(stats.Generation))
+	fmt.Printf("Size: %s\n", formatSize(stats.Size))
+	fmt.Printf("Updated: %s\n", updated)
+	fmt.Printf("Index version: %s\n", version)
+	fmt.Printf("Encrypted: %t\n", stats.Encrypted)
+	fmt.Println("Records:")
+
+	for _, r := range stats.Records {
+		prefix := r.Prefix
+		if prefix == "" {
+			prefix = "(other)"
+		}
+
+		fmt.Printf("  %-20s %d\n", prefix, r.Count)
+	}
+
+	return nil
+}
+
+func compactDatabase(env environment, args []string) error {
+	fs := flag.NewFlagSet("db compact", flag.ExitOnError)
+	fs.Parse(args)
+
+	before, err := goleveldb.GetStats(dbOptions(env)...)
+	if err != nil {
+		return err
+	}
+
+	env.logger.Printf("Compacting goleveldb database %s\n", leveldbPath)
+
+	if err := goleveldb.Compact(dbOptions(env)...); err != nil {
+		return err
+	}
+
+	after, err := goleveldb.GetStats(dbOptions(env)...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Compacted %s from %s to %s\n", leveldbPath, formatSize(before.Size), formatSize(after.Size))
+
+	return nil
+}
+
+func pruneDatabase(env environment, args []string) error {
+	fs := flag.NewFlagSet("db prune", flag.ExitOnError)
+	fs.Parse(args)
+
+	env.logger.Printf("Pruning goleveldb database %s\n", leveldbPath)
+
+	teams, players, err := goleveldb.Prune(dbOptions(env)...)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range teams {
+		fmt.Printf("Removed team %s (#%d)\n", t.Name, t.Id)
+	}
+
+	for _, p := range players {
+		fmt.Printf("Removed player %s (#%s)\n", p.Name, p.Id)
+	}
+
+	fmt.Printf("Pruned %d teams and %d players\n", len(teams), len(players))
+
+	return nil
+}
+
+func reencryptDatabase(env environment, args []string) error {
+	fs := flag.NewFlagSet("db reencrypt", flag.ExitOnError)
+	keyPath := fs.String("new-key-file", "", "the file with the hex or base64 encoded key to encrypt the data with, taking precedence over "+newKeyEnv)
+	decrypt := fs.Bool("decrypt", false, "store the data unencrypted")
+	fs.Parse(args)
+
+	key, err := loadKey(*keyPath, newKeyEnv)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *decrypt && key != nil:
+		return errors.New("-decrypt cannot be used with a new encryption key")
+	case !*decrypt && key == nil:
+		return errors.Errorf("expected -new-key-file, %s or -decrypt", newKeyEnv)
+	}
+
+	env.logger.Printf("Re-encrypting goleveldb database %s\n", leveldbPath)
+
+	if err := goleveldb.Reencrypt(key, dbOptions(env)...); err != nil {
+		return err
+	}
+
+	if key == nil {
+		fmt.Printf("Decrypted %s\n", leveldbPath)
+	} else {
+		fmt.Printf("Re-encrypted %s\n", leveldbPath)
+	}
+
+	return nil
+}
+
+// dbOptions are the options of the storage maintained by the db commands.
+func dbOptions(env environment) []goleveldb.Option {
+	return []goleveldb.Option{goleveldb.Path(leveldbPath), goleveldb.Encryption(env.key)}
+}
+
+// formatSize formats the byte size with a binary unit.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
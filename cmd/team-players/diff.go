@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/diff"
+	"github.com/urandom/team-search-test/storage/dump"
+	"github.com/urandom/team-search-test/storage/goleveldb"
+)
+
+func diffDatasets(env environment, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := fs.String("format", "text", "report format, one of text or json")
+	dumpFormat := fs.String("dump-format", "ndjson", "format of the compared dumps, one of ndjson, csv or json")
+	fs.Parse(args)
+
+	f, err := diff.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	df, err := dump.ParseFormat(*dumpFormat)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return errors.New("expected an old and a new dataset")
+	}
+
+	from, err := loadDataset(env, fs.Arg(0), df)
+	if err != nil {
+		return err
+	}
+
+	to, err := loadDataset(env, fs.Arg(1), df)
+	if err != nil {
+		return err
+	}
+
+	r, err := diff.Compare(from, to)
+	if err != nil {
+		return err
+	}
+
+	return diff.Write(os.Stdout, f, r)
+}
+
+// loadDataset reads the dataset from a goleveldb directory, or a dump file in
+// the given format.
+func loadDataset(env environment, path string, f dump.Format) (dump.Dataset, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return dump.Dataset{}, errors.Wrap(err, "opening dataset")
+	}
+
+	if info.IsDir() {
+		env.logger.Printf("Reading goleveldb dataset %s\n", path)
+
+		// The stored data is never replaced, so nothing is downloaded.
+		data := make(chan download.Team)
+		close(data)
+
+		repo := goleveldb.NewTeamRepository(data, goleveldb.Path(path), goleveldb.ReadOnly, goleveldb.Encryption(env.key)).(storage.Replayer)
+		defer repo.Close()
+
+		ds, err := dump.Collect(repo)
+		return ds, errors.Wrapf(err, "reading dataset %s", path)
+	}
+
+	env.logger.Printf("Reading %s dataset %s\n", f, path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return dump.Dataset{}, errors.Wrap(err, "opening dataset")
+	}
+	defer file.Close()
+
+	ds, err := dump.Read(file, f)
+	return ds, errors.Wrapf(err, "reading dataset %s", path)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/dump"
+)
+
+func export(env environment, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "ndjson", "dump format, one of ndjson, csv or json")
+	output := fs.String("o", "-", "output file, or stdout if '-'")
+	fs.Parse(args)
+
+	f, err := dump.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	opened := env.newRepository(env.download(), false)
+	defer opened.Close()
+
+	repo, ok := opened.(storage.Replayer)
+	if !ok {
+		return errors.New("storage doesn't support exporting")
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return errors.Wrap(err, "creating output file")
+		}
+		defer file.Close()
+
+		w = file
+	}
+
+	env.logger.Printf("Exporting storage as %s\n", f)
+
+	return dump.Export(w, f, repo)
+}
+
+func importDump(env environment, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "ndjson", "dump format, one of ndjson, csv or json")
+	fs.Parse(args)
+
+	// A dump imported into memory would be gone once the command exits.
+	if leveldbPath == "" {
+		return errors.New("the import command requires -leveldb-path")
+	}
+
+	if readOnly {
+		return errors.New("the import command cannot be used with -read-only")
+	}
+
+	f, err := dump.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() > 0 && fs.Arg(0) != "-" {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return errors.Wrap(err, "opening dump")
+		}
+		defer file.Close()
+
+		r = file
+	}
+
+	teams, err := dump.Import(r, f)
+	if err != nil {
+		return err
+	}
+
+	data := make(chan download.Team, len(teams))
+	for _, t := range teams {
+		data <- t
+	}
+	close(data)
+
+	opened := env.newRepository(data, true)
+	defer opened.Close()
+
+	repo, ok := opened.(storage.Replayer)
+	if !ok {
+		return errors.New("storage doesn't support importing")
+	}
+
+	ds, err := dump.Collect(repo)
+	if err != nil {
+		return errors.Wrap(err, "loading dump")
+	}
+
+	fmt.Printf("Imported %d teams and %d players\n", len(ds.Teams), len(ds.Players))
+
+	return nil
+}
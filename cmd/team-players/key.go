@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/storage/goleveldb"
+)
+
+// The environment variables holding the encryption keys, if no key file is
+// given.
+const (
+	keyEnv    = "TEAM_PLAYERS_ENCRYPTION_KEY"
+	newKeyEnv = "TEAM_PLAYERS_NEW_ENCRYPTION_KEY"
+)
+
+// loadKey reads the encryption key from the file, if given, or from the
+// environment variable otherwise. A nil key is returned if neither is set.
+func loadKey(path string, env string) ([]byte, error) {
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading encryption key file")
+		}
+
+		key, err := goleveldb.ParseKey(string(b))
+		return key, errors.Wrapf(err, "parsing encryption key file %s", path)
+	}
+
+	s, ok := os.LookupEnv(env)
+	if !ok || s == "" {
+		return nil, nil
+	}
+
+	key, err := goleveldb.ParseKey(s)
+	return key, errors.Wrapf(err, "parsing %s", env)
+}
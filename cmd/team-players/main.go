@@ -1,22 +1,33 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	"golang.org/x/text/collate"
 	"golang.org/x/text/language"
 
 	"github.com/urandom/team-search-test/download"
 	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/metrics"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
+	"github.com/urandom/team-search-test/storage/cache"
 	"github.com/urandom/team-search-test/storage/goleveldb"
 	"github.com/urandom/team-search-test/storage/memory"
+	"github.com/urandom/team-search-test/storage/tiered"
+	"github.com/urandom/team-search-test/tracing"
 )
 
 var (
@@ -29,98 +40,464 @@ var (
 	workers     int
 	verbose     bool
 	leveldbPath string
+	tieredRepo  bool
+	readOnly    bool
+	metricsAddr string
+	tracePath   string
+	aliasPath   string
+	keyPath     string
+	national    bool
+	club        bool
+
+	minAge          int
+	maxAge          int
+	minTeams        int
+	nationalPlayers bool
+	clubPlayers     bool
 )
 
 func main() {
-	names := defaults
-	if flag.NArg() != 0 {
-		names = flag.Args()
+	args := flag.Args()
+
+	if national && club {
+		log.Fatalf("Error: -national and -club are mutually exclusive")
 	}
 
-	teams := download.Teams(
-		download.Timeout(time.Duration(timeout)*time.Second),
-		download.Workers(workers),
-	)
+	var command string
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			command, args = args[0], args[1:]
+		}
+	}
 
-	var repo football.TeamRepository
-	if leveldbPath == "" {
-		repo = memory.NewTeamRepository(teams)
-	} else {
-		repo = goleveldb.NewTeamRepository(teams, goleveldb.Path(leveldbPath))
+	var registry *metrics.Registry
+	if metricsAddr != "" {
+		registry = metrics.NewRegistry()
+		serveMetrics(registry)
+	}
+
+	var aliases *alias.Set
+	if aliasPath != "" {
+		var err error
+		if aliases, err = alias.LoadFile(aliasPath); err != nil {
+			log.Fatalf("Error loading aliases: %+v", err)
+		}
 	}
 
-	var logger Logger = nopLogger{}
+	key, err := loadKey(keyPath, keyEnv)
+	if err != nil {
+		log.Fatalf("Error loading encryption key: %+v", err)
+	}
+
+	tracer, closeTrace, err := newTracer()
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %+v", err)
+	}
+
+	finishTrace := func() {
+		if err := closeTrace(); err != nil {
+			log.Printf("Error: %+v", err)
+		}
+	}
+	defer finishTrace()
+
+	env := environment{registry: registry, tracer: tracer, aliases: aliases, key: key, logger: nopLogger{}}
 	if verbose {
-		logger = errLogger{}
+		env.logger = errLogger{}
+	}
+
+	if command == "" {
+		err = listPlayers(env, args)
+	} else {
+		err = commands[command](env, args)
 	}
 
-	entries, err := getPlayers(repo, names, logger)
 	if err != nil {
-		log.Fatalf("Error getting players: %+v", err)
+		log.Printf("Error: %+v", err)
+
+		// Deferred calls don't run on exit.
+		finishTrace()
+		os.Exit(exitCode(err))
+	}
+}
+
+// Exit codes distinguishing the storage errors. Any other error exits with 1,
+// and invalid flags with 2.
+const (
+	exitNotFound    = 3
+	exitAmbiguous   = 4
+	exitNotReady    = 5
+	exitUnavailable = 6
+	exitCorrupt     = 7
+)
+
+// exitCode maps the error to the exit code of its kind.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, storage.ErrAmbiguous):
+		return exitAmbiguous
+	case errors.Is(err, storage.ErrNotReady):
+		return exitNotReady
+	case errors.Is(err, storage.ErrUnavailable):
+		return exitUnavailable
+	case errors.Is(err, storage.ErrCorrupt):
+		return exitCorrupt
+	default:
+		return 1
+	}
+}
+
+// environment holds the instrumentation shared by all commands.
+type environment struct {
+	registry *metrics.Registry
+	tracer   *tracing.Tracer
+	aliases  *alias.Set
+	key      []byte
+	logger   Logger
+}
+
+// commands are the subcommands, accepting any arguments after the command
+// name.
+var commands = map[string]func(env environment, args []string) error{
+	"clubs":     listConnectedClubs,
+	"connect":   connectPlayers,
+	"db":        database,
+	"diff":      diffDatasets,
+	"export":    export,
+	"import":    importDump,
+	"player":    findPlayer,
+	"refresh":   refreshData,
+	"squad":     listSquad,
+	"teammates": listTeammates,
+	"teams":     listTeams,
+	"watch":     watchChanges,
+}
+
+func listPlayers(env environment, names []string) error {
+	query := playerQuery()
+
+	// Filtered queries without any team names cover all players.
+	if len(names) == 0 && reflect.DeepEqual(query, football.PlayerQuery{}) {
+		names = defaults
+	}
+
+	repo := env.decorate(env.newRepository(env.download(), false))
+	defer repo.Close()
+
+	entries, err := getPlayers(repo, names, query, env.logger)
+	if err != nil {
+		return errors.Wrap(err, "getting players")
 	}
 
 	for _, e := range entries {
 		fmt.Println(e)
 	}
+
+	return nil
 }
 
-func getPlayers(repo football.TeamRepository, names []string, logger Logger) ([]string, error) {
-	teamCache := map[football.TeamId]football.Team{}
-	playerIds := map[football.PlayerId]struct{}{}
-	players := football.Players{}
+// download starts downloading all teams.
+func (env environment) download() <-chan download.Team {
+	downloadOpts := []download.Option{
+		download.Timeout(time.Duration(timeout) * time.Second),
+		download.Workers(workers),
+	}
 
-	logger.Printf("Initializing entry creating for %v\n", names)
+	if env.registry != nil {
+		downloadOpts = append(downloadOpts, download.Observe(metrics.NewDownloadObserver(env.registry)))
+	}
 
-	for _, n := range names {
-		team, err := repo.GetTeamByName(n)
-		if err != nil {
-			return nil, err
+	if env.tracer != nil {
+		downloadOpts = append(downloadOpts, download.Observe(tracing.NewDownloadObserver(env.tracer)))
+	}
+
+	return download.Teams(downloadOpts...)
+}
+
+// newRepository creates the storage selected by the flags. If refresh is
+// true, any persisted data will be replaced by the given one. The progress of
+// the storage initialization is periodically logged, until it is done.
+func (env environment) newRepository(data <-chan download.Team, refresh bool) football.TeamRepository {
+	var repo football.TeamRepository
+
+	if leveldbPath == "" {
+		repo = memory.NewTeamRepository(data, memory.Tracer(env.tracer), memory.Aliases(env.aliases))
+	} else {
+		opts := []goleveldb.Option{
+			goleveldb.Path(leveldbPath), goleveldb.Tracer(env.tracer), goleveldb.Aliases(env.aliases),
+			goleveldb.Encryption(env.key),
+		}
+		if refresh {
+			opts = append(opts, goleveldb.Refresh)
 		}
 
-		logger.Printf("Found team %s\n", n)
-		for _, pid := range team.Players {
-			playerIds[pid] = struct{}{}
+		if readOnly {
+			opts = append(opts, goleveldb.ReadOnly)
 		}
 
-		teamCache[team.Id] = team
+		if tieredRepo {
+			repo = tiered.NewTeamRepository(data, opts...)
+		} else {
+			repo = goleveldb.NewTeamRepository(data, opts...)
+		}
+	}
+
+	if m, ok := repo.(storage.Monitor); ok {
+		go reportProgress(m)
 	}
 
-	for pid := range playerIds {
-		player, err := repo.GetPlayer(pid)
+	return repo
+}
+
+// reportProgress logs the status of the storage every few seconds, until it
+// is initialized.
+func reportProgress(m storage.Monitor) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.Ready():
+			return
+		case <-ticker.C:
+			s := m.Status()
+			elapsed := time.Since(s.StartedAt) / time.Second * time.Second
+
+			if s.Source == "" {
+				log.Printf("Waiting for the storage to open, %s so far\n", elapsed)
+			} else {
+				log.Printf("Waiting for the storage, %d teams and %d players loaded from %s in %s\n",
+					s.Teams, s.Players, s.Source, elapsed)
+			}
+		}
+	}
+}
 
+// decorate wraps the repository with instrumentation and caching.
+func (env environment) decorate(repo football.TeamRepository) football.TeamRepository {
+	if env.registry != nil {
+		repo = metrics.NewTeamRepository(repo, env.registry)
+	}
+
+	if env.tracer != nil {
+		repo = tracing.NewTeamRepository(repo, env.tracer)
+	}
+
+	return cache.NewTeamRepository(repo)
+}
+
+// getPlayers creates the entries of the players matching the query, from the
+// teams with the given names, or all teams if there are no names.
+func getPlayers(repo football.TeamRepository, names []string, query football.PlayerQuery, logger Logger) ([]string, error) {
+	logger.Printf("Initializing entry creating for %v\n", names)
+
+	for _, n := range names {
+		team, err := selectTeam(repo, n)
 		if err != nil {
 			return nil, err
 		}
 
-		players = append(players, player)
+		if team.Name != n && !strings.HasPrefix(n, "#") {
+			log.Printf("Team %q resolved to %q\n", n, team.Name)
+		}
+
+		logger.Printf("Found team %s\n", n)
+		query.Teams = append(query.Teams, team.Id)
+	}
+
+	found, err := storage.QueryPlayers(repo, query)
+	if err != nil {
+		return nil, err
 	}
 
+	players := football.Players(found)
+
 	collator := collate.New(language.English, collate.Loose)
 	collator.Sort(players)
 
+	teamNames, err := getTeamNames(repo, players)
+	if err != nil {
+		return nil, err
+	}
+
 	entries := make([]string, len(players))
 
 	for i, p := range players {
-		teamNames := []string{}
+		logger.Printf("Generating entry for player %s", p.Name)
+		entries[i] = formatPlayer(i+1, p, teamNames)
+	}
+
+	return entries, nil
+}
+
+// getTeamNames looks up the names of all teams of the players at once.
+func getTeamNames(repo football.TeamRepository, players []football.Player) (map[football.TeamId]string, error) {
+	ids := []football.TeamId{}
+	seen := map[football.TeamId]struct{}{}
+
+	for _, p := range players {
 		for _, tid := range p.Teams {
-			if team, ok := teamCache[tid]; ok {
-				teamNames = append(teamNames, team.Name)
-			} else {
-				team, err := repo.GetTeam(tid)
-				if err != nil {
-					return nil, err
-				}
-				teamNames = append(teamNames, team.Name)
+			if _, ok := seen[tid]; !ok {
+				seen[tid] = struct{}{}
+				ids = append(ids, tid)
 			}
 		}
+	}
 
-		sort.Strings(teamNames)
+	teams, missing, err := storage.GetTeams(repo, ids)
+	if err != nil {
+		return nil, err
+	}
 
-		logger.Printf("Generating entry for player %s", p.Name)
-		entries[i] = fmt.Sprintf("%d. %s; %d; %s", i+1, p.Name, p.Age, strings.Join(teamNames, ", "))
+	if len(missing) > 0 {
+		return nil, errors.Errorf("missing teams %v", missing)
 	}
 
-	return entries, nil
+	names := make(map[football.TeamId]string, len(teams))
+	for _, t := range teams {
+		names[t.Id] = t.Name
+	}
+
+	return names, nil
+}
+
+// formatPlayer creates the numbered entry of a player, listing the sorted
+// names of the player's teams.
+func formatPlayer(n int, p football.Player, names map[football.TeamId]string) string {
+	teamNames := make([]string, len(p.Teams))
+	for i, tid := range p.Teams {
+		teamNames[i] = names[tid]
+	}
+
+	sort.Strings(teamNames)
+
+	return fmt.Sprintf("%d. %s; %d; %s", n, p.Name, p.Age, strings.Join(teamNames, ", "))
+}
+
+// playerQuery creates the player query from the filter flags.
+func playerQuery() football.PlayerQuery {
+	return football.PlayerQuery{
+		MinAge:   minAge,
+		MaxAge:   maxAge,
+		National: nationalPlayers,
+		Club:     clubPlayers,
+		MinTeams: minTeams,
+	}
+}
+
+// selectTeam looks for the team given either a name or an id prefixed by '#'.
+// Teams sharing a name are narrowed down by the -national and -club flags,
+// and an error listing the candidates is returned if more than one remains.
+func selectTeam(repo football.TeamRepository, name string) (football.Team, error) {
+	if strings.HasPrefix(name, "#") {
+		id, err := strconv.Atoi(name[1:])
+		if err != nil {
+			return football.Team{}, errors.Wrapf(err, "parsing team id %s", name)
+		}
+
+		return repo.GetTeam(football.TeamId(id))
+	}
+
+	teams, err := storage.GetTeamsByName(repo, name)
+	if storage.IsNotFound(err) {
+		return football.Team{}, suggest(repo, name, err)
+	} else if err != nil {
+		return football.Team{}, err
+	}
+
+	candidates := teams[:0:0]
+	for _, t := range teams {
+		if national && !t.IsNational || club && t.IsNational {
+			continue
+		}
+
+		candidates = append(candidates, t)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return football.Team{}, storage.NotFound(storage.EntityTeam, name, errors.Errorf("no %s team", teamKind(national)))
+	case 1:
+		return candidates[0], nil
+	}
+
+	desc := make([]string, len(candidates))
+	for i, t := range candidates {
+		desc[i] = fmt.Sprintf("#%d %s (%s)", t.Id, t.Name, teamKind(t.IsNational))
+	}
+
+	return football.Team{}, storage.Ambiguous(storage.EntityTeam, name, errors.Errorf(
+		"use -national, -club or one of: %s", strings.Join(desc, ", ")))
+}
+
+// suggest replaces the not-found error of a team name with one listing the
+// closest matching teams, if there are any.
+func suggest(repo football.TeamRepository, name string, notFound error) error {
+	matches, err := storage.Search(repo, name, 5)
+	if err != nil || len(matches) == 0 {
+		return notFound
+	}
+
+	names := []string{}
+	seen := map[string]struct{}{}
+	for _, t := range matches {
+		if _, ok := seen[t.Name]; !ok {
+			seen[t.Name] = struct{}{}
+			names = append(names, fmt.Sprintf("%q", t.Name))
+		}
+	}
+
+	return storage.NotFound(storage.EntityTeam, name, errors.Errorf("did you mean %s?", strings.Join(names, ", ")))
+}
+
+func teamKind(isNational bool) string {
+	if isNational {
+		return "national"
+	}
+
+	return "club"
+}
+
+// newTracer creates a tracer that writes JSON spans to the trace path, or
+// stderr if the path is "-". No tracer is created if the path is empty. The
+// returned function flushes and closes the trace file, once tracing is done.
+func newTracer() (*tracing.Tracer, func() error, error) {
+	switch tracePath {
+	case "":
+		return nil, func() error { return nil }, nil
+	case "-":
+		return tracing.New(tracing.NewJSONExporter(os.Stderr)), func() error { return nil }, nil
+	default:
+		f, err := os.Create(tracePath)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "creating trace file")
+		}
+
+		w := bufio.NewWriter(f)
+		closeTrace := func() error {
+			if err := w.Flush(); err != nil {
+				f.Close()
+				return errors.Wrap(err, "writing trace file")
+			}
+
+			return errors.Wrap(f.Close(), "closing trace file")
+		}
+
+		return tracing.New(tracing.NewJSONExporter(w)), closeTrace, nil
+	}
+}
+
+func serveMetrics(registry *metrics.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+
+	go func() {
+		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+			log.Printf("Error serving metrics: %+v", err)
+		}
+	}()
 }
 
 func usage() {
@@ -138,12 +515,95 @@ func usage() {
 	fmt.Fprintf(os.Stderr, `Usage of %[1]s
 
 	%[1]s  [team names...]
+	%[1]s  export [-format ndjson|csv|json] [-o file]
+	%[1]s  import [-format ndjson|csv|json] [file]
+	%[1]s  refresh
+	%[1]s  watch [-interval duration]
+	%[1]s  player [-limit n] player name
+	%[1]s  teams [-page-size n]
+	%[1]s  squad [-at date] team name
+	%[1]s  teammates player name
+	%[1]s  connect "player name" "player name"
+	%[1]s  clubs [-limit n]
+	%[1]s  diff [-format text|json] [-dump-format ndjson|csv|json] old new
+	%[1]s  db check|repair|stats|compact|prune
+	%[1]s  db reencrypt [-new-key-file file] [-decrypt]
 
 team-players extracts all players from the given teams and prints them out in
 alphabetical order, including their age and affiliated teams. If no team namess
 are given, the following ones will be used:
 %s
 
+Teams sharing a name can be told apart with the -national and -club flags, or
+selected by id, as in '#1'.
+
+The listed players can be filtered with the -min-age, -max-age, -min-teams,
+-national-players and -club-players flags. If any filter is given without team
+names, the players of all teams are considered.
+
+The export command dumps all teams, players and their memberships from the
+storage. The import command loads such a dump into the storage given with
+-leveldb-path, without downloading any data.
+
+With -leveldb-path, the data is downloaded again once it is over eight days
+old, keeping only the team history of the previous data. The refresh command
+downloads it right away. Any number of processes can use the same
+-leveldb-path meanwhile, and pick up the refreshed data once it is complete.
+With -read-only, the stored data is never refreshed, even if it is missing or
+stale.
+
+The watch command prints the teams added, removed or renamed, and the players
+joining or leaving teams, renamed or changing age, until interrupted. With
+-leveldb-path, these are the changes of the data refreshed by any process,
+while otherwise the teams are downloaded again every -interval.
+
+The player command prints the age and teams of the players with the given
+name, or of the closest matching players if there are none.
+
+The teams command prints all stored teams in alphabetical order, along with
+their ids and kind. It can be narrowed down with the -national and -club flags.
+
+The squad command prints the players of the team with the given name, or id
+prefixed by '#'. With -leveldb-path, the squad as it was stored at an earlier
+date or time can be printed with -at, including teams since renamed or
+removed.
+
+The teammates command prints the players who have shared a team with the
+given player. The connect command prints the shortest chain of teammates
+linking two players, along with their degrees of separation. Players sharing a
+name can be selected by id, as in '#6'. The clubs command prints the clubs
+sharing players with the most other teams.
+
+The diff command reports the transfers, new and removed players, renamed teams
+and squad size changes between two datasets, each either a goleveldb path or an
+exported dump.
+
+The db check command verifies that every record of the goleveldb storage
+decodes, that its name indices refer to existing teams and players, and that
+teams and players list each other exactly once. The db repair command fixes
+these issues, recovering corrupt database files first.
+
+The db stats command prints the current generation, the record counts of each
+key prefix, the size, the last update time and the index version of the
+goleveldb storage. The db compact command compacts the storage, discarding
+deleted and overwritten records. The db prune command removes any teams
+missing from the latest refresh, players no longer listed by any team and index
+entries of earlier team names, as left behind by earlier versions or repairs,
+while keeping the team history.
+
+With -encryption-key-file, or the hex or base64 encoded key in the
+TEAM_PLAYERS_ENCRYPTION_KEY environment variable, the team, player and history
+records of the goleveldb storage are encrypted with AES-256-GCM, and the names
+in its indices are replaced by their HMAC-SHA256. The db reencrypt command
+encrypts the stored data with the key from -new-key-file or the
+TEAM_PLAYERS_NEW_ENCRYPTION_KEY environment variable instead, or decrypts it
+with -decrypt. Processes using the storage meanwhile keep serving the data they
+have, until restarted with the new key.
+
+The exit code is 3 if a team or player is not found, 4 if a team name is
+ambiguous, 5 if the storage isn't ready in time, 6 if the storage is
+unavailable, 7 if the stored data is corrupt, and 1 for any other error.
+
 `, os.Args[0], defs.String())
 
 	flag.PrintDefaults()
@@ -154,6 +614,19 @@ func init() {
 	flag.IntVar(&timeout, "timeout", 10, "network request timeout, in seconds")
 	flag.BoolVar(&verbose, "v", false, "verbose outout")
 	flag.StringVar(&leveldbPath, "leveldb-path", "", "if specified, leveldb will be used to cache the team download")
+	flag.BoolVar(&tieredRepo, "tiered", false, "if specified along with -leveldb-path, queries will be served from memory, warmed up from the leveldb cache whenever it is refreshed")
+	flag.BoolVar(&readOnly, "read-only", false, "if specified along with -leveldb-path, the leveldb cache will only be read, and switched to once refreshed by another process")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "if specified, metrics will be served in the Prometheus text format on this address, under /metrics")
+	flag.StringVar(&tracePath, "trace", "", "if specified, trace spans will be written as JSON lines to this file, or stderr if '-'")
+	flag.StringVar(&keyPath, "encryption-key-file", "", "if specified along with -leveldb-path, the leveldb cache will be encrypted with the hex or base64 encoded 32 byte key in this file, taking precedence over "+keyEnv)
+	flag.StringVar(&aliasPath, "aliases", "", "if specified, team name aliases will be read from this file, one 'alias = team name' per line")
+	flag.BoolVar(&national, "national", false, "if specified, only national teams will match the given team names")
+	flag.BoolVar(&club, "club", false, "if specified, only club teams will match the given team names")
+	flag.IntVar(&minAge, "min-age", 0, "if specified, only players at least this old will be listed")
+	flag.IntVar(&maxAge, "max-age", 0, "if specified, only players at most this old will be listed")
+	flag.IntVar(&minTeams, "min-teams", 0, "if specified, only players of at least this many teams will be listed")
+	flag.BoolVar(&nationalPlayers, "national-players", false, "if specified, only players of a national team will be listed")
+	flag.BoolVar(&clubPlayers, "club-players", false, "if specified, only players of a club team will be listed")
 	flag.Usage = usage
 	flag.Parse()
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
+)
+
+func findPlayer(env environment, args []string) error {
+	fs := flag.NewFlagSet("player", flag.ExitOnError)
+	limit := fs.Int("limit", 10, "maximum number of closest matching players")
+	fs.Parse(args)
+
+	name := strings.Join(fs.Args(), " ")
+	if name == "" {
+		return errors.New("no player name given")
+	}
+
+	repo := env.decorate(env.newRepository(env.download(), false))
+	defer repo.Close()
+
+	players, err := storage.SearchPlayers(repo, name, *limit)
+	if err != nil {
+		return errors.Wrap(err, "searching players")
+	}
+
+	if len(players) == 0 {
+		return storage.NotFound(storage.EntityPlayer, name, nil)
+	}
+
+	if exact := exactPlayers(players, name); len(exact) > 0 {
+		players = exact
+	} else {
+		env.logger.Printf("No player named %s, showing the closest matches\n", name)
+	}
+
+	names, err := getTeamNames(repo, players)
+	if err != nil {
+		return err
+	}
+
+	for i, p := range players {
+		fmt.Println(formatPlayer(i+1, p, names))
+	}
+
+	return nil
+}
+
+// exactPlayers returns the players whose names loosely equal the given one.
+func exactPlayers(players []football.Player, name string) []football.Player {
+	normalized := alias.Normalize(name)
+
+	exact := []football.Player{}
+	for _, p := range players {
+		if alias.Normalize(p.Name) == normalized {
+			exact = append(exact, p)
+		}
+	}
+
+	return exact
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/storage"
+)
+
+func refreshData(env environment, args []string) error {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	fs.Parse(args)
+
+	if leveldbPath == "" {
+		return errors.New("the refresh command requires -leveldb-path")
+	}
+
+	if readOnly {
+		return errors.New("the refresh command cannot be used with -read-only")
+	}
+
+	opened := env.newRepository(env.download(), true)
+	defer opened.Close()
+
+	repo, ok := opened.(storage.Monitor)
+	if !ok {
+		return errors.New("storage doesn't support refreshing")
+	}
+
+	<-repo.Ready()
+	if err := repo.Err(); err != nil {
+		return err
+	}
+
+	s := repo.Status()
+	fmt.Printf("Refreshed %d teams and %d players\n", s.Teams, s.Players)
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+func listSquad(env environment, args []string) error {
+	fs := flag.NewFlagSet("squad", flag.ExitOnError)
+	at := fs.String("at", "", "date, as 2006-01-02, or time, as RFC 3339, of the listed squad instead of the current one")
+	fs.Parse(args)
+
+	name := strings.Join(fs.Args(), " ")
+	if name == "" {
+		return errors.New("no team name given")
+	}
+
+	repo := env.newRepository(env.download(), false)
+	defer repo.Close()
+
+	if *at != "" {
+		return listPastSquad(repo, name, *at)
+	}
+
+	team, err := selectTeam(repo, name)
+	if err != nil {
+		return err
+	}
+
+	found, missing, err := storage.GetPlayers(repo, team.Players)
+	if err != nil {
+		return errors.Wrap(err, "getting players")
+	}
+
+	for _, id := range missing {
+		log.Printf("Player %s of %s is no longer stored\n", id, team.Name)
+	}
+
+	names, err := getTeamNames(repo, found)
+	if err != nil {
+		return err
+	}
+
+	printSquad(team, found, names)
+
+	return nil
+}
+
+// listPastSquad prints the squad of the team as it was stored at the given
+// time, with the names and ages of its players as they were then.
+func listPastSquad(repo football.TeamRepository, name string, at string) error {
+	historian, ok := repo.(storage.Historian)
+	if !ok {
+		return errors.New("storage doesn't keep the team history, use -leveldb-path")
+	}
+
+	t, err := parseTime(at)
+	if err != nil {
+		return err
+	}
+
+	past, err := selectPastTeam(repo, historian, name, t)
+	if err != nil {
+		return errors.Wrapf(err, "getting squad of %s at %s", name, at)
+	}
+
+	team, players := past.Team, past.Players
+
+	// Versions stored before the players were kept only list their ids.
+	if players == nil {
+		found, missing, err := storage.GetPlayers(repo, team.Players)
+		if err != nil {
+			return errors.Wrap(err, "getting players")
+		}
+
+		for _, id := range missing {
+			log.Printf("Player %s of %s is no longer stored\n", id, team.Name)
+		}
+
+		players = found
+	}
+
+	// The players are listed with the team, rather than their current teams.
+	squad := make([]football.Player, len(players))
+	for i, p := range players {
+		p.Teams = []football.TeamId{team.Id}
+		squad[i] = p
+	}
+
+	printSquad(team, squad, map[football.TeamId]string{team.Id: team.Name})
+
+	return nil
+}
+
+// selectPastTeam looks for the version at the given time of the team given
+// either a name or an id prefixed by '#'. A name is looked up among the
+// current teams first, and then among the stored versions, so that renamed and
+// removed teams are found as well.
+func selectPastTeam(repo football.TeamRepository, historian storage.Historian, name string, at time.Time) (storage.TeamVersion, error) {
+	if strings.HasPrefix(name, "#") {
+		id, err := strconv.Atoi(name[1:])
+		if err != nil {
+			return storage.TeamVersion{}, errors.Wrapf(err, "parsing team id %s", name)
+		}
+
+		return historian.GetTeamAt(football.TeamId(id), at)
+	}
+
+	team, notFound := selectTeam(repo, name)
+	if notFound == nil {
+		return historian.GetTeamAt(team.Id, at)
+	} else if !storage.IsNotFound(notFound) {
+		return storage.TeamVersion{}, notFound
+	}
+
+	ids, err := historian.GetTeamIdsByPastName(name)
+	if storage.IsNotFound(err) {
+		return storage.TeamVersion{}, notFound
+	} else if err != nil {
+		return storage.TeamVersion{}, err
+	}
+
+	candidates := []storage.TeamVersion{}
+	for _, id := range ids {
+		v, err := historian.GetTeamAt(id, at)
+		if storage.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return storage.TeamVersion{}, err
+		}
+
+		if national && !v.Team.IsNational || club && v.Team.IsNational {
+			continue
+		}
+
+		candidates = append(candidates, v)
+	}
+
+	switch len(candidates) {
+	case 0:
+		return storage.TeamVersion{}, storage.NotFound(storage.EntityTeam, name, errors.Errorf("no version at %s", at))
+	case 1:
+		return candidates[0], nil
+	}
+
+	desc := make([]string, len(candidates))
+	for i, v := range candidates {
+		desc[i] = fmt.Sprintf("#%d %s (%s)", v.Team.Id, v.Team.Name, teamKind(v.Team.IsNational))
+	}
+
+	return storage.TeamVersion{}, storage.Ambiguous(storage.EntityTeam, name, errors.Errorf(
+		"use -national, -club or one of: %s", strings.Join(desc, ", ")))
+}
+
+// printSquad prints the team, followed by its players in alphabetical order.
+func printSquad(team football.Team, found []football.Player, names map[football.TeamId]string) {
+	players := football.Players(found)
+
+	collator := collate.New(language.English, collate.Loose)
+	collator.Sort(players)
+
+	fmt.Printf("%s (#%d, %s)\n", team.Name, team.Id, teamKind(team.IsNational))
+	for i, p := range players {
+		fmt.Println(formatPlayer(i+1, p, names))
+	}
+}
+
+// parseTime parses either a date, denoting the end of that day in the local
+// time zone, or an RFC 3339 time.
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return d.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	return t, errors.Wrapf(err, "parsing time %s", s)
+}
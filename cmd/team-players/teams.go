@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+func listTeams(env environment, args []string) error {
+	fs := flag.NewFlagSet("teams", flag.ExitOnError)
+	pageSize := fs.Int("page-size", 100, "number of teams fetched from the storage at once")
+	fs.Parse(args)
+
+	if *pageSize < 1 {
+		return errors.New("the page size must be positive")
+	}
+
+	repo := env.decorate(env.newRepository(env.download(), false))
+	defer repo.Close()
+
+	teams := football.Teams{}
+	for cursor := ""; ; {
+		page, next, err := storage.ListTeams(repo, cursor, *pageSize)
+		if err != nil {
+			return errors.Wrap(err, "listing teams")
+		}
+
+		env.logger.Printf("Listed %d teams after %q\n", len(page), cursor)
+
+		for _, t := range page {
+			if national && !t.IsNational || club && t.IsNational {
+				continue
+			}
+
+			teams = append(teams, t)
+		}
+
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	collator := collate.New(language.English, collate.Loose)
+	collator.Sort(teams)
+
+	for _, t := range teams {
+		fmt.Printf("%s (#%d, %s)\n", t.Name, t.Id, teamKind(t.IsNational))
+	}
+
+	return nil
+}
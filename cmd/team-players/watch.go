@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/memory"
+)
+
+func watchChanges(env environment, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Hour, "how often the teams are downloaded again, without -leveldb-path")
+	fs.Parse(args)
+
+	if *interval <= 0 {
+		return errors.New("the interval has to be positive")
+	}
+
+	var opened football.TeamRepository
+	if leveldbPath == "" {
+		opened = memory.NewTeamRepository(env.download(), memory.Tracer(env.tracer), memory.Aliases(env.aliases),
+			memory.Refresh(*interval, env.download))
+		go reportProgress(opened.(storage.Monitor))
+	} else {
+		opened = env.newRepository(env.download(), false)
+	}
+	defer opened.Close()
+
+	repo, ok := opened.(storage.Watcher)
+	if !ok {
+		return errors.New("storage doesn't support watching")
+	}
+
+	if m, ok := opened.(storage.Monitor); ok {
+		<-m.Ready()
+		if err := m.Err(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+
+	go func() {
+		select {
+		case <-interrupted:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	changes := repo.Subscribe(ctx)
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	reported := ""
+	for {
+		select {
+		case c, ok := <-changes:
+			if !ok {
+				return nil
+			}
+
+			fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), formatChange(c))
+		case <-ticker.C:
+			reported = reportStale(opened, reported)
+		}
+	}
+}
+
+// reportStale logs why the storage cannot serve its newer data, unless the
+// same reason has already been reported. It returns the reported reason.
+func reportStale(repo football.TeamRepository, reported string) string {
+	m, ok := repo.(storage.Monitor)
+	if !ok {
+		return reported
+	}
+
+	reason := ""
+	if err := m.Status().Err; err != nil {
+		reason = err.Error()
+	}
+
+	if reason != "" && reason != reported {
+		log.Printf("Serving older data, as the newer stored data cannot be served: %s\n", reason)
+	}
+
+	return reason
+}
+
+// formatChange describes the change on a single line.
+func formatChange(c football.Change) string {
+	team := fmt.Sprintf("%s (#%d, %s)", c.Team.Name, c.Team.Id, teamKind(c.Team.IsNational))
+	player := fmt.Sprintf("%s (#%s)", c.Player.Name, c.Player.Id)
+
+	switch c.Kind {
+	case football.TeamAdded, football.TeamRemoved:
+		return fmt.Sprintf("%s: %s", c.Kind, team)
+	case football.TeamRenamed:
+		return fmt.Sprintf("%s: %s to %s", c.Kind, c.PreviousName, team)
+	case football.PlayerJoined, football.PlayerLeft:
+		return fmt.Sprintf("%s: %s, %s", c.Kind, player, team)
+	case football.PlayerRenamed:
+		return fmt.Sprintf("%s: %s to %s", c.Kind, c.PreviousName, player)
+	case football.PlayerAgeChanged:
+		return fmt.Sprintf("%s: %s, %d to %d", c.Kind, player, c.PreviousAge, c.Player.Age)
+	}
+
+	return c.Kind.String()
+}
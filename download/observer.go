@@ -0,0 +1,37 @@
+package download
+
+import "time"
+
+// Observer receives notifications about the progress of a download. Its
+// methods are called concurrently from the download workers, and must not
+// block.
+type Observer interface {
+	// Request is called before a team is requested.
+	Request(id int)
+	// Response is called once a request has finished. The status is 0 if
+	// no response was received.
+	Response(id int, status int, elapsed time.Duration)
+	// Retry is called when a request is rescheduled due to an error.
+	Retry(id int)
+}
+
+// observers notifies all of its observers in turn.
+type observers []Observer
+
+func (o observers) Request(id int) {
+	for _, obs := range o {
+		obs.Request(id)
+	}
+}
+
+func (o observers) Response(id int, status int, elapsed time.Duration) {
+	for _, obs := range o {
+		obs.Response(id, status, elapsed)
+	}
+}
+
+func (o observers) Retry(id int) {
+	for _, obs := range o {
+		obs.Retry(id)
+	}
+}
@@ -10,7 +10,7 @@ type feedback struct {
 	retry bool
 }
 
-func sequence(problemFeedback <-chan feedback) <-chan int {
+func sequence(problemFeedback <-chan feedback, observer Observer) <-chan int {
 	ids := make(chan int)
 
 	go func() {
@@ -38,6 +38,7 @@ func sequence(problemFeedback <-chan feedback) <-chan int {
 						// A network error will likely manifest again unless we
 						// give it some time to breathe.
 						time.Sleep(50 * time.Millisecond)
+						observer.Retry(p.id)
 						ids <- p.id
 					}
 				} else {
@@ -19,9 +19,10 @@ var (
 )
 
 type options struct {
-	endpoint string
-	timeout  time.Duration
-	workers  int
+	endpoint  string
+	timeout   time.Duration
+	workers   int
+	observers observers
 }
 
 // Option represents the options for the downloader
@@ -56,6 +57,13 @@ func Workers(count int) Option {
 	}}
 }
 
+// Observe adds an observer that will be notified of every request
+func Observe(observer Observer) Option {
+	return Option{func(o *options) {
+		o.observers = append(o.observers, observer)
+	}}
+}
+
 // Teams downloads team data from the endpoint ands passes it through the
 // returned channel. The later is closed once it is determined that there are
 // no more teams to download.
@@ -69,7 +77,7 @@ func Teams(opts ...Option) <-chan Team {
 	problemFeedback := make(chan feedback, o.workers)
 	data := make(chan Team)
 
-	ids := sequence(problemFeedback)
+	ids := sequence(problemFeedback, o.observers)
 
 	client := http.Client{Timeout: o.timeout}
 
@@ -79,7 +87,7 @@ func Teams(opts ...Option) <-chan Team {
 	for i := 0; i < o.workers; i++ {
 		go func() {
 			for id := range ids {
-				b, err := getTeam(client, o.endpoint, id)
+				b, err := getTeam(client, o.endpoint, id, o.observers)
 				if err != nil {
 					if err == errNotFound {
 						problemFeedback <- feedback{id, false}
@@ -102,13 +110,21 @@ func Teams(opts ...Option) <-chan Team {
 	return data
 }
 
-func getTeam(client http.Client, url string, id int) (b []byte, err error) {
+func getTeam(client http.Client, url string, id int, observer Observer) (b []byte, err error) {
+	observer.Request(id)
+	start := time.Now()
+
 	var resp *http.Response
 	resp, err = client.Get(fmt.Sprintf(url, id))
 	if err != nil {
+		observer.Response(id, 0, time.Since(start))
 		return
 	}
 
+	defer func() {
+		observer.Response(id, resp.StatusCode, time.Since(start))
+	}()
+
 	defer func() {
 		if e := resp.Body.Close(); e != nil && err == nil {
 			err = errors.Wrap(e, "closing body")
@@ -119,7 +135,7 @@ func getTeam(client http.Client, url string, id int) (b []byte, err error) {
 		if resp.StatusCode == http.StatusNotFound {
 			return b, errNotFound
 		} else {
-			return b, errors.Errorf("response %d", resp.Request)
+			return b, errors.Errorf("response %d", resp.StatusCode)
 		}
 	}
 
@@ -0,0 +1,165 @@
+package football
+
+import "sort"
+
+// ChangeKind is the kind of a change between two versions of the teams and
+// players.
+type ChangeKind int
+
+const (
+	// TeamAdded denotes a new team.
+	TeamAdded ChangeKind = iota + 1
+	// TeamRemoved denotes a team that no longer exists.
+	TeamRemoved
+	// TeamRenamed denotes a team whose name has changed.
+	TeamRenamed
+	// PlayerJoined denotes a player who became a member of a team.
+	PlayerJoined
+	// PlayerLeft denotes a player who is no longer a member of a team.
+	PlayerLeft
+	// PlayerRenamed denotes a player whose name has changed.
+	PlayerRenamed
+	// PlayerAgeChanged denotes a player whose age has changed.
+	PlayerAgeChanged
+)
+
+// Change describes a single difference between two versions of the teams and
+// players.
+type Change struct {
+	Kind ChangeKind
+	// Team is the added, removed or renamed team, or the one joined or left
+	// by the player. Removed teams are given as they were last known.
+	Team Team
+	// Player is the player who joined or left the team, or was renamed or
+	// changed age. Players no longer known are given as they were last
+	// known.
+	Player Player
+	// PreviousName is the name of the renamed team or player before the
+	// change.
+	PreviousName string
+	// PreviousAge is the age of the player before the change.
+	PreviousAge int
+}
+
+var changeKinds = map[ChangeKind]string{
+	TeamAdded:        "team added",
+	TeamRemoved:      "team removed",
+	TeamRenamed:      "team renamed",
+	PlayerJoined:     "player joined",
+	PlayerLeft:       "player left",
+	PlayerRenamed:    "player renamed",
+	PlayerAgeChanged: "player age changed",
+}
+
+func (k ChangeKind) String() string {
+	if s, ok := changeKinds[k]; ok {
+		return s
+	}
+
+	return "unknown change"
+}
+
+// Changes compares the old and new versions of the teams and players. Team
+// changes come first, ordered by team id, followed by player changes, ordered
+// by player id and then team id.
+func Changes(oldTeams, newTeams map[TeamId]Team, oldPlayers, newPlayers map[PlayerId]Player) []Change {
+	changes := []Change{}
+
+	teamIds := []int{}
+	for id := range oldTeams {
+		teamIds = append(teamIds, int(id))
+	}
+	for id := range newTeams {
+		if _, ok := oldTeams[id]; !ok {
+			teamIds = append(teamIds, int(id))
+		}
+	}
+	sort.Ints(teamIds)
+
+	for _, id := range teamIds {
+		o, wasOld := oldTeams[TeamId(id)]
+		n, isNew := newTeams[TeamId(id)]
+
+		switch {
+		case !wasOld:
+			changes = append(changes, Change{Kind: TeamAdded, Team: n})
+		case !isNew:
+			changes = append(changes, Change{Kind: TeamRemoved, Team: o})
+		case o.Name != n.Name:
+			changes = append(changes, Change{Kind: TeamRenamed, Team: n, PreviousName: o.Name})
+		}
+	}
+
+	playerIds := []string{}
+	for id := range oldPlayers {
+		playerIds = append(playerIds, string(id))
+	}
+	for id := range newPlayers {
+		if _, ok := oldPlayers[id]; !ok {
+			playerIds = append(playerIds, string(id))
+		}
+	}
+	sort.Strings(playerIds)
+
+	team := func(id TeamId) Team {
+		if t, ok := newTeams[id]; ok {
+			return t
+		}
+
+		return oldTeams[id]
+	}
+
+	for _, id := range playerIds {
+		o, wasOld := oldPlayers[PlayerId(id)]
+		n, isNew := newPlayers[PlayerId(id)]
+
+		current := n
+		if !isNew {
+			current = o
+		}
+
+		if wasOld && isNew {
+			if o.Name != n.Name {
+				changes = append(changes, Change{Kind: PlayerRenamed, Player: n, PreviousName: o.Name})
+			}
+
+			if o.Age != n.Age {
+				changes = append(changes, Change{Kind: PlayerAgeChanged, Player: n, PreviousAge: o.Age})
+			}
+		}
+
+		for _, tid := range teamDiff(o.Teams, n.Teams) {
+			changes = append(changes, Change{Kind: PlayerLeft, Team: team(tid), Player: current})
+		}
+
+		for _, tid := range teamDiff(n.Teams, o.Teams) {
+			changes = append(changes, Change{Kind: PlayerJoined, Team: team(tid), Player: current})
+		}
+	}
+
+	return changes
+}
+
+// teamDiff returns the sorted ids of the teams in a, but not in b.
+func teamDiff(a, b []TeamId) []TeamId {
+	in := map[TeamId]bool{}
+	for _, id := range b {
+		in[id] = true
+	}
+
+	diff := []int{}
+	for _, id := range a {
+		if !in[id] {
+			diff = append(diff, int(id))
+			in[id] = true
+		}
+	}
+	sort.Ints(diff)
+
+	ids := make([]TeamId, len(diff))
+	for i, id := range diff {
+		ids[i] = TeamId(id)
+	}
+
+	return ids
+}
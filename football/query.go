@@ -0,0 +1,75 @@
+package football
+
+// PlayerQuery filters players. The zero value of each field disables the
+// respective filter, so the zero query matches all players.
+type PlayerQuery struct {
+	// MinAge and MaxAge bound the age of the players, inclusively.
+	MinAge int
+	MaxAge int
+	// National matches players of at least one national team.
+	National bool
+	// Club matches players of at least one club team.
+	Club bool
+	// MinTeams is the minimum number of teams of the players.
+	MinTeams int
+	// Teams matches players of at least one of the given teams.
+	Teams []TeamId
+}
+
+// Match checks whether the player satisfies the query. The isNational
+// function reports whether a team of the player is a national one.
+func (q PlayerQuery) Match(p Player, isNational func(id TeamId) bool) bool {
+	if q.MinAge > 0 && p.Age < q.MinAge || q.MaxAge > 0 && p.Age > q.MaxAge {
+		return false
+	}
+
+	if len(p.Teams) < q.MinTeams {
+		return false
+	}
+
+	if len(q.Teams) > 0 && !q.memberOf(p) {
+		return false
+	}
+
+	if !q.National && !q.Club {
+		return true
+	}
+
+	national, club := false, false
+	for _, tid := range p.Teams {
+		if isNational(tid) {
+			national = true
+		} else {
+			club = true
+		}
+	}
+
+	return (!q.National || national) && (!q.Club || club)
+}
+
+func (q PlayerQuery) memberOf(p Player) bool {
+	for _, tid := range p.Teams {
+		for _, qid := range q.Teams {
+			if tid == qid {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// PlayersById is a player slice sortable by player ids.
+type PlayersById []Player
+
+func (p PlayersById) Len() int {
+	return len(p)
+}
+
+func (p PlayersById) Less(i int, j int) bool {
+	return p[i].Id < p[j].Id
+}
+
+func (p PlayersById) Swap(i int, j int) {
+	p[i], p[j] = p[j], p[i]
+}
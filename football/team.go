@@ -1,5 +1,7 @@
 package football
 
+import "context"
+
 // TeamId is the unique identifier of a team.
 type TeamId int
 
@@ -25,11 +27,17 @@ type Player struct {
 // Players is a player slice alphabetically sortable by player names.
 type Players []Player
 
-// TeamRepository allows queries for teams and players.
+// Teams is a team slice alphabetically sortable by team names.
+type Teams []Team
+
+// TeamRepository allows queries for teams and players. Repositories may
+// support further queries, by implementing any of the Disambiguator,
+// BatchGetter, Searcher, Querier and Lister interfaces.
 type TeamRepository interface {
 	// GetTeams looks for a team given an id.
 	GetTeam(id TeamId) (Team, error)
-	// GetTeamByName looks for a team given a name.
+	// GetTeamByName looks for a team given a name. It fails if more than one
+	// team matches the name.
 	GetTeamByName(name string) (Team, error)
 	// GetPlayer looks for a player given a player id.
 	GetPlayer(id PlayerId) (Player, error)
@@ -37,6 +45,111 @@ type TeamRepository interface {
 	Close() error
 }
 
+// Disambiguator is a TeamRepository that returns all teams sharing a name.
+type Disambiguator interface {
+	TeamRepository
+
+	// GetTeamsByName looks for all teams matching a name, ordered by id.
+	GetTeamsByName(name string) ([]Team, error)
+}
+
+// BatchGetter is a TeamRepository that looks up several teams or players at
+// once.
+type BatchGetter interface {
+	TeamRepository
+
+	// GetTeams looks for the teams with the given ids. The found teams are
+	// returned in the order of their ids, while the ids without a team are
+	// returned separately, without causing an error.
+	GetTeams(ids []TeamId) (teams []Team, missing []TeamId, err error)
+	// GetPlayers looks for the players with the given ids. The found players
+	// are returned in the order of their ids, while the ids without a player
+	// are returned separately, without causing an error.
+	GetPlayers(ids []PlayerId) (players []Player, missing []PlayerId, err error)
+}
+
+// Searcher is a TeamRepository that looks for teams and players loosely
+// matching a query.
+type Searcher interface {
+	TeamRepository
+
+	// Search looks for at most limit teams loosely matching the query, best
+	// matches first.
+	Search(query string, limit int) ([]Team, error)
+	// SearchPlayers looks for at most limit players loosely matching the
+	// query, best matches first.
+	SearchPlayers(query string, limit int) ([]Player, error)
+}
+
+// Querier is a TeamRepository that filters the players by their age and
+// teams.
+type Querier interface {
+	TeamRepository
+
+	// QueryPlayers looks for all players matching the query, ordered by id.
+	QueryPlayers(query PlayerQuery) ([]Player, error)
+}
+
+// Lister is a TeamRepository that lists all of its teams and players, a page
+// at a time.
+type Lister interface {
+	TeamRepository
+
+	// ListTeams returns at most limit teams following the cursor, in a
+	// stable order, along with the cursor of the next page. An empty cursor
+	// denotes the first page, or the lack of a next one.
+	ListTeams(cursor string, limit int) (teams []Team, next string, err error)
+	// ListPlayers returns at most limit players following the cursor, in a
+	// stable order, along with the cursor of the next page. An empty cursor
+	// denotes the first page, or the lack of a next one.
+	ListPlayers(cursor string, limit int) (players []Player, next string, err error)
+}
+
+// ContextTeamRepository is a TeamRepository whose queries give up once the
+// given context is done. The further queries are given up the same way by
+// the ContextDisambiguator, ContextBatchGetter, ContextSearcher,
+// ContextQuerier and ContextLister interfaces.
+type ContextTeamRepository interface {
+	GetTeamContext(ctx context.Context, id TeamId) (Team, error)
+	GetTeamByNameContext(ctx context.Context, name string) (Team, error)
+	GetPlayerContext(ctx context.Context, id PlayerId) (Player, error)
+	// Close frees any resources held by the repository
+	Close() error
+}
+
+// ContextDisambiguator is a Disambiguator whose queries give up once the
+// given context is done.
+type ContextDisambiguator interface {
+	GetTeamsByNameContext(ctx context.Context, name string) ([]Team, error)
+}
+
+// ContextBatchGetter is a BatchGetter whose queries give up once the given
+// context is done.
+type ContextBatchGetter interface {
+	GetTeamsContext(ctx context.Context, ids []TeamId) ([]Team, []TeamId, error)
+	GetPlayersContext(ctx context.Context, ids []PlayerId) ([]Player, []PlayerId, error)
+}
+
+// ContextSearcher is a Searcher whose queries give up once the given context
+// is done.
+type ContextSearcher interface {
+	SearchContext(ctx context.Context, query string, limit int) ([]Team, error)
+	SearchPlayersContext(ctx context.Context, query string, limit int) ([]Player, error)
+}
+
+// ContextQuerier is a Querier whose queries give up once the given context is
+// done.
+type ContextQuerier interface {
+	QueryPlayersContext(ctx context.Context, query PlayerQuery) ([]Player, error)
+}
+
+// ContextLister is a Lister whose queries give up once the given context is
+// done.
+type ContextLister interface {
+	ListTeamsContext(ctx context.Context, cursor string, limit int) ([]Team, string, error)
+	ListPlayersContext(ctx context.Context, cursor string, limit int) ([]Player, string, error)
+}
+
 func (p Players) Len() int {
 	return len(p)
 }
@@ -52,3 +165,19 @@ func (p Players) Swap(i int, j int) {
 func (p Players) Bytes(i int) []byte {
 	return []byte(p[i].Name)
 }
+
+func (t Teams) Len() int {
+	return len(t)
+}
+
+func (t Teams) Less(i int, j int) bool {
+	return t[i].Name < t[j].Name
+}
+
+func (t Teams) Swap(i int, j int) {
+	t[i], t[j] = t[j], t[i]
+}
+
+func (t Teams) Bytes(i int) []byte {
+	return []byte(t[i].Name)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/urandom/team-search-test/download"
+)
+
+type downloadObserver struct {
+	requests  *Counter
+	responses *Counter
+	retries   *Counter
+	latency   *Histogram
+}
+
+// NewDownloadObserver creates a download.Observer that records the number of
+// requests, responses by status code and retries, as well as the request
+// latency, in the registry. Requests that didn't receive a response are
+// recorded with a status code of 0.
+func NewDownloadObserver(reg *Registry) download.Observer {
+	return downloadObserver{
+		requests: reg.Counter("team_players_download_requests_total",
+			"Number of team download requests."),
+		responses: reg.Counter("team_players_download_responses_total",
+			"Number of team download responses by status code.", "code"),
+		retries: reg.Counter("team_players_download_retries_total",
+			"Number of team download requests that were retried."),
+		latency: reg.Histogram("team_players_download_request_duration_seconds",
+			"Latency of team download requests.", DefaultBuckets),
+	}
+}
+
+func (o downloadObserver) Request(id int) {
+	o.requests.Inc()
+}
+
+func (o downloadObserver) Response(id int, status int, elapsed time.Duration) {
+	o.responses.Inc(strconv.Itoa(status))
+	o.latency.Observe(elapsed.Seconds())
+}
+
+func (o downloadObserver) Retry(id int) {
+	o.retries.Inc()
+}
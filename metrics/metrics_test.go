@@ -0,0 +1,120 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/metrics"
+	"github.com/urandom/team-search-test/storage/memory"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	c := reg.Counter("requests_total", "Number of requests.", "code")
+	c.Inc("200")
+	c.Inc("200")
+	c.Inc("404")
+
+	g := reg.Gauge("init_seconds", "Init duration.")
+	g.Set(1.5)
+
+	h := reg.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(2)
+
+	var b bytes.Buffer
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatalf("error writing metrics: %+v", err)
+	}
+
+	expected := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{code="200"} 2
+requests_total{code="404"} 1
+# HELP init_seconds Init duration.
+# TYPE init_seconds gauge
+init_seconds 1.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.55
+latency_seconds_count 3
+`
+
+	if b.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, b.String())
+	}
+}
+
+func TestTeamRepository(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	data := make(chan download.Team, 2)
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 1, "name": "Apoel FC"}}}`), Id: 1}
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 2, "name": "D2"}}}`), Id: 2}
+	close(data)
+
+	repo := metrics.NewTeamRepository(memory.NewTeamRepository(data), reg)
+
+	repo.GetTeam(1)
+	repo.GetTeamByName("Apoel FC")
+	repo.GetTeamByName("sdd")
+	repo.GetPlayer("6")
+
+	var b bytes.Buffer
+	reg.WriteTo(&b)
+
+	for _, line := range []string{
+		`team_players_repository_lookup_duration_seconds_count{method="GetTeam"} 1`,
+		`team_players_repository_lookup_duration_seconds_count{method="GetTeamByName"} 2`,
+		`team_players_repository_not_found_total{method="GetTeamByName"} 1`,
+		`team_players_repository_not_found_total{method="GetPlayer"} 1`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Fatalf("expected %q in:\n%s", line, b.String())
+		}
+	}
+}
+
+func TestDownloadObserver(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.RequestURI, "/1") {
+			w.Write([]byte("1"))
+		} else {
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	reg := metrics.NewRegistry()
+
+	teams := download.Teams(
+		download.Endpoint(ts.URL+"/%d"),
+		download.Timeout(time.Second),
+		download.Observe(metrics.NewDownloadObserver(reg)),
+	)
+	for range teams {
+	}
+
+	var b bytes.Buffer
+	reg.WriteTo(&b)
+
+	for _, line := range []string{
+		`team_players_download_responses_total{code="200"} 1`,
+		`team_players_download_responses_total{code="404"}`,
+		`team_players_download_retries_total`,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Fatalf("expected %q in:\n%s", line, b.String())
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+type repository struct {
+	repo football.TeamRepository
+
+	latency      *Histogram
+	notFound     *Counter
+	initErrors   *Counter
+	initDuration *Gauge
+}
+
+// NewTeamRepository wraps the given repository, recording the latency of
+// every query, along with the number of not-found and initializer errors, in
+// the registry.
+//
+// The initialization duration is measured from the moment the repository is
+// wrapped, until the first query against it returns. The wrapped repository
+// should therefore be instrumented right after it is created. The optional
+// queries are delegated through the storage query functions.
+func NewTeamRepository(repo football.TeamRepository, reg *Registry) football.TeamRepository {
+	r := &repository{
+		repo: repo,
+		latency: reg.Histogram("team_players_repository_lookup_duration_seconds",
+			"Latency of repository queries.", DefaultBuckets, "method"),
+		notFound: reg.Counter("team_players_repository_not_found_total",
+			"Number of repository queries that didn't find an entry.", "method"),
+		initErrors: reg.Counter("team_players_repository_init_errors_total",
+			"Number of repository queries that failed due to an initializer error.", "method"),
+		initDuration: reg.Gauge("team_players_repository_init_duration_seconds",
+			"Time it took the repository to initialize."),
+	}
+
+	start := time.Now()
+	go func() {
+		// Every repository blocks its queries until it is initialized.
+		r.repo.GetTeam(0)
+		r.initDuration.Set(time.Since(start).Seconds())
+	}()
+
+	return r
+}
+
+func (r *repository) GetTeam(id football.TeamId) (football.Team, error) {
+	defer r.observe("GetTeam", time.Now())
+
+	team, err := r.repo.GetTeam(id)
+	r.count("GetTeam", err)
+
+	return team, err
+}
+
+func (r *repository) GetTeamByName(name string) (football.Team, error) {
+	defer r.observe("GetTeamByName", time.Now())
+
+	team, err := r.repo.GetTeamByName(name)
+	r.count("GetTeamByName", err)
+
+	return team, err
+}
+
+func (r *repository) GetTeamsByName(name string) ([]football.Team, error) {
+	defer r.observe("GetTeamsByName", time.Now())
+
+	teams, err := storage.GetTeamsByName(r.repo, name)
+	r.count("GetTeamsByName", err)
+
+	return teams, err
+}
+
+func (r *repository) Search(query string, limit int) ([]football.Team, error) {
+	defer r.observe("Search", time.Now())
+
+	teams, err := storage.Search(r.repo, query, limit)
+	r.count("Search", err)
+
+	return teams, err
+}
+
+func (r *repository) GetPlayer(id football.PlayerId) (football.Player, error) {
+	defer r.observe("GetPlayer", time.Now())
+
+	player, err := r.repo.GetPlayer(id)
+	r.count("GetPlayer", err)
+
+	return player, err
+}
+
+func (r *repository) GetTeams(ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	defer r.observe("GetTeams", time.Now())
+
+	teams, missing, err := storage.GetTeams(r.repo, ids)
+	r.count("GetTeams", err)
+
+	return teams, missing, err
+}
+
+func (r *repository) GetPlayers(ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	defer r.observe("GetPlayers", time.Now())
+
+	players, missing, err := storage.GetPlayers(r.repo, ids)
+	r.count("GetPlayers", err)
+
+	return players, missing, err
+}
+
+func (r *repository) SearchPlayers(query string, limit int) ([]football.Player, error) {
+	defer r.observe("SearchPlayers", time.Now())
+
+	players, err := storage.SearchPlayers(r.repo, query, limit)
+	r.count("SearchPlayers", err)
+
+	return players, err
+}
+
+func (r *repository) ListTeams(cursor string, limit int) ([]football.Team, string, error) {
+	defer r.observe("ListTeams", time.Now())
+
+	teams, next, err := storage.ListTeams(r.repo, cursor, limit)
+	r.count("ListTeams", err)
+
+	return teams, next, err
+}
+
+func (r *repository) ListPlayers(cursor string, limit int) ([]football.Player, string, error) {
+	defer r.observe("ListPlayers", time.Now())
+
+	players, next, err := storage.ListPlayers(r.repo, cursor, limit)
+	r.count("ListPlayers", err)
+
+	return players, next, err
+}
+
+func (r *repository) QueryPlayers(query football.PlayerQuery) ([]football.Player, error) {
+	defer r.observe("QueryPlayers", time.Now())
+
+	players, err := storage.QueryPlayers(r.repo, query)
+	r.count("QueryPlayers", err)
+
+	return players, err
+}
+
+func (r *repository) Close() error {
+	return r.repo.Close()
+}
+
+func (r *repository) observe(method string, start time.Time) {
+	r.latency.Observe(time.Since(start).Seconds(), method)
+}
+
+func (r *repository) count(method string, err error) {
+	switch {
+	case storage.IsNotFound(err):
+		r.notFound.Inc(method)
+	case storage.IsInitializer(err):
+		r.initErrors.Inc(method)
+	}
+}
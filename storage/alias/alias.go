@@ -0,0 +1,267 @@
+// Package alias provides alternative team names, both user maintained and
+// generated ones, for use in the team name indices of the repositories.
+//
+// Alias files contain one alias per line, in the form:
+//
+//	Man Utd = Manchester Utd
+//
+// Empty lines and lines starting with '#' are ignored. Aliases are matched
+// loosely, disregarding case, accents and punctuation.
+package alias
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Set maps user maintained aliases to canonical team names. A nil *Set is
+// valid and contains no aliases.
+type Set struct {
+	names map[string]string
+}
+
+// affixes are the common club designations, which are usually omitted when
+// referring to a team.
+var affixes = map[string]bool{
+	"ac": true, "afc": true, "as": true, "cd": true, "cf": true, "club": true,
+	"fc": true, "fk": true, "sc": true, "sk": true, "ssc": true, "sv": true,
+}
+
+// abbreviations are the words commonly abbreviated in team names, mapped to
+// their alternative forms.
+var abbreviations = map[string][]string{
+	"manchester": {"man"}, "man": {"manchester"},
+	"united": {"utd"}, "utd": {"united"},
+	"saint": {"st"}, "st": {"saint"},
+}
+
+// minShortName is the minimum length of the leading word of a team name, for
+// it to be used as its short name.
+const minShortName = 3
+
+// New creates an empty alias set.
+func New() *Set {
+	return &Set{names: map[string]string{}}
+}
+
+// Load reads an alias set in the alias file format.
+func Load(r io.Reader) (*Set, error) {
+	s := New()
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid alias on line %d: %q", line, text)
+		}
+
+		alias, canonical := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if alias == "" || canonical == "" {
+			return nil, errors.Errorf("invalid alias on line %d: %q", line, text)
+		}
+
+		s.Add(alias, canonical)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading aliases")
+	}
+
+	return s, nil
+}
+
+// LoadFile reads an alias set from the given file.
+func LoadFile(path string) (*Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening alias file")
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// Add adds an alias for the canonical team name.
+func (s *Set) Add(alias, canonical string) {
+	s.names[Normalize(alias)] = canonical
+}
+
+// Canonical returns the canonical team name for the given alias.
+func (s *Set) Canonical(alias string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+
+	name, ok := s.names[Normalize(alias)]
+
+	return name, ok
+}
+
+// Len returns the number of aliases in the set.
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	return len(s.names)
+}
+
+// Normalize folds the case of the name, strips any accents and replaces
+// punctuation with single spaces, such that "Atlético Madrid" and
+// "atletico-madrid" are the same. Dots and apostrophes are dropped, so that
+// abbreviations such as "F.C." become "fc".
+func Normalize(name string) string {
+	t := transform.Chain(
+		norm.NFD,
+		runes.Remove(runes.In(unicode.Mn)),
+		runes.Remove(runes.Predicate(func(r rune) bool {
+			return r == '.' || r == '\'' || r == '’'
+		})),
+		norm.NFC,
+		cases.Fold(),
+	)
+
+	folded, _, err := transform.String(t, name)
+	if err != nil {
+		folded = strings.ToLower(name)
+	}
+
+	return strings.Join(strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+// Forms returns the normalized name, along with the name without any leading
+// or trailing club designation, such as "FC". These are the forms under which
+// a name that was typed in is looked up in the variant index.
+func Forms(name string) []string {
+	normalized := Normalize(name)
+	if normalized == "" {
+		return nil
+	}
+
+	forms := []string{normalized}
+
+	words := strings.Fields(normalized)
+	if len(words) > 1 && affixes[words[0]] {
+		words = words[1:]
+		forms = append(forms, strings.Join(words, " "))
+	}
+
+	if len(words) > 1 && affixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+		forms = append(forms, strings.Join(words, " "))
+	}
+
+	return forms
+}
+
+// Variants generates the normalized alternative names of a team, as stored in
+// the variant index. Besides the forms of the name, they include the forms
+// with commonly abbreviated words swapped, such that "Manchester Utd" is also
+// "Man Utd" and "Manchester United".
+func Variants(name string) []string {
+	forms := Forms(name)
+	if forms == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	variants := make([]string, 0, len(forms))
+	add := func(v string) {
+		if !seen[v] {
+			seen[v] = true
+			variants = append(variants, v)
+		}
+	}
+
+	for _, f := range forms {
+		add(f)
+	}
+
+	for _, f := range forms {
+		for _, v := range abbreviated(strings.Fields(f)) {
+			add(v)
+		}
+	}
+
+	return variants
+}
+
+// Short returns the normalized leading word of a longer team name without its
+// club designations, such that "FC Bayern Munich" is "bayern". It is empty for
+// names of a single word, or too short a leading word. Unlike the variants, the
+// short name is only matched by a name typed as a single word, so that "Apoel
+// FC" doesn't match "Apoel Nicosia".
+func Short(name string) string {
+	forms := Forms(name)
+	if forms == nil {
+		return ""
+	}
+
+	words := strings.Fields(forms[len(forms)-1])
+	if len(words) < 2 || len([]rune(words[0])) < minShortName {
+		return ""
+	}
+
+	return words[0]
+}
+
+// Matches checks whether the typed name refers to a team with the given name,
+// by its exact name, a variant or its short name, as the storages look up
+// names in their indices.
+func Matches(typed string, name string) bool {
+	if typed == name {
+		return true
+	}
+
+	forms := Forms(typed)
+	for _, v := range Variants(name) {
+		for _, f := range forms {
+			if f == v {
+				return true
+			}
+		}
+	}
+
+	short := Short(name)
+
+	return short != "" && short == Normalize(typed)
+}
+
+// abbreviated returns the names made of the words, with the abbreviated words
+// swapped with their long forms and the other way around.
+func abbreviated(words []string) []string {
+	if len(words) == 0 {
+		return []string{""}
+	}
+
+	rest := abbreviated(words[1:])
+	names := make([]string, 0, len(rest)*2)
+
+	for _, w := range append([]string{words[0]}, abbreviations[words[0]]...) {
+		for _, r := range rest {
+			if r == "" {
+				names = append(names, w)
+			} else {
+				names = append(names, w+" "+r)
+			}
+		}
+	}
+
+	return names
+}
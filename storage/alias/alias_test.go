@@ -0,0 +1,139 @@
+package alias_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/urandom/team-search-test/storage/alias"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"Manchester Utd":      "manchester utd",
+		"Atlético  Madrid":    "atletico madrid",
+		"Borussia M'gladbach": "borussia mgladbach",
+		"Apoel F.C.":          "apoel fc",
+		"Paris Saint-Germain": "paris saint germain",
+		"  ":                  "",
+	}
+
+	for name, expected := range cases {
+		if n := alias.Normalize(name); n != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, name, n)
+		}
+	}
+}
+
+func TestForms(t *testing.T) {
+	cases := map[string][]string{
+		"FC Bayern Munich": {"fc bayern munich", "bayern munich"},
+		"Apoel FC":         {"apoel fc", "apoel"},
+		"AC Sparta FC":     {"ac sparta fc", "sparta fc", "sparta"},
+		"FC":               {"fc"},
+		"Germany":          {"germany"},
+		"":                 nil,
+	}
+
+	for name, expected := range cases {
+		if f := alias.Forms(name); !reflect.DeepEqual(f, expected) {
+			t.Fatalf("expected %q for %q, got %q", expected, name, f)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	cases := map[string][]string{
+		"FC Bayern Munich": {"fc bayern munich", "bayern munich"},
+		"Apoel FC":         {"apoel fc", "apoel"},
+		"AC Sparta FC":     {"ac sparta fc", "sparta fc", "sparta"},
+		"Manchester Utd": {
+			"manchester utd", "manchester united", "man utd", "man united",
+		},
+		"Saint-Etienne": {"saint etienne", "st etienne"},
+		"AS Roma":     {"as roma", "roma"},
+		"FC":          {"fc"},
+		"Germany":     {"germany"},
+		"":            nil,
+	}
+
+	for name, expected := range cases {
+		if v := alias.Variants(name); !reflect.DeepEqual(v, expected) {
+			t.Fatalf("expected %q for %q, got %q", expected, name, v)
+		}
+	}
+}
+
+func TestShort(t *testing.T) {
+	cases := map[string]string{
+		"FC Bayern Munich": "bayern",
+		"Manchester Utd":   "manchester",
+		"AS Roma":          "",
+		"FC St Pauli":      "",
+		"Germany":          "",
+		"":                 "",
+	}
+
+	for name, expected := range cases {
+		if s := alias.Short(name); s != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, name, s)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	for _, tc := range []struct {
+		typed, name string
+		matches     bool
+	}{
+		{"Apoel FC", "Apoel FC", true},
+		{"apoel", "Apoel FC", true},
+		{"Man Utd", "Manchester Utd", true},
+		{"Bayern", "FC Bayern Munich", true},
+		{"Apoel FC", "Apoel Nicosia", false},
+		{"Bayern Leverkusen", "FC Bayern Munich", false},
+	} {
+		if m := alias.Matches(tc.typed, tc.name); m != tc.matches {
+			t.Fatalf("expected %q matching %q to be %v", tc.typed, tc.name, tc.matches)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	s, err := alias.Load(strings.NewReader(`
+# Aliases
+Man Utd = Manchester Utd
+Manchester United=Manchester Utd
+  Bayern =  FC Bayern Munich
+`))
+	if err != nil {
+		t.Fatalf("error loading aliases: %+v", err)
+	}
+
+	if s.Len() != 3 {
+		t.Fatalf("expected 3 aliases, got %d", s.Len())
+	}
+
+	for a, expected := range map[string]string{
+		"man utd":           "Manchester Utd",
+		"MANCHESTER UNITED": "Manchester Utd",
+		"Bayern":            "FC Bayern Munich",
+	} {
+		if c, ok := s.Canonical(a); !ok || c != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, a, c)
+		}
+	}
+
+	if _, ok := s.Canonical("Arsenal"); ok {
+		t.Fatalf("expected no canonical name for Arsenal")
+	}
+
+	var empty *alias.Set
+	if _, ok := empty.Canonical("Man Utd"); ok {
+		t.Fatalf("expected no canonical name from a nil set")
+	}
+
+	if _, err := alias.Load(strings.NewReader("Man Utd")); err == nil {
+		t.Fatalf("expected an error for an alias without a canonical name")
+	}
+}
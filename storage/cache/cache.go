@@ -0,0 +1,417 @@
+// Package cache provides a read-through, size bounded caching decorator for
+// any football.TeamRepository.
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+// Repository is a football.TeamRepository that keeps the most recently used
+// teams, players and team name lookups in memory, delegating to the
+// underlying repository on a miss. It implements the optional football
+// interfaces regardless of the underlying repository, whose missing
+// capabilities are handled like the storage query functions do.
+type Repository struct {
+	// Accessed atomically, kept first for 64-bit alignment.
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	repo football.TeamRepository
+	opts options
+
+	mu      sync.Mutex
+	teams   *lru
+	players *lru
+	names   *lru
+	// generation is bumped on every purge, so that lookups started before
+	// it don't repopulate the cache with stale data.
+	generation uint64
+
+	done chan struct{}
+	once sync.Once
+}
+
+// Stats contains the cache counters accumulated since the repository was
+// created.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Entries is the number of currently cached teams, players and names.
+	Entries int
+}
+
+type options struct {
+	size       int
+	negative   bool
+	invalidate <-chan struct{}
+}
+
+// teamsKey distinguishes GetTeamsByName lookups from GetTeamByName ones in
+// the names cache.
+type teamsKey string
+
+// result is the cached outcome of a single lookup. A non-nil err is only ever
+// a not-found error, and is only stored when negative caching is enabled.
+type result struct {
+	value interface{}
+	err   error
+}
+
+var (
+	// NegativeCaching enables caching of not-found results.
+	NegativeCaching Option = negativeCaching
+
+	negativeCaching = Option{func(o *options) {
+		o.negative = true
+	}}
+)
+
+// Option represents the options for the caching repository
+type Option struct {
+	f func(o *options)
+}
+
+// Size sets the maximum number of entries kept for each of the teams,
+// players and team names.
+func Size(size int) Option {
+	return Option{func(o *options) {
+		o.size = size
+	}}
+}
+
+// InvalidateOn purges the cache every time a value is received from the
+// channel. It is meant to be fed by whatever refreshes the underlying
+// repository.
+func InvalidateOn(refreshed <-chan struct{}) Option {
+	return Option{func(o *options) {
+		o.invalidate = refreshed
+	}}
+}
+
+// NewTeamRepository wraps the given repository with a least recently used
+// cache. Only successful lookups are cached by default, initializer errors
+// are never cached.
+//
+// Closing the caching repository closes the underlying one.
+func NewTeamRepository(repo football.TeamRepository, opts ...Option) *Repository {
+	o := options{size: 1000}
+	o.apply(opts)
+
+	if o.size < 1 {
+		o.size = 1
+	}
+
+	r := &Repository{
+		repo:    repo,
+		opts:    o,
+		teams:   newLRU(o.size),
+		players: newLRU(o.size),
+		names:   newLRU(o.size),
+		done:    make(chan struct{}),
+	}
+
+	if o.invalidate != nil {
+		go r.watch(o.invalidate)
+	}
+
+	return r
+}
+
+func (r *Repository) GetTeam(id football.TeamId) (football.Team, error) {
+	res, err := r.get(r.teams, id, func() (interface{}, error) {
+		return r.repo.GetTeam(id)
+	})
+
+	if err != nil {
+		return football.Team{}, err
+	}
+
+	return res.(football.Team), nil
+}
+
+func (r *Repository) GetTeamByName(name string) (football.Team, error) {
+	res, err := r.get(r.names, name, func() (interface{}, error) {
+		return r.repo.GetTeamByName(name)
+	})
+
+	if err != nil {
+		return football.Team{}, err
+	}
+
+	return res.(football.Team), nil
+}
+
+func (r *Repository) GetTeamsByName(name string) ([]football.Team, error) {
+	res, err := r.get(r.names, teamsKey(name), func() (interface{}, error) {
+		return storage.GetTeamsByName(r.repo, name)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]football.Team), nil
+}
+
+// GetTeams serves the cached teams, looking up the rest with a single batch
+// lookup. Missing teams are not cached, but negatively cached ones are
+// reported as missing.
+func (r *Repository) GetTeams(ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	keys := make([]interface{}, len(ids))
+	for i, id := range ids {
+		keys[i] = id
+	}
+
+	values, err := r.getBatch(r.teams, keys, func(misses []interface{}) (map[interface{}]interface{}, error) {
+		ids := make([]football.TeamId, len(misses))
+		for i, k := range misses {
+			ids[i] = k.(football.TeamId)
+		}
+
+		teams, _, err := storage.GetTeams(r.repo, ids)
+
+		loaded := make(map[interface{}]interface{}, len(teams))
+		for _, t := range teams {
+			loaded[t.Id] = t
+		}
+
+		return loaded, err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	teams := make([]football.Team, 0, len(ids))
+	missing := []football.TeamId{}
+
+	for _, id := range ids {
+		if v, ok := values[id]; ok {
+			teams = append(teams, v.(football.Team))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	return teams, missing, nil
+}
+
+// GetPlayers serves the cached players, looking up the rest with a single
+// batch lookup, like GetTeams.
+func (r *Repository) GetPlayers(ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	keys := make([]interface{}, len(ids))
+	for i, id := range ids {
+		keys[i] = id
+	}
+
+	values, err := r.getBatch(r.players, keys, func(misses []interface{}) (map[interface{}]interface{}, error) {
+		ids := make([]football.PlayerId, len(misses))
+		for i, k := range misses {
+			ids[i] = k.(football.PlayerId)
+		}
+
+		players, _, err := storage.GetPlayers(r.repo, ids)
+
+		loaded := make(map[interface{}]interface{}, len(players))
+		for _, p := range players {
+			loaded[p.Id] = p
+		}
+
+		return loaded, err
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	players := make([]football.Player, 0, len(ids))
+	missing := []football.PlayerId{}
+
+	for _, id := range ids {
+		if v, ok := values[id]; ok {
+			players = append(players, v.(football.Player))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	return players, missing, nil
+}
+
+// Search is passed through uncached, as the same search is rarely repeated.
+func (r *Repository) Search(query string, limit int) ([]football.Team, error) {
+	return storage.Search(r.repo, query, limit)
+}
+
+func (r *Repository) GetPlayer(id football.PlayerId) (football.Player, error) {
+	res, err := r.get(r.players, id, func() (interface{}, error) {
+		return r.repo.GetPlayer(id)
+	})
+
+	if err != nil {
+		return football.Player{}, err
+	}
+
+	return res.(football.Player), nil
+}
+
+// SearchPlayers is passed through uncached, like Search.
+func (r *Repository) SearchPlayers(query string, limit int) ([]football.Player, error) {
+	return storage.SearchPlayers(r.repo, query, limit)
+}
+
+// QueryPlayers is passed through uncached, like Search.
+func (r *Repository) QueryPlayers(query football.PlayerQuery) ([]football.Player, error) {
+	return storage.QueryPlayers(r.repo, query)
+}
+
+// ListTeams is passed through uncached, like Search.
+func (r *Repository) ListTeams(cursor string, limit int) ([]football.Team, string, error) {
+	return storage.ListTeams(r.repo, cursor, limit)
+}
+
+// ListPlayers is passed through uncached, like Search.
+func (r *Repository) ListPlayers(cursor string, limit int) ([]football.Player, string, error) {
+	return storage.ListPlayers(r.repo, cursor, limit)
+}
+
+// Close stops watching for invalidations and closes the underlying
+// repository.
+func (r *Repository) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+	})
+
+	return r.repo.Close()
+}
+
+// Purge drops all cached entries.
+func (r *Repository) Purge() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.teams.purge()
+	r.players.purge()
+	r.names.purge()
+	r.generation++
+}
+
+// Stats returns a snapshot of the cache counters.
+func (r *Repository) Stats() Stats {
+	r.mu.Lock()
+	entries := r.teams.len() + r.players.len() + r.names.len()
+	r.mu.Unlock()
+
+	return Stats{
+		Hits:      atomic.LoadUint64(&r.hits),
+		Misses:    atomic.LoadUint64(&r.misses),
+		Evictions: atomic.LoadUint64(&r.evictions),
+		Entries:   entries,
+	}
+}
+
+func (r *Repository) get(c *lru, key interface{}, load func() (interface{}, error)) (interface{}, error) {
+	r.mu.Lock()
+	v, ok := c.get(key)
+	generation := r.generation
+	r.mu.Unlock()
+
+	if ok {
+		atomic.AddUint64(&r.hits, 1)
+		res := v.(result)
+		return res.value, res.err
+	}
+
+	atomic.AddUint64(&r.misses, 1)
+
+	value, err := load()
+	if err != nil && !(r.opts.negative && storage.IsNotFound(err)) {
+		return value, err
+	}
+
+	r.mu.Lock()
+	if generation == r.generation {
+		if c.add(key, result{value, err}) {
+			atomic.AddUint64(&r.evictions, 1)
+		}
+
+		// A team found by name will most likely be queried by id as well.
+		if team, ok := value.(football.Team); ok && c == r.names && err == nil {
+			if r.teams.add(team.Id, result{value, nil}) {
+				atomic.AddUint64(&r.evictions, 1)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	return value, err
+}
+
+// getBatch returns the cached values of the keys, loading all misses with a
+// single call. Keys without a value in the result are missing.
+func (r *Repository) getBatch(c *lru, keys []interface{}, load func(misses []interface{}) (map[interface{}]interface{}, error)) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{}, len(keys))
+	misses := []interface{}{}
+
+	r.mu.Lock()
+	for _, k := range keys {
+		v, ok := c.get(k)
+		if !ok {
+			misses = append(misses, k)
+		} else if res := v.(result); res.err == nil {
+			values[k] = res.value
+		}
+	}
+	generation := r.generation
+	r.mu.Unlock()
+
+	atomic.AddUint64(&r.hits, uint64(len(keys)-len(misses)))
+	atomic.AddUint64(&r.misses, uint64(len(misses)))
+
+	if len(misses) == 0 {
+		return values, nil
+	}
+
+	loaded, err := load(misses)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	for k, v := range loaded {
+		values[k] = v
+
+		if generation == r.generation && c.add(k, result{v, nil}) {
+			atomic.AddUint64(&r.evictions, 1)
+		}
+	}
+	r.mu.Unlock()
+
+	return values, nil
+}
+
+func (r *Repository) watch(refreshed <-chan struct{}) {
+	for {
+		select {
+		case _, ok := <-refreshed:
+			if !ok {
+				return
+			}
+			r.Purge()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (o *options) apply(opts []Option) {
+	for _, op := range opts {
+		op.f(o)
+	}
+}
@@ -0,0 +1,233 @@
+// +build go1.7
+
+package cache_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/cache"
+	"github.com/urandom/team-search-test/storage/memory"
+	"github.com/urandom/team-search-test/storage/storagetest"
+)
+
+// counting is a repository that knows about a single team, counting how many
+// times it was queried.
+type counting struct {
+	calls int
+	name  string
+}
+
+func (c *counting) GetTeam(id football.TeamId) (football.Team, error) {
+	c.calls++
+	if id != 1 {
+		return football.Team{}, storage.NotFound(storage.EntityTeam, id, nil)
+	}
+	return football.Team{Id: 1, Name: c.name}, nil
+}
+
+func (c *counting) GetTeamByName(name string) (football.Team, error) {
+	c.calls++
+	if name != c.name {
+		return football.Team{}, storage.NotFound(storage.EntityTeam, name, nil)
+	}
+	return football.Team{Id: 1, Name: c.name}, nil
+}
+
+func (c *counting) GetTeamsByName(name string) ([]football.Team, error) {
+	team, err := c.GetTeamByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return []football.Team{team}, nil
+}
+
+func (c *counting) GetTeams(ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	c.calls++
+	teams, missing := []football.Team{}, []football.TeamId{}
+	for _, id := range ids {
+		if id == 1 {
+			teams = append(teams, football.Team{Id: 1, Name: c.name})
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return teams, missing, nil
+}
+
+func (c *counting) GetPlayers(ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	c.calls++
+	return nil, nil, errors.New("unavailable")
+}
+
+func (c *counting) Search(query string, limit int) ([]football.Team, error) {
+	return c.GetTeamsByName(query)
+}
+
+func (c *counting) GetPlayer(id football.PlayerId) (football.Player, error) {
+	c.calls++
+	return football.Player{}, errors.New("unavailable")
+}
+
+func (c *counting) SearchPlayers(query string, limit int) ([]football.Player, error) {
+	return nil, nil
+}
+
+func (c *counting) QueryPlayers(query football.PlayerQuery) ([]football.Player, error) {
+	return nil, nil
+}
+
+func (c *counting) ListTeams(cursor string, limit int) ([]football.Team, string, error) {
+	return nil, "", nil
+}
+
+func (c *counting) ListPlayers(cursor string, limit int) ([]football.Player, string, error) {
+	return nil, "", nil
+}
+
+func (c *counting) Close() error {
+	return nil
+}
+
+func TestTeamRepository(t *testing.T) {
+	storagetest.TestTeamRepository(t, func(data <-chan download.Team) football.TeamRepository {
+		return cache.NewTeamRepository(memory.NewTeamRepository(data))
+	})
+
+	storagetest.TestTeamRepository(t, func(data <-chan download.Team) football.TeamRepository {
+		return cache.NewTeamRepository(memory.NewTeamRepository(data), cache.NegativeCaching, cache.Size(2))
+	})
+}
+
+func TestBatchLookups(t *testing.T) {
+	c := &counting{name: "Apoel FC"}
+	repo := cache.NewTeamRepository(c)
+	defer repo.Close()
+
+	if _, err := repo.GetTeam(1); err != nil {
+		t.Fatalf("error looking for team: %+v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		teams, missing, err := repo.GetTeams([]football.TeamId{1, 2})
+		if err != nil {
+			t.Fatalf("error looking for teams: %+v", err)
+		}
+
+		if len(teams) != 1 || teams[0].Id != 1 || len(missing) != 1 || missing[0] != 2 {
+			t.Fatalf("expected team 1 and missing team 2, got %+v, %v", teams, missing)
+		}
+	}
+
+	// The missing team isn't cached, and is looked up by both batches.
+	if c.calls != 3 {
+		t.Fatalf("expected 3 calls to the underlying repository, got %d", c.calls)
+	}
+
+	if _, _, err := repo.GetPlayers([]football.PlayerId{"6"}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestHitsAndMisses(t *testing.T) {
+	c := &counting{name: "Apoel FC"}
+	repo := cache.NewTeamRepository(c)
+	defer repo.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := repo.GetTeam(1); err != nil {
+			t.Fatalf("error looking for team: %+v", err)
+		}
+
+		if _, err := repo.GetTeam(2); !storage.IsNotFound(err) {
+			t.Fatalf("expected not found error, got %+v", err)
+		}
+
+		if _, err := repo.GetPlayer("6"); err == nil {
+			t.Fatalf("expected error")
+		}
+	}
+
+	if c.calls != 7 {
+		t.Fatalf("expected 7 calls to the underlying repository, got %d", c.calls)
+	}
+
+	stats := repo.Stats()
+	if stats.Hits != 2 || stats.Misses != 7 {
+		t.Fatalf("expected 2 hits and 7 misses, got %+v", stats)
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	c := &counting{name: "Apoel FC"}
+	repo := cache.NewTeamRepository(c, cache.NegativeCaching)
+	defer repo.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := repo.GetTeamByName("Apoel"); !storage.IsNotFound(err) {
+			t.Fatalf("expected not found error, got %+v", err)
+		}
+	}
+
+	if c.calls != 1 {
+		t.Fatalf("expected a single call to the underlying repository, got %d", c.calls)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	c := &counting{name: "Apoel FC"}
+	repo := cache.NewTeamRepository(c, cache.NegativeCaching, cache.Size(2))
+	defer repo.Close()
+
+	for _, id := range []football.TeamId{1, 2, 3, 1} {
+		repo.GetTeam(id)
+	}
+
+	stats := repo.Stats()
+	if stats.Evictions != 2 || stats.Entries != 2 {
+		t.Fatalf("expected 2 evictions and 2 entries, got %+v", stats)
+	}
+
+	if c.calls != 4 {
+		t.Fatalf("expected 4 calls to the underlying repository, got %d", c.calls)
+	}
+}
+
+func TestClose(t *testing.T) {
+	repo := cache.NewTeamRepository(&counting{name: "Apoel FC"})
+
+	for i := 0; i < 2; i++ {
+		if err := repo.Close(); err != nil {
+			t.Fatalf("error closing repository: %+v", err)
+		}
+	}
+}
+
+func TestInvalidation(t *testing.T) {
+	c := &counting{name: "Apoel FC"}
+	refreshed := make(chan struct{})
+	repo := cache.NewTeamRepository(c, cache.InvalidateOn(refreshed))
+	defer repo.Close()
+
+	if team, _ := repo.GetTeamByName("Apoel FC"); team.Name != "Apoel FC" {
+		t.Fatalf("expected Apoel FC, got %s", team.Name)
+	}
+
+	c.name = "APOEL"
+	refreshed <- struct{}{}
+
+	for i := 0; repo.Stats().Entries != 0; i++ {
+		if i > 100 {
+			t.Fatalf("expected cache to be purged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if team, err := repo.GetTeamByName("APOEL"); err != nil || team.Name != "APOEL" {
+		t.Fatalf("expected APOEL, got %s: %+v", team.Name, err)
+	}
+}
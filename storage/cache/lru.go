@@ -0,0 +1,59 @@
+package cache
+
+import "container/list"
+
+// lru is a fixed size, least recently used cache. It is not safe for
+// concurrent use.
+type lru struct {
+	size    int
+	ll      *list.List
+	entries map[interface{}]*list.Element
+}
+
+type entry struct {
+	key   interface{}
+	value interface{}
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, ll: list.New(), entries: make(map[interface{}]*list.Element)}
+}
+
+func (c *lru) get(key interface{}) (interface{}, bool) {
+	if el, ok := c.entries[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*entry).value, true
+	}
+
+	return nil, false
+}
+
+// add stores the value under the given key, evicting the oldest entry if the
+// cache is full. It reports whether an entry was evicted.
+func (c *lru) add(key, value interface{}) bool {
+	if el, ok := c.entries[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*entry).value = value
+		return false
+	}
+
+	c.entries[key] = c.ll.PushFront(&entry{key, value})
+
+	if c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.entries, el.Value.(*entry).key)
+		return true
+	}
+
+	return false
+}
+
+func (c *lru) purge() {
+	c.ll.Init()
+	c.entries = make(map[interface{}]*list.Element)
+}
+
+func (c *lru) len() int {
+	return c.ll.Len()
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/football"
+)
+
+// contextRepository adapts a football.TeamRepository to the
+// football.ContextTeamRepository interface, along with the optional context
+// interfaces, whose queries behave like the query functions of this package
+// when the repository lacks the capability.
+type contextRepository struct {
+	repo football.TeamRepository
+}
+
+type outcome struct {
+	value interface{}
+	err   error
+}
+
+// batch holds the results of a batch lookup.
+type batch struct {
+	found   interface{}
+	missing interface{}
+}
+
+// WithContext returns the repository itself if it already implements
+// football.ContextTeamRepository. Otherwise, it wraps it, running every query
+// in the background and returning a not-ready error if the context is done
+// first. Such abandoned queries still run to completion.
+func WithContext(repo football.TeamRepository) football.ContextTeamRepository {
+	if r, ok := repo.(football.ContextTeamRepository); ok {
+		return r
+	}
+
+	return contextRepository{repo: repo}
+}
+
+func (r contextRepository) GetTeamContext(ctx context.Context, id football.TeamId) (football.Team, error) {
+	v, err := await(ctx, fmt.Sprintf("getting team %d", id), func() (interface{}, error) {
+		return r.repo.GetTeam(id)
+	})
+
+	team, _ := v.(football.Team)
+	return team, err
+}
+
+func (r contextRepository) GetTeamByNameContext(ctx context.Context, name string) (football.Team, error) {
+	v, err := await(ctx, fmt.Sprintf("getting team %s", name), func() (interface{}, error) {
+		return r.repo.GetTeamByName(name)
+	})
+
+	team, _ := v.(football.Team)
+	return team, err
+}
+
+func (r contextRepository) GetTeamsByNameContext(ctx context.Context, name string) ([]football.Team, error) {
+	v, err := await(ctx, fmt.Sprintf("getting teams %s", name), func() (interface{}, error) {
+		return GetTeamsByName(r.repo, name)
+	})
+
+	teams, _ := v.([]football.Team)
+	return teams, err
+}
+
+func (r contextRepository) SearchContext(ctx context.Context, query string, limit int) ([]football.Team, error) {
+	v, err := await(ctx, fmt.Sprintf("searching teams %s", query), func() (interface{}, error) {
+		return Search(r.repo, query, limit)
+	})
+
+	teams, _ := v.([]football.Team)
+	return teams, err
+}
+
+func (r contextRepository) GetPlayerContext(ctx context.Context, id football.PlayerId) (football.Player, error) {
+	v, err := await(ctx, fmt.Sprintf("getting player %s", id), func() (interface{}, error) {
+		return r.repo.GetPlayer(id)
+	})
+
+	player, _ := v.(football.Player)
+	return player, err
+}
+
+func (r contextRepository) GetTeamsContext(ctx context.Context, ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	v, err := await(ctx, fmt.Sprintf("getting %d teams", len(ids)), func() (interface{}, error) {
+		teams, missing, err := GetTeams(r.repo, ids)
+		return batch{teams, missing}, err
+	})
+
+	b, _ := v.(batch)
+	teams, _ := b.found.([]football.Team)
+	missing, _ := b.missing.([]football.TeamId)
+	return teams, missing, err
+}
+
+func (r contextRepository) GetPlayersContext(ctx context.Context, ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	v, err := await(ctx, fmt.Sprintf("getting %d players", len(ids)), func() (interface{}, error) {
+		players, missing, err := GetPlayers(r.repo, ids)
+		return batch{players, missing}, err
+	})
+
+	b, _ := v.(batch)
+	players, _ := b.found.([]football.Player)
+	missing, _ := b.missing.([]football.PlayerId)
+	return players, missing, err
+}
+
+func (r contextRepository) SearchPlayersContext(ctx context.Context, query string, limit int) ([]football.Player, error) {
+	v, err := await(ctx, fmt.Sprintf("searching players %s", query), func() (interface{}, error) {
+		return SearchPlayers(r.repo, query, limit)
+	})
+
+	players, _ := v.([]football.Player)
+	return players, err
+}
+
+func (r contextRepository) QueryPlayersContext(ctx context.Context, query football.PlayerQuery) ([]football.Player, error) {
+	v, err := await(ctx, "querying players", func() (interface{}, error) {
+		return QueryPlayers(r.repo, query)
+	})
+
+	players, _ := v.([]football.Player)
+	return players, err
+}
+
+func (r contextRepository) ListTeamsContext(ctx context.Context, cursor string, limit int) ([]football.Team, string, error) {
+	v, err := await(ctx, "listing teams", func() (interface{}, error) {
+		teams, next, err := ListTeams(r.repo, cursor, limit)
+		return batch{teams, next}, err
+	})
+
+	b, _ := v.(batch)
+	teams, _ := b.found.([]football.Team)
+	next, _ := b.missing.(string)
+	return teams, next, err
+}
+
+func (r contextRepository) ListPlayersContext(ctx context.Context, cursor string, limit int) ([]football.Player, string, error) {
+	v, err := await(ctx, "listing players", func() (interface{}, error) {
+		players, next, err := ListPlayers(r.repo, cursor, limit)
+		return batch{players, next}, err
+	})
+
+	b, _ := v.(batch)
+	players, _ := b.found.([]football.Player)
+	next, _ := b.missing.(string)
+	return players, next, err
+}
+
+func (r contextRepository) Close() error {
+	return r.repo.Close()
+}
+
+// await runs the query in the background, waiting for it to finish or the
+// context to be done, whichever comes first.
+func await(ctx context.Context, desc string, query func() (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, NotReady("", nil, errors.Wrap(err, desc))
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		v, err := query()
+		done <- outcome{v, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.value, o.err
+	case <-ctx.Done():
+		return nil, NotReady("", nil, errors.Wrap(ctx.Err(), desc))
+	}
+}
@@ -1,6 +1,13 @@
 package storage
 
-import "github.com/urandom/team-search-test/football"
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+)
 
 type JsonData struct {
 	Data struct {
@@ -20,3 +27,68 @@ type playerData struct {
 	Name string            `json:"name"`
 	Age  interface{}       `json:"age"`
 }
+
+// ParseTeam decodes the downloaded team data. The returned players only list
+// the parsed team in their Teams field. Player ages may be either JSON numbers,
+// as written by EncodeTeam, or numeric strings, as served by the download API.
+// Any other age is parsed as 0.
+func ParseTeam(d download.Team) (football.Team, []football.Player, error) {
+	var j JsonData
+
+	if err := json.Unmarshal(d.Bytes, &j); err != nil {
+		return football.Team{}, nil, errors.Wrapf(err, "parsing team data for %d", d.Id)
+	}
+
+	td := j.Data.Team
+
+	team := football.Team{
+		Id: td.Id, Name: td.Name,
+		IsNational: td.IsNational, Players: []football.PlayerId{},
+	}
+	players := make([]football.Player, 0, len(td.Players))
+
+	for _, p := range td.Players {
+		team.Players = append(team.Players, p.Id)
+
+		var age int
+		switch v := p.Age.(type) {
+		case float64:
+			age = int(v)
+		case string:
+			// Ignore the error, we can't do anything if the string
+			// isn't numerical
+			age, _ = strconv.Atoi(v)
+		}
+
+		players = append(players, football.Player{
+			Id: p.Id, Name: p.Name,
+			Age: age, Teams: []football.TeamId{td.Id},
+		})
+	}
+
+	return team, players, nil
+}
+
+// EncodeTeam encodes the team and its players in the download data format,
+// such that ParseTeam will return the same team.
+func EncodeTeam(team football.Team, players []football.Player) (download.Team, error) {
+	var j JsonData
+
+	j.Data.Team = teamData{
+		Id: team.Id, Name: team.Name,
+		IsNational: team.IsNational, Players: make([]playerData, 0, len(players)),
+	}
+
+	for _, p := range players {
+		j.Data.Team.Players = append(j.Data.Team.Players, playerData{
+			Id: p.Id, Name: p.Name, Age: p.Age,
+		})
+	}
+
+	b, err := json.Marshal(j)
+	if err != nil {
+		return download.Team{}, errors.Wrapf(err, "encoding team data for %d", team.Id)
+	}
+
+	return download.Team{Bytes: b, Id: int(team.Id)}, nil
+}
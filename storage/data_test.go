@@ -0,0 +1,53 @@
+// +build go1.7
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/storage"
+)
+
+func TestParseTeam(t *testing.T) {
+	team, players, err := storage.ParseTeam(download.Team{Bytes: []byte(`{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"},
+		{"id": "7", "name": "Urko Pardo", "age": 33},
+		{"id": "8", "name": "Carlao", "age": "unknown"}
+	]}}}`), Id: 1})
+	if err != nil {
+		t.Fatalf("error parsing team: %+v", err)
+	}
+
+	if team.Id != 1 || team.Name != "Apoel FC" || len(team.Players) != 3 {
+		t.Fatalf("unexpected team %+v", team)
+	}
+
+	for i, age := range []int{32, 33, 0} {
+		if players[i].Age != age {
+			t.Fatalf("expected player %s to be %d, got %d", players[i].Id, age, players[i].Age)
+		}
+
+		if len(players[i].Teams) != 1 || players[i].Teams[0] != 1 {
+			t.Fatalf("expected player %s to list team 1, got %v", players[i].Id, players[i].Teams)
+		}
+	}
+
+	d, err := storage.EncodeTeam(team, players[:2])
+	if err != nil {
+		t.Fatalf("error encoding team: %+v", err)
+	}
+
+	_, decoded, err := storage.ParseTeam(d)
+	if err != nil {
+		t.Fatalf("error parsing encoded team: %+v", err)
+	}
+
+	if len(decoded) != 2 || decoded[0].Age != 32 || decoded[1].Age != 33 {
+		t.Fatalf("expected the encoded ages to round trip, got %+v", decoded)
+	}
+
+	if _, _, err := storage.ParseTeam(download.Team{Bytes: []byte("garbage"), Id: 1}); err == nil {
+		t.Fatalf("expected an error parsing invalid data")
+	}
+}
@@ -0,0 +1,340 @@
+// Package diff compares two datasets, as exported by the dump package, and
+// reports the transfers, new and removed players, renamed teams and squad
+// size changes between them.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage/dump"
+)
+
+// Format is the encoding of a report.
+type Format string
+
+const (
+	// Text lists the report entries in readable sections.
+	Text Format = "text"
+	// JSON encodes the whole report as a single JSON object.
+	JSON Format = "json"
+)
+
+// Report contains the differences between two datasets. Entries are ordered
+// by player or team id.
+type Report struct {
+	Transfers      []Transfer    `json:"transfers"`
+	NewPlayers     []Membership  `json:"new_players"`
+	RemovedPlayers []Membership  `json:"removed_players"`
+	RenamedTeams   []Rename      `json:"renamed_teams"`
+	SquadSizes     []SquadChange `json:"squad_sizes"`
+}
+
+// Transfer lists the teams a player, present in both datasets, has left and
+// joined.
+type Transfer struct {
+	Player dump.Player `json:"player"`
+	Left   []dump.Team `json:"left"`
+	Joined []dump.Team `json:"joined"`
+}
+
+// Membership lists the teams of a player only present in one dataset.
+type Membership struct {
+	Player dump.Player `json:"player"`
+	Teams  []dump.Team `json:"teams"`
+}
+
+// Rename is a team present in both datasets under different names.
+type Rename struct {
+	Team         dump.Team `json:"team"`
+	PreviousName string    `json:"previous_name"`
+}
+
+// SquadChange is a team whose number of players differs between the
+// datasets. Teams only present in one dataset have no players in the other.
+type SquadChange struct {
+	Team   dump.Team `json:"team"`
+	Before int       `json:"before"`
+	After  int       `json:"after"`
+}
+
+type dataset struct {
+	teams   map[football.TeamId]football.Team
+	players map[football.PlayerId]football.Player
+}
+
+// ParseFormat checks whether the string is a supported format.
+func ParseFormat(f string) (Format, error) {
+	switch Format(f) {
+	case Text, JSON:
+		return Format(f), nil
+	default:
+		return "", errors.Errorf("unknown format %q", f)
+	}
+}
+
+// Compare reports the differences from the old dataset to the new one.
+func Compare(from, to dump.Dataset) (Report, error) {
+	old, err := index(from)
+	if err != nil {
+		return Report{}, errors.Wrap(err, "indexing old dataset")
+	}
+
+	cur, err := index(to)
+	if err != nil {
+		return Report{}, errors.Wrap(err, "indexing new dataset")
+	}
+
+	r := Report{
+		Transfers:      []Transfer{},
+		NewPlayers:     []Membership{},
+		RemovedPlayers: []Membership{},
+		RenamedTeams:   []Rename{},
+		SquadSizes:     []SquadChange{},
+	}
+
+	transfers := map[football.PlayerId]int{}
+
+	for _, c := range football.Changes(old.teams, cur.teams, old.players, cur.players) {
+		switch c.Kind {
+		case football.TeamRenamed:
+			r.RenamedTeams = append(r.RenamedTeams, Rename{exportTeam(c.Team), c.PreviousName})
+		case football.PlayerJoined, football.PlayerLeft:
+			_, wasOld := old.players[c.Player.Id]
+			_, isNew := cur.players[c.Player.Id]
+			if !wasOld || !isNew {
+				continue
+			}
+
+			i, ok := transfers[c.Player.Id]
+			if !ok {
+				i = len(r.Transfers)
+				transfers[c.Player.Id] = i
+				r.Transfers = append(r.Transfers, Transfer{
+					Player: exportPlayer(c.Player), Left: []dump.Team{}, Joined: []dump.Team{},
+				})
+			}
+
+			if c.Kind == football.PlayerJoined {
+				r.Transfers[i].Joined = append(r.Transfers[i].Joined, exportTeam(c.Team))
+			} else {
+				r.Transfers[i].Left = append(r.Transfers[i].Left, exportTeam(c.Team))
+			}
+		}
+	}
+
+	r.NewPlayers = missing(cur, old)
+	r.RemovedPlayers = missing(old, cur)
+
+	for _, id := range teamIds(old, cur) {
+		o, n := old.teams[id], cur.teams[id]
+		if len(o.Players) == len(n.Players) {
+			continue
+		}
+
+		t := n
+		if _, ok := cur.teams[id]; !ok {
+			t = o
+		}
+
+		r.SquadSizes = append(r.SquadSizes, SquadChange{exportTeam(t), len(o.Players), len(n.Players)})
+	}
+
+	return r, nil
+}
+
+// Write encodes the report in the given format.
+func Write(w io.Writer, f Format, r Report) error {
+	switch f {
+	case Text:
+		return writeText(w, r)
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(r), "encoding report")
+	default:
+		return errors.Errorf("unknown format %q", f)
+	}
+}
+
+// Empty checks whether the report contains no differences.
+func (r Report) Empty() bool {
+	return len(r.Transfers) == 0 && len(r.NewPlayers) == 0 && len(r.RemovedPlayers) == 0 &&
+		len(r.RenamedTeams) == 0 && len(r.SquadSizes) == 0
+}
+
+func writeText(w io.Writer, r Report) error {
+	if r.Empty() {
+		_, err := fmt.Fprintln(w, "No differences")
+		return errors.Wrap(err, "writing report")
+	}
+
+	lines := []string{}
+	section := func(title string, entries []string) {
+		if len(entries) == 0 {
+			return
+		}
+
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+
+		lines = append(lines, title+":")
+		for _, e := range entries {
+			lines = append(lines, "  "+e)
+		}
+	}
+
+	entries := []string{}
+	for _, t := range r.Transfers {
+		moves := []string{}
+		if len(t.Left) > 0 {
+			moves = append(moves, "left "+formatTeams(t.Left))
+		}
+		if len(t.Joined) > 0 {
+			moves = append(moves, "joined "+formatTeams(t.Joined))
+		}
+
+		entries = append(entries, fmt.Sprintf("%s: %s", formatPlayer(t.Player), strings.Join(moves, "; ")))
+	}
+	section("Transfers", entries)
+
+	entries = []string{}
+	for _, m := range r.NewPlayers {
+		entries = append(entries, fmt.Sprintf("%s: %s", formatPlayer(m.Player), formatTeams(m.Teams)))
+	}
+	section("New players", entries)
+
+	entries = []string{}
+	for _, m := range r.RemovedPlayers {
+		entries = append(entries, fmt.Sprintf("%s: %s", formatPlayer(m.Player), formatTeams(m.Teams)))
+	}
+	section("Removed players", entries)
+
+	entries = []string{}
+	for _, rn := range r.RenamedTeams {
+		entries = append(entries, fmt.Sprintf("%s, previously %s", formatTeam(rn.Team), rn.PreviousName))
+	}
+	section("Renamed teams", entries)
+
+	entries = []string{}
+	for _, s := range r.SquadSizes {
+		entries = append(entries, fmt.Sprintf("%s: %d -> %d", formatTeam(s.Team), s.Before, s.After))
+	}
+	section("Squad sizes", entries)
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return errors.Wrap(err, "writing report")
+}
+
+// index converts the dataset to teams and players referring to each other.
+func index(ds dump.Dataset) (dataset, error) {
+	d := dataset{
+		teams:   make(map[football.TeamId]football.Team, len(ds.Teams)),
+		players: make(map[football.PlayerId]football.Player, len(ds.Players)),
+	}
+
+	for _, t := range ds.Teams {
+		d.teams[t.Id] = football.Team{Id: t.Id, Name: t.Name, IsNational: t.IsNational}
+	}
+
+	for _, p := range ds.Players {
+		d.players[p.Id] = football.Player{Id: p.Id, Name: p.Name, Age: p.Age}
+	}
+
+	for _, m := range ds.Memberships {
+		t, ok := d.teams[m.TeamId]
+		if !ok {
+			return dataset{}, errors.Errorf("unknown team %d of player %s", m.TeamId, m.PlayerId)
+		}
+
+		p, ok := d.players[m.PlayerId]
+		if !ok {
+			return dataset{}, errors.Errorf("unknown player %s in team %d", m.PlayerId, m.TeamId)
+		}
+
+		t.Players = append(t.Players, p.Id)
+		p.Teams = append(p.Teams, t.Id)
+
+		d.teams[t.Id] = t
+		d.players[p.Id] = p
+	}
+
+	return d, nil
+}
+
+// missing returns the players of a that are not in b, ordered by id.
+func missing(a, b dataset) []Membership {
+	ids := []string{}
+	for id := range a.players {
+		if _, ok := b.players[id]; !ok {
+			ids = append(ids, string(id))
+		}
+	}
+	sort.Strings(ids)
+
+	memberships := make([]Membership, 0, len(ids))
+	for _, id := range ids {
+		p := a.players[football.PlayerId(id)]
+
+		teams := make([]dump.Team, 0, len(p.Teams))
+		for _, tid := range p.Teams {
+			teams = append(teams, exportTeam(a.teams[tid]))
+		}
+
+		memberships = append(memberships, Membership{exportPlayer(p), teams})
+	}
+
+	return memberships
+}
+
+// teamIds returns the sorted ids of the teams of both datasets.
+func teamIds(a, b dataset) []football.TeamId {
+	all := []int{}
+	for id := range a.teams {
+		all = append(all, int(id))
+	}
+	for id := range b.teams {
+		if _, ok := a.teams[id]; !ok {
+			all = append(all, int(id))
+		}
+	}
+	sort.Ints(all)
+
+	ids := make([]football.TeamId, len(all))
+	for i, id := range all {
+		ids[i] = football.TeamId(id)
+	}
+
+	return ids
+}
+
+func exportTeam(t football.Team) dump.Team {
+	return dump.Team{Id: t.Id, Name: t.Name, IsNational: t.IsNational}
+}
+
+func exportPlayer(p football.Player) dump.Player {
+	return dump.Player{Id: p.Id, Name: p.Name, Age: p.Age}
+}
+
+func formatPlayer(p dump.Player) string {
+	return fmt.Sprintf("%s (#%s)", p.Name, p.Id)
+}
+
+func formatTeam(t dump.Team) string {
+	return fmt.Sprintf("%s (#%d)", t.Name, t.Id)
+}
+
+func formatTeams(teams []dump.Team) string {
+	names := make([]string, len(teams))
+	for i, t := range teams {
+		names[i] = formatTeam(t)
+	}
+
+	return strings.Join(names, ", ")
+}
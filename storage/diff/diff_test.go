@@ -0,0 +1,158 @@
+// +build go1.7
+
+package diff_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/urandom/team-search-test/storage/diff"
+	"github.com/urandom/team-search-test/storage/dump"
+)
+
+func TestCompare(t *testing.T) {
+	from := dump.Dataset{
+		Teams: []dump.Team{
+			{Id: 1, Name: "Apoel"},
+			{Id: 2, Name: "Bulgaria", IsNational: true},
+			{Id: 3, Name: "Levski Sofia"},
+		},
+		Players: []dump.Player{
+			{Id: "6", Name: "Nuno Morais", Age: 32},
+			{Id: "7", Name: "Aleksandar Tonev", Age: 26},
+			{Id: "8", Name: "Ivelin Popov", Age: 28},
+		},
+		Memberships: []dump.Membership{
+			{TeamId: 1, PlayerId: "6"},
+			{TeamId: 2, PlayerId: "7"}, {TeamId: 2, PlayerId: "8"},
+			{TeamId: 3, PlayerId: "7"}, {TeamId: 3, PlayerId: "8"},
+		},
+	}
+
+	to := dump.Dataset{
+		Teams: []dump.Team{
+			{Id: 1, Name: "Apoel FC"},
+			{Id: 2, Name: "Bulgaria", IsNational: true},
+			{Id: 4, Name: "Crotone"},
+		},
+		Players: []dump.Player{
+			{Id: "6", Name: "Nuno Morais", Age: 33},
+			{Id: "7", Name: "Aleksandar Tonev", Age: 26},
+			{Id: "9", Name: "Tomas Sivok", Age: 33},
+		},
+		Memberships: []dump.Membership{
+			{TeamId: 1, PlayerId: "6"}, {TeamId: 1, PlayerId: "9"},
+			{TeamId: 2, PlayerId: "7"},
+			{TeamId: 4, PlayerId: "7"},
+		},
+	}
+
+	r, err := diff.Compare(from, to)
+	if err != nil {
+		t.Fatalf("error comparing datasets: %+v", err)
+	}
+
+	if len(r.Transfers) != 1 || r.Transfers[0].Player.Id != "7" ||
+		len(r.Transfers[0].Left) != 1 || r.Transfers[0].Left[0].Id != 3 ||
+		len(r.Transfers[0].Joined) != 1 || r.Transfers[0].Joined[0].Id != 4 {
+		t.Fatalf("expected player 7 to transfer from team 3 to 4, got %+v", r.Transfers)
+	}
+
+	if len(r.NewPlayers) != 1 || r.NewPlayers[0].Player.Id != "9" || r.NewPlayers[0].Teams[0].Id != 1 {
+		t.Fatalf("expected new player 9 of team 1, got %+v", r.NewPlayers)
+	}
+
+	if len(r.RemovedPlayers) != 1 || r.RemovedPlayers[0].Player.Id != "8" || len(r.RemovedPlayers[0].Teams) != 2 {
+		t.Fatalf("expected removed player 8 of two teams, got %+v", r.RemovedPlayers)
+	}
+
+	if len(r.RenamedTeams) != 1 || r.RenamedTeams[0].Team.Name != "Apoel FC" || r.RenamedTeams[0].PreviousName != "Apoel" {
+		t.Fatalf("expected team Apoel to be renamed, got %+v", r.RenamedTeams)
+	}
+
+	expected := []diff.SquadChange{
+		{Team: dump.Team{Id: 1, Name: "Apoel FC"}, Before: 1, After: 2},
+		{Team: dump.Team{Id: 2, Name: "Bulgaria", IsNational: true}, Before: 2, After: 1},
+		{Team: dump.Team{Id: 3, Name: "Levski Sofia"}, Before: 2, After: 0},
+		{Team: dump.Team{Id: 4, Name: "Crotone"}, Before: 0, After: 1},
+	}
+
+	if len(r.SquadSizes) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, r.SquadSizes)
+	}
+
+	for i := range expected {
+		if r.SquadSizes[i] != expected[i] {
+			t.Fatalf("expected %+v, got %+v", expected[i], r.SquadSizes[i])
+		}
+	}
+
+	var b bytes.Buffer
+	if err := diff.Write(&b, diff.Text, r); err != nil {
+		t.Fatalf("error writing text report: %+v", err)
+	}
+
+	text := `Transfers:
+  Aleksandar Tonev (#7): left Levski Sofia (#3); joined Crotone (#4)
+
+New players:
+  Tomas Sivok (#9): Apoel FC (#1)
+
+Removed players:
+  Ivelin Popov (#8): Bulgaria (#2), Levski Sofia (#3)
+
+Renamed teams:
+  Apoel FC (#1), previously Apoel
+
+Squad sizes:
+  Apoel FC (#1): 1 -> 2
+  Bulgaria (#2): 2 -> 1
+  Levski Sofia (#3): 2 -> 0
+  Crotone (#4): 0 -> 1
+`
+	if b.String() != text {
+		t.Fatalf("expected text report\n%s\ngot\n%s", text, b.String())
+	}
+
+	b.Reset()
+	if err := diff.Write(&b, diff.JSON, r); err != nil {
+		t.Fatalf("error writing JSON report: %+v", err)
+	}
+
+	var decoded diff.Report
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatalf("error decoding JSON report: %+v", err)
+	}
+
+	if len(decoded.Transfers) != 1 || len(decoded.SquadSizes) != 4 {
+		t.Fatalf("expected the JSON report to match, got %+v", decoded)
+	}
+}
+
+func TestCompareEqual(t *testing.T) {
+	ds := dump.Dataset{
+		Teams:       []dump.Team{{Id: 1, Name: "Apoel FC"}},
+		Players:     []dump.Player{{Id: "6", Name: "Nuno Morais", Age: 32}},
+		Memberships: []dump.Membership{{TeamId: 1, PlayerId: "6"}},
+	}
+
+	r, err := diff.Compare(ds, ds)
+	if err != nil {
+		t.Fatalf("error comparing datasets: %+v", err)
+	}
+
+	if !r.Empty() {
+		t.Fatalf("expected no differences, got %+v", r)
+	}
+
+	var b bytes.Buffer
+	if err := diff.Write(&b, diff.Text, r); err != nil || b.String() != "No differences\n" {
+		t.Fatalf("expected no differences to be reported, got %q, %+v", b.String(), err)
+	}
+
+	ds.Memberships = append(ds.Memberships, dump.Membership{TeamId: 2, PlayerId: "6"})
+	if _, err := diff.Compare(ds, ds); err == nil {
+		t.Fatalf("expected an error for an unknown team")
+	}
+}
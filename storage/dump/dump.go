@@ -0,0 +1,384 @@
+// Package dump exports the contents of a team repository into a portable
+// dataset, and imports such datasets back as download data.
+package dump
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+// Format is the encoding of a dataset.
+type Format string
+
+const (
+	// NDJSON encodes every team, player and membership as a separate JSON
+	// object, one per line, distinguished by their type field.
+	NDJSON Format = "ndjson"
+	// CSV encodes one row per team membership, with teams without players
+	// having empty player columns.
+	CSV Format = "csv"
+	// JSON encodes the whole dataset as a single JSON object.
+	JSON Format = "json"
+)
+
+// Team is the exported form of a team.
+type Team struct {
+	Id         football.TeamId `json:"id"`
+	Name       string          `json:"name"`
+	IsNational bool            `json:"national"`
+}
+
+// Player is the exported form of a player.
+type Player struct {
+	Id   football.PlayerId `json:"id"`
+	Name string            `json:"name"`
+	Age  int               `json:"age"`
+}
+
+// Membership denotes that a player is part of a team.
+type Membership struct {
+	TeamId   football.TeamId   `json:"team"`
+	PlayerId football.PlayerId `json:"player"`
+}
+
+// Dataset contains the whole contents of a repository. The memberships are
+// ordered by team, in the same order as the team players.
+type Dataset struct {
+	Teams       []Team       `json:"teams"`
+	Players     []Player     `json:"players"`
+	Memberships []Membership `json:"memberships"`
+}
+
+type teamRecord struct {
+	Type string `json:"type"`
+	Team
+}
+
+type playerRecord struct {
+	Type string `json:"type"`
+	Player
+}
+
+type membershipRecord struct {
+	Type string `json:"type"`
+	Membership
+}
+
+var csvHeader = []string{"team_id", "team_name", "national", "player_id", "player_name", "player_age"}
+
+// ParseFormat checks whether the string is a supported format.
+func ParseFormat(f string) (Format, error) {
+	switch Format(f) {
+	case NDJSON, CSV, JSON:
+		return Format(f), nil
+	default:
+		return "", errors.Errorf("unknown format %q", f)
+	}
+}
+
+// Collect replays the repository contents into a dataset.
+func Collect(repo storage.Replayer) (Dataset, error) {
+	data := make(chan download.Team)
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- repo.Replay(data)
+		close(data)
+	}()
+
+	var ds Dataset
+	seen := map[football.PlayerId]struct{}{}
+
+	var err error
+	for d := range data {
+		if err != nil {
+			continue
+		}
+
+		var team football.Team
+		var players []football.Player
+
+		team, players, err = storage.ParseTeam(d)
+		if err != nil {
+			continue
+		}
+
+		ds.Teams = append(ds.Teams, Team{team.Id, team.Name, team.IsNational})
+
+		for _, p := range players {
+			if _, ok := seen[p.Id]; !ok {
+				seen[p.Id] = struct{}{}
+				ds.Players = append(ds.Players, Player{p.Id, p.Name, p.Age})
+			}
+
+			ds.Memberships = append(ds.Memberships, Membership{team.Id, p.Id})
+		}
+	}
+
+	if e := <-errc; e != nil {
+		return ds, errors.Wrap(e, "replaying repository")
+	}
+
+	return ds, err
+}
+
+// Export writes the repository contents in the given format.
+func Export(w io.Writer, f Format, repo storage.Replayer) error {
+	ds, err := Collect(repo)
+	if err != nil {
+		return err
+	}
+
+	return Write(w, f, ds)
+}
+
+// Write encodes the dataset in the given format.
+func Write(w io.Writer, f Format, ds Dataset) error {
+	switch f {
+	case NDJSON:
+		return writeNDJSON(w, ds)
+	case CSV:
+		return writeCSV(w, ds)
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(ds), "encoding dataset")
+	default:
+		return errors.Errorf("unknown format %q", f)
+	}
+}
+
+// Read decodes a dataset in the given format.
+func Read(r io.Reader, f Format) (Dataset, error) {
+	switch f {
+	case NDJSON:
+		return readNDJSON(r)
+	case CSV:
+		return readCSV(r)
+	case JSON:
+		var ds Dataset
+		err := json.NewDecoder(r).Decode(&ds)
+		return ds, errors.Wrap(err, "decoding dataset")
+	default:
+		return Dataset{}, errors.Errorf("unknown format %q", f)
+	}
+}
+
+// Import reads a dataset in the given format, and converts it to download
+// data, suitable for initializing any team repository.
+func Import(r io.Reader, f Format) ([]download.Team, error) {
+	ds, err := Read(r, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return ds.Download()
+}
+
+// Download converts the dataset to download data, one entry per team.
+func (ds Dataset) Download() ([]download.Team, error) {
+	players := make(map[football.PlayerId]football.Player, len(ds.Players))
+	for _, p := range ds.Players {
+		players[p.Id] = football.Player{Id: p.Id, Name: p.Name, Age: p.Age}
+	}
+
+	members := map[football.TeamId][]football.Player{}
+	for _, m := range ds.Memberships {
+		p, ok := players[m.PlayerId]
+		if !ok {
+			return nil, errors.Errorf("unknown player %s in team %d", m.PlayerId, m.TeamId)
+		}
+
+		members[m.TeamId] = append(members[m.TeamId], p)
+	}
+
+	teams := make([]download.Team, 0, len(ds.Teams))
+	for _, t := range ds.Teams {
+		d, err := storage.EncodeTeam(football.Team{
+			Id: t.Id, Name: t.Name, IsNational: t.IsNational,
+		}, members[t.Id])
+		if err != nil {
+			return nil, err
+		}
+
+		teams = append(teams, d)
+	}
+
+	return teams, nil
+}
+
+func writeNDJSON(w io.Writer, ds Dataset) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	for _, t := range ds.Teams {
+		if err := enc.Encode(teamRecord{"team", t}); err != nil {
+			return errors.Wrapf(err, "encoding team %d", t.Id)
+		}
+	}
+
+	for _, p := range ds.Players {
+		if err := enc.Encode(playerRecord{"player", p}); err != nil {
+			return errors.Wrapf(err, "encoding player %s", p.Id)
+		}
+	}
+
+	for _, m := range ds.Memberships {
+		if err := enc.Encode(membershipRecord{"membership", m}); err != nil {
+			return errors.Wrapf(err, "encoding membership of %s", m.PlayerId)
+		}
+	}
+
+	return errors.Wrap(bw.Flush(), "writing dataset")
+}
+
+func readNDJSON(r io.Reader) (Dataset, error) {
+	var ds Dataset
+
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var rec struct {
+			Type string `json:"type"`
+		}
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return ds, errors.Wrapf(err, "decoding record %d", line)
+		}
+
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return ds, errors.Wrapf(err, "decoding record %d", line)
+		}
+
+		var err error
+		switch rec.Type {
+		case "team":
+			var t Team
+			err = json.Unmarshal(raw, &t)
+			ds.Teams = append(ds.Teams, t)
+		case "player":
+			var p Player
+			err = json.Unmarshal(raw, &p)
+			ds.Players = append(ds.Players, p)
+		case "membership":
+			var m Membership
+			err = json.Unmarshal(raw, &m)
+			ds.Memberships = append(ds.Memberships, m)
+		default:
+			err = errors.Errorf("unknown type %q", rec.Type)
+		}
+
+		if err != nil {
+			return ds, errors.Wrapf(err, "decoding record %d", line)
+		}
+	}
+
+	return ds, nil
+}
+
+func writeCSV(w io.Writer, ds Dataset) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write(csvHeader); err != nil {
+		return errors.Wrap(err, "writing header")
+	}
+
+	players := make(map[football.PlayerId]Player, len(ds.Players))
+	for _, p := range ds.Players {
+		players[p.Id] = p
+	}
+
+	members := map[football.TeamId][]football.PlayerId{}
+	for _, m := range ds.Memberships {
+		members[m.TeamId] = append(members[m.TeamId], m.PlayerId)
+	}
+
+	for _, t := range ds.Teams {
+		team := []string{strconv.Itoa(int(t.Id)), t.Name, strconv.FormatBool(t.IsNational)}
+
+		if len(members[t.Id]) == 0 {
+			if err := cw.Write(append(team, "", "", "")); err != nil {
+				return errors.Wrapf(err, "writing team %d", t.Id)
+			}
+		}
+
+		for _, pid := range members[t.Id] {
+			p := players[pid]
+			row := append(team[:3:3], string(p.Id), p.Name, strconv.Itoa(p.Age))
+			if err := cw.Write(row); err != nil {
+				return errors.Wrapf(err, "writing player %s of team %d", pid, t.Id)
+			}
+		}
+	}
+
+	cw.Flush()
+
+	return errors.Wrap(cw.Error(), "writing dataset")
+}
+
+func readCSV(r io.Reader) (Dataset, error) {
+	var ds Dataset
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return ds, errors.Wrap(err, "reading dataset")
+	}
+
+	if len(rows) == 0 {
+		return ds, nil
+	}
+
+	seenTeams := map[football.TeamId]struct{}{}
+	seenPlayers := map[football.PlayerId]struct{}{}
+
+	// The first row is the header.
+	for i, row := range rows[1:] {
+		id, err := strconv.Atoi(row[0])
+		if err != nil {
+			return ds, errors.Wrapf(err, "parsing team id on row %d", i+2)
+		}
+
+		national, err := strconv.ParseBool(row[2])
+		if err != nil {
+			return ds, errors.Wrapf(err, "parsing national flag on row %d", i+2)
+		}
+
+		tid := football.TeamId(id)
+		if _, ok := seenTeams[tid]; !ok {
+			seenTeams[tid] = struct{}{}
+			ds.Teams = append(ds.Teams, Team{tid, row[1], national})
+		}
+
+		if row[3] == "" {
+			continue
+		}
+
+		age, err := strconv.Atoi(row[5])
+		if err != nil {
+			return ds, errors.Wrapf(err, "parsing player age on row %d", i+2)
+		}
+
+		pid := football.PlayerId(row[3])
+		if _, ok := seenPlayers[pid]; !ok {
+			seenPlayers[pid] = struct{}{}
+			ds.Players = append(ds.Players, Player{pid, row[4], age})
+		}
+
+		ds.Memberships = append(ds.Memberships, Membership{tid, pid})
+	}
+
+	return ds, nil
+}
@@ -0,0 +1,107 @@
+// +build go1.7
+
+package dump_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/dump"
+	"github.com/urandom/team-search-test/storage/memory"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range []dump.Format{dump.NDJSON, dump.CSV, dump.JSON} {
+		t.Run(string(f), func(t *testing.T) {
+			repo := newRepo(teams())
+
+			expected, err := dump.Collect(repo)
+			if err != nil {
+				t.Fatalf("error collecting dataset: %+v", err)
+			}
+
+			var b bytes.Buffer
+			if err := dump.Export(&b, f, repo); err != nil {
+				t.Fatalf("error exporting: %+v", err)
+			}
+
+			imported, err := dump.Import(&b, f)
+			if err != nil {
+				t.Fatalf("error importing: %+v", err)
+			}
+
+			ds, err := dump.Collect(newRepo(imported))
+			if err != nil {
+				t.Fatalf("error collecting imported dataset: %+v", err)
+			}
+
+			if !reflect.DeepEqual(expected, ds) {
+				t.Fatalf("expected %+v, got %+v", expected, ds)
+			}
+		})
+	}
+}
+
+func TestDataset(t *testing.T) {
+	ds, err := dump.Collect(newRepo(teams()))
+	if err != nil {
+		t.Fatalf("error collecting dataset: %+v", err)
+	}
+
+	if len(ds.Teams) != 3 || len(ds.Players) != 3 || len(ds.Memberships) != 4 {
+		t.Fatalf("expected 3 teams, 3 players and 4 memberships, got %+v", ds)
+	}
+
+	if ds.Teams[0].Name != "Apoel FC" || ds.Teams[2].Name != "Test 1" || !ds.Teams[2].IsNational {
+		t.Fatalf("expected teams ordered by id, got %+v", ds.Teams)
+	}
+
+	if ds.Players[2].Name != "Tomás Vaclik" || ds.Players[2].Age != 27 {
+		t.Fatalf("unexpected player %+v", ds.Players[2])
+	}
+}
+
+func TestInvalidData(t *testing.T) {
+	cases := []struct {
+		format dump.Format
+		data   string
+	}{
+		{dump.NDJSON, `{"type":"coach","id":"1"}`},
+		{dump.NDJSON, `{"type":"membership","team":1,"player":"6"}`},
+		{dump.CSV, "team_id,team_name,national,player_id,player_name,player_age\nx,Apoel FC,false,,,\n"},
+		{dump.JSON, `{"teams": [`},
+	}
+
+	for _, tc := range cases {
+		if _, err := dump.Import(bytes.NewBufferString(tc.data), tc.format); err == nil {
+			t.Fatalf("expected an error importing %s", tc.data)
+		}
+	}
+}
+
+func newRepo(teams []download.Team) storage.Replayer {
+	data := make(chan download.Team, len(teams))
+	for _, d := range teams {
+		data <- d
+	}
+	close(data)
+
+	return memory.NewTeamRepository(data).(storage.Replayer)
+}
+
+func teams() []download.Team {
+	return []download.Team{
+		{Bytes: []byte(`{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+			{"id": "6", "name": "Nuno Morais", "age": "32"}
+		]}}}`), Id: 1},
+		{Bytes: []byte(`{"data": {"team": {"id": 50, "name": "D2", "players": []}}}`), Id: 50},
+		{Bytes: []byte(`{"data": {"team": {"id": 200, "name": "Test 1", "IsNational": true, "players": [
+			{"id": "235", "name": "Jaroslav Plasil", "age": 34},
+			{"id": "19492", "name": "Tomás Vaclik", "age": "27"},
+			{"id": "6", "name": "Nuno Morais", "age": "32"}
+		]}}}`), Id: 200},
+	}
+}
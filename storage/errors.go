@@ -1,27 +1,149 @@
 package storage
 
-// IsInitializer checks if the error value is an initializer error.
-func IsInitializer(err error) bool {
-	type init interface {
-		IsInitializer() bool
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound denotes a missing team or player.
+	ErrNotFound = errors.New("not found")
+	// ErrNotReady denotes a query whose context was done before the
+	// repository was initialized.
+	ErrNotReady = errors.New("not ready")
+	// ErrCorrupt denotes stored data that cannot be read back.
+	ErrCorrupt = errors.New("corrupt")
+	// ErrAmbiguous denotes a name matching more than one entry.
+	ErrAmbiguous = errors.New("ambiguous")
+	// ErrUnavailable denotes a repository that cannot serve any queries,
+	// such as one whose initialization has failed.
+	ErrUnavailable = errors.New("unavailable")
+	// ErrUnsupported denotes a query the repository doesn't implement.
+	ErrUnsupported = errors.New("unsupported")
+)
+
+const (
+	// EntityTeam is the entity of team errors.
+	EntityTeam = "team"
+	// EntityPlayer is the entity of player errors.
+	EntityPlayer = "player"
+)
+
+// Error is a repository error of a given kind, concerning an entity. It
+// matches its kind with errors.Is, and can be extracted with errors.As.
+type Error struct {
+	// Kind is one of the sentinel errors, such as ErrNotFound.
+	Kind error
+	// Entity is the kind of the concerned entity, such as EntityTeam.
+	Entity string
+	// Key identifies the concerned entity, such as its id or name. It is
+	// empty for queries not concerning a single entity.
+	Key string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+
+	if e.Entity != "" {
+		msg += ": " + e.Entity
+		if e.Key != "" {
+			msg += " " + e.Key
+		}
 	}
 
-	if i, ok := err.(init); ok {
-		return ok && i.IsInitializer()
-	} else {
-		return false
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
 	}
+
+	return msg
 }
 
-// IsNotFound checks if the error value is a not-found error.
-func IsNotFound(err error) bool {
-	type notFound interface {
-		IsNotFound() bool
-	}
+// Is reports whether the target is the kind of the error.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// NotFound creates an ErrNotFound error for the entity with the key.
+func NotFound(entity string, key interface{}, cause error) error {
+	return newError(ErrNotFound, entity, key, cause)
+}
+
+// NotReady creates an ErrNotReady error for the entity with the key.
+func NotReady(entity string, key interface{}, cause error) error {
+	return newError(ErrNotReady, entity, key, cause)
+}
+
+// Corrupt creates an ErrCorrupt error for the entity with the key.
+func Corrupt(entity string, key interface{}, cause error) error {
+	return newError(ErrCorrupt, entity, key, cause)
+}
 
-	if nf, ok := err.(notFound); ok {
-		return ok && nf.IsNotFound()
-	} else {
-		return false
+// Ambiguous creates an ErrAmbiguous error for the entity with the key.
+func Ambiguous(entity string, key interface{}, cause error) error {
+	return newError(ErrAmbiguous, entity, key, cause)
+}
+
+// Unavailable creates an ErrUnavailable error for the entity with the key.
+func Unavailable(entity string, key interface{}, cause error) error {
+	return newError(ErrUnavailable, entity, key, cause)
+}
+
+// Unsupported creates an ErrUnsupported error for the entity with the key.
+func Unsupported(entity string, key interface{}, cause error) error {
+	return newError(ErrUnsupported, entity, key, cause)
+}
+
+func newError(kind error, entity string, key interface{}, cause error) error {
+	e := &Error{Kind: kind, Entity: entity, Err: cause}
+	if key != nil {
+		e.Key = fmt.Sprint(key)
 	}
+
+	return e
+}
+
+// IsInitializer checks if the error value is an initializer error, which is
+// an ErrUnavailable error.
+func IsInitializer(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
+
+// IsNotFound checks if the error value is an ErrNotFound error.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsAmbiguous checks if the error value is returned due to a name matching
+// more than one entry.
+func IsAmbiguous(err error) bool {
+	return errors.Is(err, ErrAmbiguous)
+}
+
+// IsNotReady checks if the error value is returned due to the repository not
+// being initialized before the query context was done.
+func IsNotReady(err error) bool {
+	return errors.Is(err, ErrNotReady)
+}
+
+// IsUnsupported checks if the error value is returned due to the repository
+// not implementing the query.
+func IsUnsupported(err error) bool {
+	return errors.Is(err, ErrUnsupported)
+}
+
+// IsCorrupt checks if the error value is returned due to unreadable stored
+// data.
+func IsCorrupt(err error) bool {
+	return errors.Is(err, ErrCorrupt)
 }
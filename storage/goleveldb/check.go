@@ -0,0 +1,407 @@
+package goleveldb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+// IssueKind is the kind of an inconsistency in the database.
+type IssueKind int
+
+const (
+	// CorruptFiles denotes database files which had to be recovered.
+	CorruptFiles IssueKind = iota + 1
+	// Undecodable denotes a record which cannot be decoded.
+	Undecodable
+	// DanglingIndex denotes an index entry of a missing team or player.
+	DanglingIndex
+	// AsymmetricMembership denotes a team listing a player who doesn't list
+	// the team, or the other way around.
+	AsymmetricMembership
+	// DuplicateMembership denotes a team id listed more than once by a
+	// player.
+	DuplicateMembership
+)
+
+var issueKinds = map[IssueKind]string{
+	CorruptFiles:         "corrupt files",
+	Undecodable:          "undecodable record",
+	DanglingIndex:        "dangling index entry",
+	AsymmetricMembership: "asymmetric membership",
+	DuplicateMembership:  "duplicate membership",
+}
+
+func (k IssueKind) String() string {
+	if s, ok := issueKinds[k]; ok {
+		return s
+	}
+
+	return "unknown issue"
+}
+
+// Issue is an inconsistency found in the database.
+type Issue struct {
+	Kind IssueKind
+	// Key is the database key of the affected record.
+	Key    string
+	Detail string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s %q: %s", i.Kind, i.Key, i.Detail)
+}
+
+// checker collects the issues of a database, along with the changes fixing
+// them.
+type checker struct {
+	db      *database
+	issues  []Issue
+	deleted *leveldb.Batch
+	refresh bool
+
+	teams   map[football.TeamId]football.Team
+	players map[football.PlayerId]football.Player
+
+	changedTeams   map[football.TeamId]bool
+	changedPlayers map[football.PlayerId]bool
+}
+
+// Check verifies that every record of the current generation at the path
+// decodes, that every index entry refers to an existing team or player, and
+// that the teams and their players list each other exactly once. The
+// generation is opened read only, and may be in use by other repositories.
+func Check(opts ...Option) ([]Issue, error) {
+	o := options{path: defaultPath}
+	o.apply(opts)
+
+	path, err := generationPath(o.path)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		if lerrors.IsCorrupted(err) {
+			return nil, storage.Corrupt("", nil, errors.Wrapf(err, "opening database %s", path))
+		}
+
+		return nil, storage.Unavailable("", nil, errors.Wrapf(err, "opening database %s", path))
+	}
+	defer db.Close()
+
+	d, err := withCipher(db, o.key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newChecker(d)
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	return c.issues, nil
+}
+
+// Repair fixes the issues reported by Check, and returns them. Corrupt
+// database files are recovered first, which may lose some of the records.
+// Undecodable records and dangling index entries are removed, and the
+// memberships are made to follow the players listed by the teams. If any team
+// or player had to be removed, the stored data is marked as stale, so that it
+// is refreshed when next opened. The current generation is repaired in place,
+// and must not be in use by any repository.
+func Repair(opts ...Option) ([]Issue, error) {
+	o := options{path: defaultPath}
+	o.apply(opts)
+
+	path, unlock, err := lockGeneration(o.path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	issues := []Issue{}
+
+	db, err := leveldb.OpenFile(path, &opt.Options{ErrorIfMissing: true})
+	if lerrors.IsCorrupted(err) {
+		issues = append(issues, Issue{Kind: CorruptFiles, Detail: err.Error()})
+		db, err = leveldb.RecoverFile(path, nil)
+	}
+
+	if err != nil {
+		return nil, storage.Unavailable("", nil, errors.Wrapf(err, "opening database %s", path))
+	}
+	defer db.Close()
+
+	d, err := withCipher(db, o.key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newChecker(d)
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+
+	if err := c.repair(); err != nil {
+		return nil, err
+	}
+
+	return append(issues, c.issues...), nil
+}
+
+func newChecker(db *database) *checker {
+	return &checker{
+		db:             db,
+		issues:         []Issue{},
+		deleted:        &leveldb.Batch{},
+		teams:          map[football.TeamId]football.Team{},
+		players:        map[football.PlayerId]football.Player{},
+		changedTeams:   map[football.TeamId]bool{},
+		changedPlayers: map[football.PlayerId]bool{},
+	}
+}
+
+func (c *checker) check() error {
+	if err := c.scan(teamPrefix, c.checkTeam); err != nil {
+		return err
+	}
+
+	if err := c.scan(playerPrefix, c.checkPlayer); err != nil {
+		return err
+	}
+
+	if err := c.scan(historyPrefix, c.checkVersion); err != nil {
+		return err
+	}
+
+	for _, prefix := range []string{teamNameIndexPrefix, aliasIndexPrefix, shortIndexPrefix} {
+		if err := c.scan(prefix, c.checkTeamIndex); err != nil {
+			return err
+		}
+	}
+
+	if err := c.scan(playerIndexPrefix, c.checkPlayerIndex); err != nil {
+		return err
+	}
+
+	c.checkMemberships()
+
+	return nil
+}
+
+// scan checks the records with the prefix. The check returns the kind of the
+// issue with the record and its details, or 0 if there are none. Records with
+// issues are deleted when repairing.
+func (c *checker) scan(prefix string, check func(key string, value []byte) (IssueKind, string)) error {
+	iter := c.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := string(iter.Key()[len(prefix):])
+
+		if kind, detail := check(key, iter.Value()); kind != 0 {
+			c.issues = append(c.issues, Issue{Kind: kind, Key: string(iter.Key()), Detail: detail})
+			c.deleted.Delete(append([]byte{}, iter.Key()...))
+		}
+	}
+
+	return errors.Wrapf(iter.Error(), "iterating over %s keys", prefix)
+}
+
+func (c *checker) checkTeam(key string, value []byte) (IssueKind, string) {
+	t := football.Team{}
+	if err := c.db.decode([]byte(teamPrefix+key), value, &t); err != nil {
+		c.refresh = true
+		return Undecodable, err.Error()
+	}
+
+	if strconv.Itoa(int(t.Id)) != key {
+		c.refresh = true
+		return Undecodable, fmt.Sprintf("stored team %d", t.Id)
+	}
+
+	c.teams[t.Id] = t
+	return 0, ""
+}
+
+func (c *checker) checkPlayer(key string, value []byte) (IssueKind, string) {
+	p := football.Player{}
+	if err := c.db.decode([]byte(playerPrefix+key), value, &p); err != nil {
+		c.refresh = true
+		return Undecodable, err.Error()
+	}
+
+	if string(p.Id) != key {
+		c.refresh = true
+		return Undecodable, fmt.Sprintf("stored player %s", p.Id)
+	}
+
+	c.players[p.Id] = p
+	return 0, ""
+}
+
+func (c *checker) checkVersion(key string, value []byte) (IssueKind, string) {
+	sep := strings.LastIndexByte(key, 0)
+	if sep == -1 {
+		return Undecodable, "missing version time"
+	}
+
+	if _, err := strconv.ParseInt(key[sep+1:], 10, 64); err != nil {
+		return Undecodable, err.Error()
+	}
+
+	v := teamVersion{}
+	if err := c.db.decode([]byte(historyPrefix+key), value, &v); err != nil {
+		return Undecodable, err.Error()
+	}
+
+	return 0, ""
+}
+
+func (c *checker) checkTeamIndex(key string, value []byte) (IssueKind, string) {
+	sep := strings.LastIndexByte(key, 0)
+	if sep == -1 {
+		return Undecodable, "missing team id"
+	}
+
+	id, err := strconv.Atoi(key[sep+1:])
+	if err != nil {
+		return Undecodable, err.Error()
+	}
+
+	if _, ok := c.teams[football.TeamId(id)]; !ok {
+		return DanglingIndex, fmt.Sprintf("team %d doesn't exist", id)
+	}
+
+	return 0, ""
+}
+
+func (c *checker) checkPlayerIndex(key string, value []byte) (IssueKind, string) {
+	sep := strings.LastIndexByte(key, 0)
+	if sep == -1 {
+		return Undecodable, "missing player id"
+	}
+
+	id := football.PlayerId(key[sep+1:])
+	if _, ok := c.players[id]; !ok {
+		return DanglingIndex, fmt.Sprintf("player %s doesn't exist", id)
+	}
+
+	return 0, ""
+}
+
+// checkMemberships treats the players listed by the teams as the correct
+// memberships, since every team is stored along with all of its players.
+func (c *checker) checkMemberships() {
+	teams := make([]football.Team, 0, len(c.teams))
+	for _, t := range c.teams {
+		teams = append(teams, t)
+	}
+	sort.Sort(teamsById(teams))
+
+	members := map[football.TeamId]map[football.PlayerId]bool{}
+
+	for _, t := range teams {
+		key := fmt.Sprintf("%s%v", teamPrefix, t.Id)
+		players := make([]football.PlayerId, 0, len(t.Players))
+		members[t.Id] = map[football.PlayerId]bool{}
+
+		for _, pid := range t.Players {
+			p, ok := c.players[pid]
+			if !ok {
+				c.issues = append(c.issues, Issue{
+					Kind: AsymmetricMembership, Key: key, Detail: fmt.Sprintf("player %s doesn't exist", pid),
+				})
+				c.changedTeams[t.Id] = true
+				continue
+			}
+
+			players = append(players, pid)
+			members[t.Id][pid] = true
+
+			if !memberOf(p, t.Id) {
+				c.issues = append(c.issues, Issue{
+					Kind: AsymmetricMembership, Key: key, Detail: fmt.Sprintf("player %s doesn't list the team", pid),
+				})
+				p.Teams = append(p.Teams, t.Id)
+				c.players[pid] = p
+				c.changedPlayers[pid] = true
+			}
+		}
+
+		t.Players = players
+		c.teams[t.Id] = t
+	}
+
+	ids := make([]string, 0, len(c.players))
+	for id := range c.players {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		p := c.players[football.PlayerId(id)]
+		key := fmt.Sprintf("%s%v", playerPrefix, p.Id)
+
+		listed := map[football.TeamId]bool{}
+		teams := make([]football.TeamId, 0, len(p.Teams))
+
+		for _, tid := range p.Teams {
+			switch {
+			case listed[tid]:
+				c.issues = append(c.issues, Issue{
+					Kind: DuplicateMembership, Key: key, Detail: fmt.Sprintf("team %d is listed more than once", tid),
+				})
+			case !members[tid][p.Id]:
+				c.issues = append(c.issues, Issue{
+					Kind: AsymmetricMembership, Key: key, Detail: fmt.Sprintf("team %d doesn't list the player", tid),
+				})
+			default:
+				teams = append(teams, tid)
+			}
+
+			listed[tid] = true
+		}
+
+		if len(teams) != len(p.Teams) {
+			p.Teams = teams
+			c.players[p.Id] = p
+			c.changedPlayers[p.Id] = true
+		}
+	}
+}
+
+// repair writes the changes fixing the found issues.
+func (c *checker) repair() error {
+	if c.refresh {
+		c.deleted.Delete(updateTimestampKey)
+	}
+
+	if err := c.db.Write(c.deleted, nil); err != nil {
+		return errors.Wrap(err, "deleting records")
+	}
+
+	for id := range c.changedTeams {
+		if err := putTeam(c.db, c.teams[id]); err != nil {
+			return errors.Wrap(err, "repairing team")
+		}
+	}
+
+	for id := range c.changedPlayers {
+		if err := putPlayer(c.db, c.players[id]); err != nil {
+			return errors.Wrap(err, "repairing player")
+		}
+	}
+
+	return nil
+}
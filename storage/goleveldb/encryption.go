@@ -0,0 +1,391 @@
+package goleveldb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
+)
+
+// KeySize is the size of an encryption key, in bytes.
+const KeySize = 32
+
+// encryptionCheck is sealed under the encryption check key, so that a wrong
+// key, or a missing one, is detected before any record is read.
+var encryptionCheck = []byte("team-search-test")
+
+// indexLabel is the message whose HMAC, keyed by the encryption key, is the
+// key of the name index HMACs.
+var indexLabel = []byte("team-search-test name index")
+
+// cipherKeys are derived from an encryption key: the cipher of the values,
+// and the key of the name index HMACs.
+type cipherKeys struct {
+	aead  cipher.AEAD
+	index []byte
+}
+
+// database is a goleveldb database, whose team, player and history values are
+// encrypted with AES-256-GCM if it has a cipher. The value key is used as
+// additional data, so that values cannot be moved between keys. The names in
+// its index keys are replaced by their HMAC-SHA256 as well.
+type database struct {
+	*leveldb.DB
+	cipherKeys
+}
+
+// ParseKey decodes a hex or base64 encoded encryption key, as read from a file
+// or an environment variable. Surrounding whitespace is ignored.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("decoding encryption key, expected hex or base64")
+		}
+	}
+
+	if len(key) != KeySize {
+		return nil, errors.Errorf("expected a %d byte encryption key, got %d bytes", KeySize, len(key))
+	}
+
+	return key, nil
+}
+
+// newCipher creates the cipher keys of the key, or none if there is no key.
+func newCipher(key []byte) (cipherKeys, error) {
+	if key == nil {
+		return cipherKeys{}, nil
+	}
+
+	if len(key) != KeySize {
+		return cipherKeys{}, errors.Errorf("expected a %d byte encryption key, got %d bytes", KeySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return cipherKeys{}, errors.Wrap(err, "creating encryption cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return cipherKeys{}, errors.Wrap(err, "creating encryption cipher")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(indexLabel)
+
+	return cipherKeys{aead: aead, index: mac.Sum(nil)}, nil
+}
+
+// withCipher wraps the database with the cipher of the key, after verifying
+// that its data is encrypted with that key, or not at all if there is none.
+func withCipher(db *leveldb.DB, key []byte) (*database, error) {
+	keys, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &database{DB: db, cipherKeys: keys}
+	return d, d.verify()
+}
+
+// encode encodes the value of the key, encrypting it if needed.
+func (db *database) encode(key []byte, v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return db.seal(key, b.Bytes())
+}
+
+// decode decodes the value of the key, decrypting it if needed.
+func (db *database) decode(key []byte, value []byte, v interface{}) error {
+	plain, err := db.open(key, value)
+	if err != nil {
+		return err
+	}
+
+	return gob.NewDecoder(bytes.NewReader(plain)).Decode(v)
+}
+
+func (db *database) seal(key []byte, value []byte) ([]byte, error) {
+	if db.aead == nil {
+		return value, nil
+	}
+
+	nonce := make([]byte, db.aead.NonceSize(), db.aead.NonceSize()+len(value)+db.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+
+	return db.aead.Seal(nonce, nonce, value, key), nil
+}
+
+func (db *database) open(key []byte, value []byte) ([]byte, error) {
+	if db.aead == nil {
+		return value, nil
+	}
+
+	if len(value) < db.aead.NonceSize() {
+		return nil, errors.New("decrypting value: too short")
+	}
+
+	n := db.aead.NonceSize()
+	plain, err := db.aead.Open(nil, value[:n], value[n:], key)
+
+	return plain, errors.Wrap(err, "decrypting value")
+}
+
+// verify checks whether the database is encrypted with the cipher, if it has
+// one, or not encrypted at all otherwise.
+func (db *database) verify() error {
+	check, err := db.Get(encryptionCheckKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return errors.Wrap(err, "getting encryption check")
+	}
+
+	switch {
+	case err == leveldb.ErrNotFound && db.aead == nil:
+		return nil
+	case err == leveldb.ErrNotFound:
+		return storage.Unavailable("", nil, errors.New("the stored data isn't encrypted, re-encrypt it first"))
+	case db.aead == nil:
+		return storage.Unavailable("", nil, errors.New("the stored data is encrypted, an encryption key is required"))
+	}
+
+	plain, err := db.open(encryptionCheckKey, check)
+	if err != nil || !bytes.Equal(plain, encryptionCheck) {
+		return storage.Unavailable("", nil, errors.New("the stored data is encrypted with a different key"))
+	}
+
+	return nil
+}
+
+// mark adds the encryption check of the cipher to the batch, or removes it if
+// there is no cipher.
+func (db *database) mark(batch *leveldb.Batch) error {
+	if db.aead == nil {
+		batch.Delete(encryptionCheckKey)
+		return nil
+	}
+
+	check, err := db.seal(encryptionCheckKey, encryptionCheck)
+	if err != nil {
+		return err
+	}
+
+	batch.Put(encryptionCheckKey, check)
+	return nil
+}
+
+// Reencrypt stores the current generation at the path in a new one, whose
+// records are encrypted with the key, and publishes it. The key given with the
+// Encryption option is the one the current generation is encrypted with.
+// Either key may be nil, to encrypt plain data, or to store it in plain form.
+// Repositories using the path only switch to the new generation once they use
+// the new key as well.
+func Reencrypt(key []byte, opts ...Option) error {
+	o := options{path: defaultPath}
+	o.apply(opts)
+
+	keys, err := newCipher(key)
+	if err != nil {
+		return err
+	}
+
+	path, unlock, err := lockGeneration(o.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	opened, err := openMaintained(path, true)
+	if err != nil {
+		return err
+	}
+	defer opened.Close()
+
+	current, err := withCipher(opened, o.key)
+	if err != nil {
+		return err
+	}
+
+	name, err := nextGeneration(o.path)
+	if err != nil {
+		return err
+	}
+
+	created, err := leveldb.OpenFile(filepath.Join(o.path, name), nil)
+	if err != nil {
+		return errors.Wrapf(err, "creating generation %s", name)
+	}
+
+	db := &database{DB: created, cipherKeys: keys}
+	if err := reencryptRecords(current, db); err != nil {
+		db.Close()
+		return err
+	}
+
+	current.Close()
+
+	if err := db.Close(); err != nil {
+		return errors.Wrapf(err, "closing generation %s", name)
+	}
+
+	if err := publish(o.path, name); err != nil {
+		return err
+	}
+
+	return removeStale(o.path, name)
+}
+
+// reencryptRecords copies all records from one database to another, each
+// with its own cipher. The name index entries are stored anew with the HMACs
+// of the other database.
+func reencryptRecords(from *database, to *database) error {
+	iter := from.NewIterator(nil, nil)
+	defer iter.Release()
+
+	batch := &leveldb.Batch{}
+	for iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		if bytes.Equal(key, encryptionCheckKey) || indexed(key) {
+			continue
+		}
+
+		if encrypted(key) {
+			plain, err := from.open(key, value)
+			if err != nil {
+				return storage.Corrupt("", string(key), err)
+			}
+
+			entries, err := to.recordIndex(key, plain)
+			if err != nil {
+				return storage.Corrupt("", string(key), err)
+			}
+
+			for _, e := range entries {
+				batch.Put([]byte(e), nil)
+			}
+
+			if value, err = to.seal(key, plain); err != nil {
+				return err
+			}
+		}
+
+		batch.Put(key, value)
+
+		if batch.Len() >= copyBatchSize {
+			if err := to.Write(batch, nil); err != nil {
+				return errors.Wrap(err, "copying records")
+			}
+
+			batch.Reset()
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return errors.Wrap(err, "iterating over records")
+	}
+
+	if err := to.mark(batch); err != nil {
+		return err
+	}
+
+	return errors.Wrap(to.Write(batch, nil), "copying records")
+}
+
+// encrypted checks whether the value of the key is encrypted, as are those of
+// the teams, players and their history. The index entries have no values.
+func encrypted(key []byte) bool {
+	for _, prefix := range []string{teamPrefix, playerPrefix, historyPrefix} {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// indexed checks whether the key is a name index entry.
+func indexed(key []byte) bool {
+	for _, prefix := range []string{teamNameIndexPrefix, aliasIndexPrefix, shortIndexPrefix, playerIndexPrefix} {
+		if bytes.HasPrefix(key, []byte(prefix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// indexName returns the name as it is stored in the index keys: its HMAC if
+// the database is encrypted, or the name itself otherwise.
+func (db *database) indexName(name string) string {
+	if db.index == nil {
+		return name
+	}
+
+	mac := hmac.New(sha256.New, db.index)
+	mac.Write([]byte(name))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// teamIndex returns the name, alias and short name index keys of the team.
+func (db *database) teamIndex(t football.Team) []string {
+	keys := []string{indexKey(teamNameIndexPrefix, db.indexName(t.Name), t.Id)}
+	for _, v := range alias.Variants(t.Name) {
+		keys = append(keys, indexKey(aliasIndexPrefix, db.indexName(v), t.Id))
+	}
+
+	if short := alias.Short(t.Name); short != "" {
+		keys = append(keys, indexKey(shortIndexPrefix, db.indexName(short), t.Id))
+	}
+
+	return keys
+}
+
+// playerIndex returns the name index key of the player.
+func (db *database) playerIndex(p football.Player) string {
+	return indexKey(playerIndexPrefix, db.indexName(alias.Normalize(p.Name)), p.Id)
+}
+
+// recordIndex returns the index keys of the team or player record with the
+// key and plain value, or none for other records.
+func (db *database) recordIndex(key []byte, plain []byte) ([]string, error) {
+	switch {
+	case bytes.HasPrefix(key, []byte(teamPrefix)):
+		t := football.Team{}
+		if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&t); err != nil {
+			return nil, err
+		}
+
+		return db.teamIndex(t), nil
+	case bytes.HasPrefix(key, []byte(playerPrefix)):
+		p := football.Player{}
+		if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&p); err != nil {
+			return nil, err
+		}
+
+		return []string{db.playerIndex(p)}, nil
+	}
+
+	return nil, nil
+}
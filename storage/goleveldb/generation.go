@@ -0,0 +1,423 @@
+package goleveldb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	lstorage "github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+// The data is stored in generations, each a separate goleveldb database under
+// the storage path. Only one process at a time writes a new generation, while
+// holding the writer lock, and then publishes it by atomically replacing the
+// generation file. All processes serve the published generation read only,
+// which goleveldb allows any number of processes to do at once.
+const (
+	generationFile   = "current_generation"
+	generationPrefix = "generation-"
+	writerLock       = "writer"
+	copyBatchSize    = 1000
+)
+
+// update stores the download data in a new generation, if the current one is
+// missing or stale, or the Refresh option is given. The current generation is
+// used as is if another process is writing one meanwhile, unless there isn't
+// any or Refresh is given, in which case that process is waited for.
+func (ldb *ldb) update(data <-chan download.Team) error {
+	root := ldb.opts.path
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return errors.Wrapf(err, "creating storage directory %s", root)
+	}
+
+	var lock lstorage.Storage
+	for {
+		var err error
+		if lock, err = lstorage.OpenFile(filepath.Join(root, writerLock), false); err == nil {
+			break
+		}
+
+		current, err := currentGeneration(root)
+		if err != nil {
+			return err
+		}
+
+		if current != "" && !ldb.opts.refresh {
+			return nil
+		}
+
+		select {
+		case <-ldb.done:
+			return errors.New("closed while waiting for another process to store the data")
+		case <-time.After(ldb.opts.poll):
+		}
+	}
+	defer lock.Close()
+
+	current, err := currentGeneration(root)
+	if err != nil {
+		return err
+	}
+
+	var previous *database
+	if current != "" {
+		if previous, err = openGeneration(root, current, ldb.keys); err != nil {
+			return err
+		}
+		defer previous.Close()
+
+		if !ldb.opts.refresh {
+			stale, err := ldb.stale(previous)
+			if err != nil || !stale {
+				return err
+			}
+		}
+	}
+
+	name, err := nextGeneration(root)
+	if err != nil {
+		return err
+	}
+
+	created, err := leveldb.OpenFile(filepath.Join(root, name), nil)
+	if err != nil {
+		return errors.Wrapf(err, "creating generation %s", name)
+	}
+
+	db := &database{DB: created, cipherKeys: ldb.keys}
+
+	// Only the history of the previous generation carries over, while its
+	// teams, players and their indices are stored anew from the data.
+	if previous != nil {
+		if err := copyHistory(previous, db); err != nil {
+			db.Close()
+			return err
+		}
+
+		previous.Close()
+	}
+
+	if err := ldb.refresh(db, data); err != nil {
+		db.Close()
+		return err
+	}
+
+	if err := db.Close(); err != nil {
+		return errors.Wrapf(err, "closing generation %s", name)
+	}
+
+	if err := publish(root, name); err != nil {
+		return err
+	}
+
+	return removeStale(root, name)
+}
+
+// watch switches to any newer generation, published by this or another
+// process, until the repository is closed. A failed switch is reported in the
+// status and retried with the next poll, unless the generation is encrypted
+// with a different key, which takes a restart with that key to serve.
+func (ldb *ldb) watch() {
+	ticker := time.NewTicker(ldb.opts.poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ldb.done:
+			return
+		case <-ticker.C:
+		}
+
+		name, err := currentGeneration(ldb.opts.path)
+		if err != nil || name == "" {
+			continue
+		}
+
+		ldb.dbMu.RLock()
+		current := ldb.generation
+		ldb.dbMu.RUnlock()
+
+		if name == current {
+			continue
+		}
+
+		err = ldb.switchTo(name)
+		ldb.setStatus(func(s *storage.Status) {
+			s.Err = err
+		})
+
+		if storage.IsInitializer(err) {
+			return
+		}
+	}
+}
+
+// switchTo opens the generation read only, and serves it instead of the
+// current one. The changes between the two are then published to the
+// subscribers.
+func (ldb *ldb) switchTo(name string) error {
+	db, err := openGeneration(ldb.opts.path, name, ldb.keys)
+	if err != nil {
+		return err
+	}
+
+	if err := ldb.loadStatus(db); err != nil {
+		db.Close()
+		return err
+	}
+
+	// Only the watch replaces the current generation, so that it doesn't
+	// change until the new one is served.
+	var changes []football.Change
+	if ldb.publisher.Subscribed() {
+		current, release := ldb.acquire()
+		if current != nil {
+			changes, err = generationChanges(current, db)
+		}
+		release()
+
+		if err != nil {
+			db.Close()
+			return err
+		}
+	}
+
+	ldb.dbMu.Lock()
+	select {
+	case <-ldb.done:
+		ldb.dbMu.Unlock()
+		return db.Close()
+	default:
+	}
+
+	previous := ldb.db
+	ldb.db, ldb.generation = db, name
+	ldb.dbMu.Unlock()
+
+	if previous == nil {
+		return nil
+	}
+
+	err = previous.Close()
+	ldb.publisher.Publish(changes)
+
+	return errors.Wrap(err, "closing previous generation")
+}
+
+func (ldb *ldb) Subscribe(ctx context.Context) <-chan football.Change {
+	return ldb.publisher.Subscribe(ctx)
+}
+
+// generationChanges compares the teams and players of two generations.
+func generationChanges(from *database, to *database) ([]football.Change, error) {
+	oldTeams, oldPlayers, err := generationData(from)
+	if err != nil {
+		return nil, err
+	}
+
+	newTeams, newPlayers, err := generationData(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return football.Changes(oldTeams, newTeams, oldPlayers, newPlayers), nil
+}
+
+// generationData returns the teams and players of the generation by id.
+func generationData(db *database) (map[football.TeamId]football.Team, map[football.PlayerId]football.Player, error) {
+	teams, err := getTeams(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	players, err := getPlayers(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byId := make(map[football.TeamId]football.Team, len(teams))
+	for _, t := range teams {
+		byId[t.Id] = t
+	}
+
+	playersById := make(map[football.PlayerId]football.Player, len(players))
+	for _, p := range players {
+		playersById[p.Id] = p
+	}
+
+	return byId, playersById, nil
+}
+
+// acquire returns the database of the current generation, which isn't closed
+// until it is released.
+func (ldb *ldb) acquire() (*database, func()) {
+	ldb.dbMu.RLock()
+	return ldb.db, ldb.dbMu.RUnlock
+}
+
+// currentGeneration returns the name of the published generation under the
+// root, or an empty string if there isn't one.
+func currentGeneration(root string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(root, generationFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+
+	return string(bytes.TrimSpace(b)), errors.Wrap(err, "reading current generation")
+}
+
+// generationPath returns the directory of the published generation under the
+// root.
+func generationPath(root string) (string, error) {
+	name, err := currentGeneration(root)
+	if err != nil {
+		return "", err
+	}
+
+	if name == "" {
+		return "", storage.Unavailable("", nil, errors.Errorf("no data stored in %s", root))
+	}
+
+	return filepath.Join(root, name), nil
+}
+
+// lockGeneration acquires the writer lock, so that the published generation
+// can be modified in place, and returns its directory. The lock is held until
+// the returned function is called.
+func lockGeneration(root string) (string, func(), error) {
+	lock, err := lstorage.OpenFile(filepath.Join(root, writerLock), false)
+	if err != nil {
+		return "", nil, storage.Unavailable("", nil, errors.Wrapf(err, "locking %s, which another process may be refreshing", root))
+	}
+
+	path, err := generationPath(root)
+	if err != nil {
+		lock.Close()
+		return "", nil, err
+	}
+
+	return path, func() { lock.Close() }, nil
+}
+
+// openGeneration opens the generation under the root read only, verifying
+// that it is encrypted with the cipher keys, if any.
+func openGeneration(root string, name string, keys cipherKeys) (*database, error) {
+	opened, err := leveldb.OpenFile(filepath.Join(root, name), &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening generation %s", name)
+	}
+
+	db := &database{DB: opened, cipherKeys: keys}
+	if err := db.verify(); err != nil {
+		opened.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// nextGeneration returns a name following all generations under the root,
+// including any left behind by interrupted writers.
+func nextGeneration(root string) (string, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading storage directory %s", root)
+	}
+
+	last := 0
+	for _, e := range entries {
+		if n, ok := generationNumber(e); ok && n > last {
+			last = n
+		}
+	}
+
+	return fmt.Sprintf("%s%06d", generationPrefix, last+1), nil
+}
+
+// publish atomically makes the generation the current one.
+func publish(root string, name string) error {
+	tmp := filepath.Join(root, generationFile+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(name), 0644); err != nil {
+		return errors.Wrapf(err, "writing generation %s", name)
+	}
+
+	return errors.Wrapf(os.Rename(tmp, filepath.Join(root, generationFile)), "publishing generation %s", name)
+}
+
+// removeStale removes the generations under the root, other than the current
+// one, which no process has open.
+func removeStale(root string, current string) error {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return errors.Wrapf(err, "reading storage directory %s", root)
+	}
+
+	for _, e := range entries {
+		if _, ok := generationNumber(e); !ok || e.Name() == current {
+			continue
+		}
+
+		path := filepath.Join(root, e.Name())
+
+		// Generations still in use are removed by a later refresh.
+		s, err := lstorage.OpenFile(path, false)
+		if err != nil {
+			continue
+		}
+		s.Close()
+
+		if err := os.RemoveAll(path); err != nil {
+			return errors.Wrapf(err, "removing generation %s", e.Name())
+		}
+	}
+
+	return nil
+}
+
+func generationNumber(info os.FileInfo) (int, bool) {
+	if !info.IsDir() || !strings.HasPrefix(info.Name(), generationPrefix) {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(info.Name()[len(generationPrefix):])
+	return n, err == nil
+}
+
+// copyHistory copies the team history records from one database to another.
+func copyHistory(from *database, to *database) error {
+	iter := from.NewIterator(util.BytesPrefix([]byte(historyPrefix)), nil)
+	defer iter.Release()
+
+	batch := &leveldb.Batch{}
+	for iter.Next() {
+		batch.Put(iter.Key(), iter.Value())
+
+		if batch.Len() == copyBatchSize {
+			if err := to.Write(batch, nil); err != nil {
+				return errors.Wrap(err, "copying team history")
+			}
+
+			batch.Reset()
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return errors.Wrap(err, "iterating over team history")
+	}
+
+	return errors.Wrap(to.Write(batch, nil), "copying team history")
+}
@@ -0,0 +1,306 @@
+package goleveldb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
+)
+
+// teamVersion is a stored version of a team, along with its players as they
+// were then, without their teams. Removed versions mark the refreshes which
+// no longer contained the team.
+type teamVersion struct {
+	Team    football.Team
+	Players []football.Player
+	Removed bool
+}
+
+// storedVersion is a team version along with when it was stored.
+type storedVersion struct {
+	teamVersion
+	at time.Time
+}
+
+func (ldb *ldb) GetTeamAt(id football.TeamId, at time.Time) (storage.TeamVersion, error) {
+	return ldb.GetTeamAtContext(context.Background(), id, at)
+}
+
+func (ldb *ldb) GetTeamAtContext(ctx context.Context, id football.TeamId, at time.Time) (storage.TeamVersion, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return storage.TeamVersion{}, storage.NotReady(storage.EntityTeam, nil, errors.Wrapf(err, "getting team %d at %s", id, at))
+	}
+
+	if ldb.initError != nil {
+		return storage.TeamVersion{}, storage.Unavailable(storage.EntityTeam, id, ldb.initError)
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	versions, err := getVersions(db, id)
+	if err != nil {
+		return storage.TeamVersion{}, err
+	}
+
+	for _, v := range history(versions) {
+		if !v.From.After(at) && (v.To.IsZero() || v.To.After(at)) {
+			return v, nil
+		}
+	}
+
+	return storage.TeamVersion{}, storage.NotFound(storage.EntityTeam, id, errors.Errorf("no version at %s", at))
+}
+
+func (ldb *ldb) GetTeamHistory(id football.TeamId) ([]storage.TeamVersion, error) {
+	return ldb.GetTeamHistoryContext(context.Background(), id)
+}
+
+func (ldb *ldb) GetTeamHistoryContext(ctx context.Context, id football.TeamId) ([]storage.TeamVersion, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, nil, errors.Wrapf(err, "getting team %d history", id))
+	}
+
+	if ldb.initError != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, id, ldb.initError)
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	versions, err := getVersions(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tvs := history(versions)
+	if len(tvs) == 0 {
+		return nil, storage.NotFound(storage.EntityTeam, id, errors.New("no history"))
+	}
+
+	return tvs, nil
+}
+
+func (ldb *ldb) GetTeamIdsByPastName(name string) ([]football.TeamId, error) {
+	return ldb.GetTeamIdsByPastNameContext(context.Background(), name)
+}
+
+func (ldb *ldb) GetTeamIdsByPastNameContext(ctx context.Context, name string) ([]football.TeamId, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, name, errors.Wrapf(err, "getting teams formerly named %s", name))
+	}
+
+	if ldb.initError != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, name, ldb.initError)
+	}
+
+	canonical, aliased := ldb.opts.aliases.Canonical(name)
+
+	db, release := ldb.acquire()
+	defer release()
+
+	ids := []football.TeamId{}
+	seen := map[football.TeamId]bool{}
+
+	iter := db.NewIterator(util.BytesPrefix([]byte(historyPrefix)), nil)
+	for iter.Next() {
+		v := teamVersion{}
+		if err := db.decode(iter.Key(), iter.Value(), &v); err != nil {
+			iter.Release()
+			return nil, storage.Corrupt(storage.EntityTeam, name, errors.Wrapf(err, "decoding %s", iter.Key()))
+		}
+
+		id := v.Team.Id
+		if v.Removed || seen[id] {
+			continue
+		}
+
+		if alias.Matches(name, v.Team.Name) || aliased && canonical == v.Team.Name {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	iter.Release()
+
+	if err := iter.Error(); err != nil {
+		return nil, errors.Wrap(err, "iterating over team history")
+	}
+
+	if len(ids) == 0 {
+		return nil, storage.NotFound(storage.EntityTeam, name, errors.New("no version with the name"))
+	}
+
+	sort.Sort(teamIds(ids))
+
+	return ids, nil
+}
+
+// history returns the versions of a team, other than the removed ones, along
+// with the interval during which each was stored.
+func history(versions []storedVersion) []storage.TeamVersion {
+	tvs := []storage.TeamVersion{}
+	for i, v := range versions {
+		if v.Removed {
+			continue
+		}
+
+		tv := storage.TeamVersion{Team: v.Team, Players: v.Players, From: v.at}
+		if i+1 < len(versions) {
+			tv.To = versions[i+1].at
+		}
+
+		tvs = append(tvs, tv)
+	}
+
+	return tvs
+}
+
+// putVersion stores the team and its players as a new version at the given
+// time, unless it is the same as the latest one.
+func putVersion(db *database, team football.Team, players []football.Player, at time.Time) error {
+	versions, err := getVersions(db, team.Id)
+	if err != nil {
+		return err
+	}
+
+	snapshot := make([]football.Player, len(players))
+	for i, p := range players {
+		snapshot[i] = football.Player{Id: p.Id, Name: p.Name, Age: p.Age}
+	}
+
+	sort.Sort(football.PlayersById(snapshot))
+
+	v := teamVersion{Team: team, Players: snapshot}
+	if n := len(versions); n > 0 && !versions[n-1].Removed && sameVersion(versions[n-1].teamVersion, v) {
+		return nil
+	}
+
+	return writeVersion(db, v, at)
+}
+
+// removeMissing stores a removed version at the given time for every team
+// with a history which wasn't seen during the latest refresh.
+func removeMissing(db *database, seen map[football.TeamId]struct{}, at time.Time) error {
+	iter := db.NewIterator(util.BytesPrefix([]byte(historyPrefix)), nil)
+	ids := []football.TeamId{}
+	listed := map[football.TeamId]bool{}
+
+	for iter.Next() {
+		key := iter.Key()[len(historyPrefix):]
+
+		sep := bytes.IndexByte(key, 0)
+		if sep == -1 {
+			iter.Release()
+			return errors.Errorf("parsing team history key %s", iter.Key())
+		}
+
+		id, err := strconv.Atoi(string(key[:sep]))
+		if err != nil {
+			iter.Release()
+			return errors.Wrapf(err, "parsing team history key %s", iter.Key())
+		}
+
+		tid := football.TeamId(id)
+		if _, ok := seen[tid]; !ok && !listed[tid] {
+			listed[tid] = true
+			ids = append(ids, tid)
+		}
+	}
+
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return errors.Wrap(err, "iterating over team history")
+	}
+
+	for _, id := range ids {
+		versions, err := getVersions(db, id)
+		if err != nil {
+			return err
+		}
+
+		if n := len(versions); n == 0 || versions[n-1].Removed {
+			continue
+		}
+
+		if err := writeVersion(db, teamVersion{Team: football.Team{Id: id}, Removed: true}, at); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeVersion(db *database, v teamVersion, at time.Time) error {
+	key := historyKey(v.Team.Id, at)
+
+	value, err := db.encode(key, v)
+	if err != nil {
+		return errors.Wrapf(err, "encoding team %d version", v.Team.Id)
+	}
+
+	if err := db.Put(key, value, nil); err != nil {
+		return errors.Wrapf(err, "writing team %d version", v.Team.Id)
+	}
+
+	return nil
+}
+
+// getVersions returns all stored versions of a team, oldest first.
+func getVersions(db *database, id football.TeamId) ([]storedVersion, error) {
+	prefix := []byte(indexKey(historyPrefix, strconv.Itoa(int(id)), ""))
+
+	iter := db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	versions := []storedVersion{}
+	for iter.Next() {
+		nanos, err := strconv.ParseInt(string(iter.Key()[len(prefix):]), 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing team %d version key", id)
+		}
+
+		v := storedVersion{at: time.Unix(0, nanos)}
+		if err := db.decode(iter.Key(), iter.Value(), &v.teamVersion); err != nil {
+			return nil, storage.Corrupt(storage.EntityTeam, id, err)
+		}
+
+		versions = append(versions, v)
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, errors.Wrapf(err, "iterating over team %d versions", id)
+	}
+
+	return versions, nil
+}
+
+// historyKey orders the versions of a team by the time they were stored.
+func historyKey(id football.TeamId, at time.Time) []byte {
+	return []byte(indexKey(historyPrefix, strconv.Itoa(int(id)), fmt.Sprintf("%020d", at.UnixNano())))
+}
+
+// sameVersion checks whether the versions have the same team name and kind,
+// and the same players, with the same names and ages. The players of both are
+// ordered by id.
+func sameVersion(a, b teamVersion) bool {
+	if a.Team.Name != b.Team.Name || a.Team.IsNational != b.Team.IsNational || len(a.Players) != len(b.Players) {
+		return false
+	}
+
+	for i := range a.Players {
+		pa, pb := a.Players[i], b.Players[i]
+		if pa.Id != pb.Id || pa.Name != pb.Name || pa.Age != pb.Age {
+			return false
+		}
+	}
+
+	return true
+}
@@ -2,42 +2,80 @@ package goleveldb
 
 import (
 	"bytes"
-	"encoding/gob"
-	"encoding/json"
+	"context"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 	"github.com/urandom/team-search-test/download"
 	"github.com/urandom/team-search-test/football"
 	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
+	"github.com/urandom/team-search-test/storage/search"
+	"github.com/urandom/team-search-test/tracing"
 )
 
 type ldb struct {
 	opts      options
 	init      chan struct{}
 	initError error
-	db        *leveldb.DB
+	done      chan struct{}
+	once      sync.Once
+
+	// dbMu guards the database of the current generation, which is replaced
+	// once a newer one is published.
+	dbMu       sync.RWMutex
+	db         *database
+	generation string
+	keys       cipherKeys
+
+	mu     sync.Mutex
+	status storage.Status
+
+	publisher *storage.Publisher
 }
 
 type options struct {
-	path    string
-	refresh bool
+	path     string
+	refresh  bool
+	readOnly bool
+	poll     time.Duration
+	key      []byte
+	tracer   *tracing.Tracer
+	aliases  *alias.Set
 }
 
 var (
-	Refresh Option = refresh
+	Refresh  Option = refresh
+	ReadOnly Option = readOnly
 
 	refresh = Option{func(o *options) {
 		o.refresh = true
 	}}
 
+	readOnly = Option{func(o *options) {
+		o.readOnly = true
+	}}
+
+	defaultPath = "/tmp/football-teams.db"
+
 	updateTimestampKey  = []byte("update_timestamp")
+	indexVersionKey     = []byte("index_version")
+	indexVersion        = []byte("6")
 	teamPrefix          = "data_team_"
 	playerPrefix        = "data_player_"
 	teamNameIndexPrefix = "team_name_index_"
+	aliasIndexPrefix    = "team_alias_index_"
+	shortIndexPrefix    = "team_short_index_"
+	playerIndexPrefix   = "player_name_index_"
+	historyPrefix       = "team_history_"
+	encryptionCheckKey  = []byte("encryption_check")
 )
 
 // Option represents the options for the goleveldb storage
@@ -52,11 +90,79 @@ func Path(path string) Option {
 	}}
 }
 
+// Tracer sets the tracer used to create a span for each ingested team
+func Tracer(tracer *tracing.Tracer) Option {
+	return Option{func(o *options) {
+		o.tracer = tracer
+	}}
+}
+
+// Poll sets how often the repository checks whether a newer generation of the
+// data was stored, by this or another process
+func Poll(interval time.Duration) Option {
+	return Option{func(o *options) {
+		o.poll = interval
+	}}
+}
+
+// Encryption sets the key with which the team, player and history records are
+// encrypted, and the names in the index keys hashed, as returned by ParseKey.
+func Encryption(key []byte) Option {
+	return Option{func(o *options) {
+		o.key = key
+	}}
+}
+
+// Aliases sets the user maintained team name aliases
+func Aliases(aliases *alias.Set) Option {
+	return Option{func(o *options) {
+		o.aliases = aliases
+	}}
+}
+
+// NewTeamRepository creates a goleveldb backed team repository. Unless
+// Refresh is given, the download data will only be consumed if the stored
+// data is missing or stale. Queries block until the storage is initialized.
+//
+// The data is stored in generations, so that several processes can share the
+// path. A refresh writes a new generation from the download data, carrying
+// over only the team history of the previous one. Other processes keep serving
+// the previous generation meanwhile, and every process switches to the new
+// one once it is complete, as checked at the Poll interval. If another process
+// is already refreshing, the current generation is served instead, unless
+// there is none or Refresh is given. With ReadOnly, the download data is never
+// consumed, and the repository is unavailable until a generation is stored.
+//
+// Teams can be looked up by their exact name, a user maintained alias, or a
+// generated variant of their name, as described in the alias package. Each
+// name index entry may refer to several teams, in which case GetTeamByName
+// returns an ambiguous error. Search ranks all stored teams as described in
+// the search package, and SearchPlayers does the same for the players, using
+// an index of their normalized names. ListTeams orders the teams by their
+// numeric ids, as the memory storage does, while ListPlayers orders the
+// players by their string ids, both using the last id of a page as the cursor
+// of the next one. Databases with an older index format are always refreshed.
+//
+// Every refresh stores a new version of each changed team, and marks the teams
+// it no longer contains as removed, so that the previous squads can be looked
+// up with GetTeamAt and GetTeamHistory.
+//
+// The returned repository implements all optional football interfaces, along
+// with storage.Replayer, storage.Monitor, storage.Historian,
+// storage.ContextHistorian and storage.Watcher, whose context methods return a
+// not-ready error if the context is done before the repository is initialized.
+// Subscribers receive the changes between the served generation and each
+// newer one, once it is switched to, and a slow subscriber delays the
+// following switch.
 func NewTeamRepository(data <-chan download.Team, opts ...Option) football.TeamRepository {
-	o := options{path: "/tmp/football-teams.db", refresh: false}
+	o := options{path: defaultPath, refresh: false, poll: 5 * time.Second}
 	o.apply(opts)
 
-	ldb := &ldb{opts: o, init: make(chan struct{})}
+	ldb := &ldb{
+		opts: o, init: make(chan struct{}), done: make(chan struct{}),
+		status: storage.Status{StartedAt: time.Now()},
+	}
+	ldb.publisher = storage.NewPublisher(ldb.done)
 
 	go ldb.initialize(data)
 
@@ -64,51 +170,466 @@ func NewTeamRepository(data <-chan download.Team, opts ...Option) football.TeamR
 }
 
 func (ldb *ldb) GetTeam(id football.TeamId) (football.Team, error) {
-	<-ldb.init
+	return ldb.GetTeamContext(context.Background(), id)
+}
+
+func (ldb *ldb) GetTeamContext(ctx context.Context, id football.TeamId) (football.Team, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return football.Team{}, storage.NotReady(storage.EntityTeam, id, err)
+	}
 
 	if ldb.initError != nil {
-		return football.Team{}, initError{errors.Wrapf(ldb.initError, "getting team %d", id)}
+		return football.Team{}, storage.Unavailable(storage.EntityTeam, id, ldb.initError)
 	}
 
-	team, err := getTeam(ldb.db, id)
+	db, release := ldb.acquire()
+	defer release()
+
+	team, err := getTeam(db, id)
 	if errors.Cause(err) == leveldb.ErrNotFound {
-		return team, notFoundError{err}
+		return team, storage.NotFound(storage.EntityTeam, id, nil)
 	}
 
-	return team, nil
+	return team, err
 }
 
 func (ldb *ldb) GetTeamByName(name string) (football.Team, error) {
-	<-ldb.init
+	return ldb.GetTeamByNameContext(context.Background(), name)
+}
+
+func (ldb *ldb) GetTeamByNameContext(ctx context.Context, name string) (football.Team, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return football.Team{}, storage.NotReady(storage.EntityTeam, name, err)
+	}
 
 	if ldb.initError != nil {
-		return football.Team{}, initError{errors.Wrapf(ldb.initError, "getting team %s", name)}
+		return football.Team{}, storage.Unavailable(storage.EntityTeam, name, ldb.initError)
 	}
 
-	team, err := getTeamByName(ldb.db, name)
-	if errors.Cause(err) == leveldb.ErrNotFound {
-		return team, notFoundError{err}
+	db, release := ldb.acquire()
+	defer release()
+
+	teams, err := lookup(db, name, ldb.opts.aliases)
+	if err != nil {
+		return football.Team{}, err
+	}
+
+	if len(teams) > 1 {
+		return football.Team{}, storage.Ambiguous(storage.EntityTeam, name, errors.Errorf("%d teams match", len(teams)))
 	}
 
-	return team, nil
+	return teams[0], nil
+}
+
+func (ldb *ldb) GetTeamsByName(name string) ([]football.Team, error) {
+	return ldb.GetTeamsByNameContext(context.Background(), name)
+}
+
+func (ldb *ldb) GetTeamsByNameContext(ctx context.Context, name string) ([]football.Team, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, name, err)
+	}
+
+	if ldb.initError != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, name, ldb.initError)
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	return lookup(db, name, ldb.opts.aliases)
+}
+
+func (ldb *ldb) Search(query string, limit int) ([]football.Team, error) {
+	return ldb.SearchContext(context.Background(), query, limit)
+}
+
+func (ldb *ldb) SearchContext(ctx context.Context, query string, limit int) ([]football.Team, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, nil, errors.Wrapf(err, "searching teams %s", query))
+	}
+
+	if ldb.initError != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, nil, errors.Wrapf(ldb.initError, "searching teams %s", query))
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	teams, err := getTeams(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return search.Rank(query, teams, limit), nil
 }
 
 func (ldb *ldb) GetPlayer(id football.PlayerId) (football.Player, error) {
-	<-ldb.init
+	return ldb.GetPlayerContext(context.Background(), id)
+}
+
+func (ldb *ldb) GetPlayerContext(ctx context.Context, id football.PlayerId) (football.Player, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return football.Player{}, storage.NotReady(storage.EntityPlayer, id, err)
+	}
 
 	if ldb.initError != nil {
-		return football.Player{}, initError{errors.Wrapf(ldb.initError, "getting player %d", id)}
+		return football.Player{}, storage.Unavailable(storage.EntityPlayer, id, ldb.initError)
 	}
 
-	player, err := getPlayer(ldb.db, id)
+	db, release := ldb.acquire()
+	defer release()
+
+	player, err := getPlayer(db, id)
 	if errors.Cause(err) == leveldb.ErrNotFound {
-		return player, notFoundError{err}
+		return player, storage.NotFound(storage.EntityPlayer, id, nil)
+	}
+
+	return player, err
+}
+
+func (ldb *ldb) GetTeams(ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	return ldb.GetTeamsContext(context.Background(), ids)
+}
+
+func (ldb *ldb) GetTeamsContext(ctx context.Context, ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, nil, storage.NotReady(storage.EntityTeam, nil, errors.Wrapf(err, "getting %d teams", len(ids)))
+	}
+
+	if ldb.initError != nil {
+		return nil, nil, storage.Unavailable(storage.EntityTeam, nil, errors.Wrapf(ldb.initError, "getting %d teams", len(ids)))
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	keys := make([][]byte, len(ids))
+	for i, id := range ids {
+		keys[i] = []byte(fmt.Sprintf("%s%v", teamPrefix, id))
+	}
+
+	values, err := getBatch(db, teamPrefix, keys)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "getting %d teams", len(ids))
+	}
+
+	teams := make([]football.Team, 0, len(ids))
+	missing := []football.TeamId{}
+
+	for i, v := range values {
+		if v == nil {
+			missing = append(missing, ids[i])
+			continue
+		}
+
+		t := football.Team{}
+		if err := db.decode(keys[i], v, &t); err != nil {
+			return nil, nil, storage.Corrupt(storage.EntityTeam, ids[i], err)
+		}
+
+		teams = append(teams, t)
+	}
+
+	return teams, missing, nil
+}
+
+func (ldb *ldb) GetPlayers(ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	return ldb.GetPlayersContext(context.Background(), ids)
+}
+
+func (ldb *ldb) GetPlayersContext(ctx context.Context, ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrapf(err, "getting %d players", len(ids)))
+	}
+
+	if ldb.initError != nil {
+		return nil, nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrapf(ldb.initError, "getting %d players", len(ids)))
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	keys := make([][]byte, len(ids))
+	for i, id := range ids {
+		keys[i] = []byte(fmt.Sprintf("%s%v", playerPrefix, id))
+	}
+
+	values, err := getBatch(db, playerPrefix, keys)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "getting %d players", len(ids))
+	}
+
+	players := make([]football.Player, 0, len(ids))
+	missing := []football.PlayerId{}
+
+	for i, v := range values {
+		if v == nil {
+			missing = append(missing, ids[i])
+			continue
+		}
+
+		p := football.Player{}
+		if err := db.decode(keys[i], v, &p); err != nil {
+			return nil, nil, storage.Corrupt(storage.EntityPlayer, ids[i], err)
+		}
+
+		players = append(players, p)
+	}
+
+	return players, missing, nil
+}
+
+func (ldb *ldb) SearchPlayers(query string, limit int) ([]football.Player, error) {
+	return ldb.SearchPlayersContext(context.Background(), query, limit)
+}
+
+func (ldb *ldb) SearchPlayersContext(ctx context.Context, query string, limit int) ([]football.Player, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrapf(err, "searching players %s", query))
+	}
+
+	if ldb.initError != nil {
+		return nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrapf(ldb.initError, "searching players %s", query))
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	// The index only holds the HMACs of the names of encrypted players, who
+	// are all decoded instead.
+	if db.index != nil {
+		players, err := getPlayers(db)
+		if err != nil {
+			return nil, errors.Wrapf(err, "searching players %s", query)
+		}
+
+		return search.RankPlayers(query, players, limit), nil
+	}
+
+	matcher := search.NewMatcher(query)
+
+	ids := []football.PlayerId{}
+
+	iter := db.NewIterator(util.BytesPrefix([]byte(playerIndexPrefix)), nil)
+	for iter.Next() {
+		key := string(iter.Key()[len(playerIndexPrefix):])
+
+		sep := strings.LastIndexByte(key, 0)
+		if sep == -1 {
+			continue
+		}
+
+		if matcher.ScoreNormalized(key[:sep]) > 0 {
+			ids = append(ids, football.PlayerId(key[sep+1:]))
+		}
 	}
+	iter.Release()
 
-	return player, nil
+	if err := iter.Error(); err != nil {
+		return nil, errors.Wrapf(err, "searching players %s", query)
+	}
+
+	players := make([]football.Player, 0, len(ids))
+	for _, id := range ids {
+		p, err := getPlayer(db, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "searching players %s", query)
+		}
+
+		players = append(players, p)
+	}
+
+	return search.RankPlayers(query, players, limit), nil
+}
+
+func (ldb *ldb) QueryPlayers(query football.PlayerQuery) ([]football.Player, error) {
+	return ldb.QueryPlayersContext(context.Background(), query)
+}
+
+func (ldb *ldb) QueryPlayersContext(ctx context.Context, query football.PlayerQuery) ([]football.Player, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrap(err, "querying players"))
+	}
+
+	if ldb.initError != nil {
+		return nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrap(ldb.initError, "querying players"))
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	teams, err := getTeams(db)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying players")
+	}
+
+	national := map[football.TeamId]bool{}
+	for _, t := range teams {
+		national[t.Id] = t.IsNational
+	}
+
+	isNational := func(id football.TeamId) bool {
+		return national[id]
+	}
+
+	// The player keys, and therefore the matching players, are ordered by id.
+	players := []football.Player{}
+
+	iter := db.NewIterator(util.BytesPrefix([]byte(playerPrefix)), nil)
+	for iter.Next() {
+		p := football.Player{}
+
+		if err := db.decode(iter.Key(), iter.Value(), &p); err != nil {
+			iter.Release()
+			return nil, storage.Corrupt(storage.EntityPlayer, string(iter.Key()[len(playerPrefix):]), err)
+		}
+
+		if query.Match(p, isNational) {
+			players = append(players, p)
+		}
+	}
+	iter.Release()
+
+	if err := iter.Error(); err != nil {
+		return nil, errors.Wrap(err, "querying players")
+	}
+
+	return players, nil
+}
+
+func (ldb *ldb) ListTeams(cursor string, limit int) ([]football.Team, string, error) {
+	return ldb.ListTeamsContext(context.Background(), cursor, limit)
+}
+
+func (ldb *ldb) ListTeamsContext(ctx context.Context, cursor string, limit int) ([]football.Team, string, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, "", storage.NotReady(storage.EntityTeam, nil, errors.Wrap(err, "listing teams"))
+	}
+
+	if ldb.initError != nil {
+		return nil, "", storage.Unavailable(storage.EntityTeam, nil, errors.Wrap(ldb.initError, "listing teams"))
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	teams, next, err := listTeams(db, cursor, limit)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "listing teams")
+	}
+
+	return teams, next, nil
+}
+
+func (ldb *ldb) ListPlayers(cursor string, limit int) ([]football.Player, string, error) {
+	return ldb.ListPlayersContext(context.Background(), cursor, limit)
+}
+
+func (ldb *ldb) ListPlayersContext(ctx context.Context, cursor string, limit int) ([]football.Player, string, error) {
+	if err := ldb.wait(ctx); err != nil {
+		return nil, "", storage.NotReady(storage.EntityPlayer, nil, errors.Wrap(err, "listing players"))
+	}
+
+	if ldb.initError != nil {
+		return nil, "", storage.Unavailable(storage.EntityPlayer, nil, errors.Wrap(ldb.initError, "listing players"))
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	players := []football.Player{}
+	next, err := list(db, playerPrefix, cursor, limit, func(key []byte, v []byte) error {
+		p := football.Player{}
+		if err := db.decode(key, v, &p); err != nil {
+			return storage.Corrupt(storage.EntityPlayer, nil, err)
+		}
+
+		players = append(players, p)
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", errors.Wrap(err, "listing players")
+	}
+
+	return players, next, nil
+}
+
+func (ldb *ldb) Replay(data chan<- download.Team) error {
+	<-ldb.init
+
+	if ldb.initError != nil {
+		return storage.Unavailable(storage.EntityTeam, nil, errors.Wrap(ldb.initError, "replaying teams"))
+	}
+
+	db, release := ldb.acquire()
+	defer release()
+
+	teams, err := getTeams(db)
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(teamsById(teams))
+
+	for _, t := range teams {
+		players := make([]football.Player, 0, len(t.Players))
+		for _, pid := range t.Players {
+			p, err := getPlayer(db, pid)
+			if err != nil {
+				return errors.Wrapf(err, "replaying team %v", t.Id)
+			}
+
+			players = append(players, p)
+		}
+
+		d, err := storage.EncodeTeam(t, players)
+		if err != nil {
+			return err
+		}
+
+		data <- d
+	}
+
+	return nil
+}
+
+func (ldb *ldb) Ready() <-chan struct{} {
+	return ldb.init
+}
+
+func (ldb *ldb) Err() error {
+	select {
+	case <-ldb.init:
+		if ldb.initError != nil {
+			return storage.Unavailable("", nil, ldb.initError)
+		}
+	default:
+	}
+
+	return nil
+}
+
+func (ldb *ldb) Status() storage.Status {
+	ldb.mu.Lock()
+	defer ldb.mu.Unlock()
+
+	return ldb.status
 }
 
 func (ldb *ldb) Close() error {
+	ldb.once.Do(func() {
+		close(ldb.done)
+	})
+
+	ldb.dbMu.Lock()
+	defer ldb.dbMu.Unlock()
+
+	if ldb.db == nil {
+		return nil
+	}
+
 	if err := ldb.db.Close(); err != nil {
 		return errors.Wrap(err, "closing database")
 	}
@@ -116,154 +637,549 @@ func (ldb *ldb) Close() error {
 	return nil
 }
 
+// lookup returns the teams matching the name, trying the exact name, the user
+// maintained aliases, the name variants and the short names, in that order.
+func lookup(db *database, name string, aliases *alias.Set) ([]football.Team, error) {
+	teams, err := getTeamsByName(db, name)
+	if errors.Cause(err) == leveldb.ErrNotFound {
+		if canonical, ok := aliases.Canonical(name); ok {
+			teams, err = getTeamsByName(db, canonical)
+		}
+	}
+
+	if errors.Cause(err) == leveldb.ErrNotFound {
+		for _, v := range alias.Forms(name) {
+			teams, err = getTeamsByAlias(db, v)
+			if errors.Cause(err) != leveldb.ErrNotFound {
+				break
+			}
+		}
+	}
+
+	if errors.Cause(err) == leveldb.ErrNotFound {
+		teams, err = getTeamsByIndex(db, shortIndexPrefix, alias.Normalize(name))
+	}
+
+	if errors.Cause(err) == leveldb.ErrNotFound {
+		return nil, storage.NotFound(storage.EntityTeam, name, nil)
+	}
+
+	return teams, err
+}
+
+// wait blocks until the repository is initialized, or the context is done.
+func (ldb *ldb) wait(ctx context.Context) error {
+	select {
+	case <-ldb.init:
+		return nil
+	default:
+	}
+
+	select {
+	case <-ldb.init:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (ldb *ldb) initialize(data <-chan download.Team) {
 	defer close(ldb.init)
 
-	db, err := leveldb.OpenFile(ldb.opts.path, nil)
+	keys, err := newCipher(ldb.opts.key)
+	if err != nil {
+		ldb.initError = err
+		return
+	}
+	ldb.keys = keys
+
+	if !ldb.opts.readOnly {
+		if err := ldb.update(data); err != nil {
+			ldb.initError = err
+			return
+		}
+	}
+
+	name, err := currentGeneration(ldb.opts.path)
+	if err == nil && name == "" {
+		err = errors.Errorf("no data stored in %s", ldb.opts.path)
+	}
+
+	if err == nil {
+		err = ldb.switchTo(name)
+	}
+
 	if err != nil {
 		ldb.initError = errors.Wrap(err, "initializing leveldb database")
 		return
 	}
 
-	ldb.db = db
+	go ldb.watch()
+}
 
-	if !ldb.opts.refresh {
-		updateTimestamp, err := db.Get(updateTimestampKey, nil)
-		if err != nil && err != leveldb.ErrNotFound {
-			ldb.initError = errors.Wrap(err, "getting update timestamp data")
-			return
+// stale checks whether the data of the database is missing, outdated, or
+// indexed in an older format.
+func (ldb *ldb) stale(db *database) (bool, error) {
+	updateTimestamp, err := db.Get(updateTimestampKey, nil)
+	if err == leveldb.ErrNotFound {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, errors.Wrap(err, "getting update timestamp data")
+	}
+
+	stamp, err := strconv.ParseInt(string(updateTimestamp), 10, 64)
+	if err != nil {
+		return true, nil
+	}
+
+	ldb.setStatus(func(s *storage.Status) {
+		s.RefreshedAt = time.Unix(stamp, 0)
+	})
+
+	if time.Now().Sub(time.Unix(stamp, 0)) > time.Hour*196 {
+		return true, nil
+	}
+
+	version, err := db.Get(indexVersionKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return false, errors.Wrap(err, "getting index version")
+	}
+
+	return !bytes.Equal(version, indexVersion), nil
+}
+
+// refresh stores the download data in the database.
+func (ldb *ldb) refresh(db *database, data <-chan download.Team) error {
+	ldb.setStatus(func(s *storage.Status) {
+		s.Source = storage.SourceDownload
+	})
+
+	// Every version stored by this refresh is valid from its start.
+	started := time.Now()
+
+	seen := map[football.PlayerId]struct{}{}
+	seenTeams := map[football.TeamId]struct{}{}
+
+	for d := range data {
+		span := ldb.opts.tracer.Start("goleveldb.ingest").Set("team.id", d.Id)
+		parse := span.Child("parse")
+
+		team, players, err := storage.ParseTeam(d)
+		parse.Fail(err).End()
+		if err != nil {
+			span.Fail(err).End()
+			return err
 		}
 
-		if err != nil && err == leveldb.ErrNotFound {
-			ldb.opts.refresh = true
-		} else {
-			stamp, err := strconv.ParseInt(string(updateTimestamp), 10, 64)
-			if err != nil {
-				ldb.opts.refresh = true
-			} else {
-				if time.Now().Sub(time.Unix(stamp, 0)) > time.Hour*196 {
-					ldb.opts.refresh = true
-				}
-			}
+		if err := ingest(db, team, players, seen, span.Child("store")); err != nil {
+			span.Fail(err).End()
+			return err
 		}
+
+		if err := putVersion(db, team, players, started); err != nil {
+			span.Fail(err).End()
+			return err
+		}
+
+		seenTeams[team.Id] = struct{}{}
+
+		for _, p := range players {
+			seen[p.Id] = struct{}{}
+		}
+
+		ldb.setStatus(func(s *storage.Status) {
+			s.Teams++
+			s.Players = len(seen)
+		})
+
+		span.Set("team.players", len(players)).End()
 	}
 
-	if ldb.opts.refresh {
-		for d := range data {
-			var j storage.JsonData
+	if err := removeMissing(db, seenTeams, started); err != nil {
+		return err
+	}
 
-			err := json.Unmarshal(d.Bytes, &j)
-			if err != nil {
-				ldb.initError = errors.Wrapf(err, "parsing team data for %d", d.Id)
-				return
-			}
+	now := time.Now()
+
+	batch := &leveldb.Batch{}
+	batch.Put(indexVersionKey, indexVersion)
+	batch.Put(updateTimestampKey, []byte(fmt.Sprintf("%d", now.Unix())))
+
+	if err := db.mark(batch); err != nil {
+		return err
+	}
+
+	if err := db.Write(batch, nil); err != nil {
+		return errors.Wrap(err, "adding update timestamp")
+	}
+
+	ldb.setStatus(func(s *storage.Status) {
+		s.RefreshedAt = now
+	})
+
+	return nil
+}
+
+// loadStatus sets the status to the counts of the database, and its refresh
+// time, unless an earlier one.
+func (ldb *ldb) loadStatus(db *database) error {
+	teams, err := countKeys(db, teamPrefix)
+	if err != nil {
+		return err
+	}
+
+	players, err := countKeys(db, playerPrefix)
+	if err != nil {
+		return err
+	}
+
+	var refreshed time.Time
+	if stamp, err := db.Get(updateTimestampKey, nil); err == nil {
+		if seconds, err := strconv.ParseInt(string(stamp), 10, 64); err == nil {
+			refreshed = time.Unix(seconds, 0)
+		}
+	}
+
+	ldb.setStatus(func(s *storage.Status) {
+		s.Teams, s.Players = teams, players
+		if refreshed.After(s.RefreshedAt) {
+			s.RefreshedAt = refreshed
+		}
 
-			td := j.Data.Team
-
-			playerIds := []football.PlayerId{}
-
-			for _, p := range td.Players {
-				playerIds = append(playerIds, p.Id)
-
-				player, err := getPlayer(db, p.Id)
-				if err == nil {
-					player.Teams = append(player.Teams, td.Id)
-					if err := putPlayer(db, player); err != nil {
-						ldb.initError = errors.Wrapf(err, "updating player %v teams", p.Id)
-						return
-					}
-				} else {
-					if errors.Cause(err) != leveldb.ErrNotFound {
-						ldb.initError = errors.Wrapf(err, "reading stored player %v", p.Id)
-						return
-					}
-
-					var age int
-					switch v := p.Age.(type) {
-					case int:
-						age = v
-					case string:
-						// Ignore the error, we can't do anything if the string
-						// isn't numerical
-						age, _ = strconv.Atoi(v)
-					}
-					if err := putPlayer(db, football.Player{
-						Id: p.Id, Name: p.Name,
-						Age: age, Teams: []football.TeamId{td.Id},
-					}); err != nil {
-						ldb.initError = errors.Wrapf(err, "adding player %v", p.Id)
-						return
-					}
-				}
+		if s.Source == "" {
+			s.Source = storage.SourceLeveldb
+		}
+	})
+
+	return nil
+}
+
+func (ldb *ldb) setStatus(update func(s *storage.Status)) {
+	ldb.mu.Lock()
+	defer ldb.mu.Unlock()
+
+	update(&ldb.status)
+}
+
+// ingest stores the team and its players, as parsed from the download data.
+// Players already ingested during the same refresh, as listed by seen, keep
+// the teams they were stored with, while any others only list this team, so
+// that the teams of every player follow the rosters of the refresh.
+func ingest(db *database, team football.Team, players []football.Player, seen map[football.PlayerId]struct{}, span *tracing.Span) (err error) {
+	defer func() {
+		span.Fail(err).End()
+	}()
+
+	for _, p := range players {
+		p.Teams = []football.TeamId{team.Id}
+
+		if _, ok := seen[p.Id]; ok {
+			player, err := getPlayer(db, p.Id)
+			if err != nil {
+				return errors.Wrapf(err, "reading stored player %v", p.Id)
 			}
 
-			if err := putTeam(db, football.Team{
-				Id: td.Id, Name: td.Name,
-				IsNational: td.IsNational, Players: playerIds,
-			}); err != nil {
-				ldb.initError = errors.Wrapf(err, "adding team %v", td.Id)
-				return
+			p.Teams = player.Teams
+			if !memberOf(player, team.Id) {
+				p.Teams = append(p.Teams, team.Id)
 			}
+		}
 
+		if err := putPlayer(db, p); err != nil {
+			return errors.Wrapf(err, "storing player %v", p.Id)
 		}
+	}
 
-		err := db.Put(updateTimestampKey, []byte(fmt.Sprintf("%d", time.Now().Unix())), nil)
-		if err != nil {
-			ldb.initError = errors.Wrap(err, "adding update timestamp")
+	if err := putTeam(db, team); err != nil {
+		return errors.Wrapf(err, "adding team %v", team.Id)
+	}
+
+	return nil
+}
+
+// memberOf checks whether the player is already stored as a member of the
+// team, as is the case for players listed more than once by a team.
+func memberOf(p football.Player, id football.TeamId) bool {
+	for _, tid := range p.Teams {
+		if tid == id {
+			return true
 		}
 	}
+
+	return false
 }
 
-func getTeam(db *leveldb.DB, id football.TeamId) (football.Team, error) {
+func getTeam(db *database, id football.TeamId) (football.Team, error) {
 	t := football.Team{}
-	d, err := db.Get([]byte(fmt.Sprintf("%s%v", teamPrefix, id)), nil)
+	key := []byte(fmt.Sprintf("%s%v", teamPrefix, id))
+
+	d, err := db.Get(key, nil)
 	if err != nil {
 		return t, errors.Wrapf(err, "getting team %v", id)
 	}
 
-	dec := gob.NewDecoder(bytes.NewReader(d))
-	if err := dec.Decode(&t); err != nil {
-		return t, errors.Wrapf(err, "decoding team %v", id)
+	if err := db.decode(key, d, &t); err != nil {
+		return t, storage.Corrupt(storage.EntityTeam, id, err)
 	}
 
 	return t, nil
 }
 
-func getTeamByName(db *leveldb.DB, name string) (football.Team, error) {
-	t := football.Team{}
-	d, err := db.Get([]byte(fmt.Sprintf("%s%v", teamNameIndexPrefix, name)), nil)
-	if err != nil {
-		return t, errors.Wrapf(err, "getting team %v", name)
+// getBatch looks up the values of the keys, all of which share the prefix,
+// in a single sorted pass over a snapshot of the database. The values are
+// returned in the order of the keys, with nil denoting a missing key.
+func getBatch(db *database, prefix string, keys [][]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	if len(keys) == 0 {
+		return values, nil
 	}
-	id, err := strconv.ParseInt(string(d), 10, 64)
-	if err != nil {
-		return t, errors.Wrapf(err, "decoding id for team %v", name)
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
 	}
+	sort.Sort(byKey{order, keys})
 
-	d, err = db.Get([]byte(fmt.Sprintf("%s%v", teamPrefix, id)), nil)
+	snapshot, err := db.GetSnapshot()
 	if err != nil {
-		return t, errors.Wrapf(err, "getting team %v", name)
+		return nil, errors.Wrap(err, "getting snapshot")
 	}
+	defer snapshot.Release()
+
+	iter := snapshot.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
 
-	dec := gob.NewDecoder(bytes.NewReader(d))
-	if err := dec.Decode(&t); err != nil {
-		return t, errors.Wrapf(err, "decoding team %v", name)
+	for _, i := range order {
+		if !iter.Seek(keys[i]) {
+			break
+		}
+
+		if bytes.Equal(iter.Key(), keys[i]) {
+			// The iterator reuses its value buffer.
+			values[i] = append([]byte{}, iter.Value()...)
+		}
 	}
 
-	return t, nil
+	return values, errors.Wrap(iter.Error(), "iterating over keys")
+}
+
+// list passes the values of at most limit keys with the prefix, following
+// the one denoted by the cursor, to the decode function. The cursor is the
+// key without the prefix, and the returned one is that of the last listed key,
+// if there are any more. A limit less than 1 lists all remaining keys.
+func list(db *database, prefix string, cursor string, limit int, decode func(key []byte, v []byte) error) (string, error) {
+	iter := db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	var ok bool
+	if cursor == "" {
+		ok = iter.First()
+	} else {
+		after := []byte(prefix + cursor)
+		ok = iter.Seek(after)
+		if ok && bytes.Equal(iter.Key(), after) {
+			ok = iter.Next()
+		}
+	}
+
+	last := ""
+	for n := 0; ok; ok = iter.Next() {
+		if limit > 0 && n == limit {
+			return last, iter.Error()
+		}
+
+		if err := decode(iter.Key(), iter.Value()); err != nil {
+			return "", errors.Wrapf(err, "decoding %s", iter.Key())
+		}
+
+		last = string(iter.Key()[len(prefix):])
+		n++
+	}
+
+	return "", iter.Error()
+}
+
+// listTeams returns a page of teams in numeric id order, as the keys of the
+// teams are ordered lexicographically. The cursor is the id of the last team
+// of the previous page.
+func listTeams(db *database, cursor string, limit int) ([]football.Team, string, error) {
+	after := -1
+	if cursor != "" {
+		var err error
+		if after, err = strconv.Atoi(cursor); err != nil {
+			return nil, "", errors.Wrapf(err, "parsing cursor %q", cursor)
+		}
+	}
+
+	ids := []int{}
+
+	iter := db.NewIterator(util.BytesPrefix([]byte(teamPrefix)), nil)
+	for iter.Next() {
+		id, err := strconv.Atoi(string(iter.Key()[len(teamPrefix):]))
+		if err != nil {
+			iter.Release()
+			return nil, "", storage.Corrupt(storage.EntityTeam, string(iter.Key()), errors.Wrap(err, "decoding team id"))
+		}
+
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	iter.Release()
+
+	if err := iter.Error(); err != nil {
+		return nil, "", errors.Wrap(err, "iterating over teams")
+	}
+
+	sort.Ints(ids)
+
+	next := ""
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		next = strconv.Itoa(ids[limit-1])
+	}
+
+	teams := make([]football.Team, 0, len(ids))
+	for _, id := range ids {
+		t, err := getTeam(db, football.TeamId(id))
+		if err != nil {
+			return nil, "", err
+		}
+
+		teams = append(teams, t)
+	}
+
+	return teams, next, nil
+}
+
+// countKeys counts the keys with the given prefix.
+func countKeys(db *database, prefix string) (int, error) {
+	count := 0
+
+	iter := db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		count++
+	}
+
+	return count, errors.Wrapf(iter.Error(), "counting %s keys", prefix)
+}
+
+// getTeams decodes all stored teams, in key order.
+func getTeams(db *database) ([]football.Team, error) {
+	teams := []football.Team{}
+
+	iter := db.NewIterator(util.BytesPrefix([]byte(teamPrefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		t := football.Team{}
+
+		if err := db.decode(iter.Key(), iter.Value(), &t); err != nil {
+			return nil, storage.Corrupt(storage.EntityTeam, string(iter.Key()[len(teamPrefix):]), err)
+		}
+
+		teams = append(teams, t)
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, errors.Wrap(err, "iterating over teams")
+	}
+
+	return teams, nil
+}
+
+func getTeamsByName(db *database, name string) ([]football.Team, error) {
+	return getTeamsByIndex(db, teamNameIndexPrefix, name)
+}
+
+func getTeamsByAlias(db *database, variant string) ([]football.Team, error) {
+	return getTeamsByIndex(db, aliasIndexPrefix, variant)
+}
+
+// getTeamsByIndex returns the teams of an index entry. Every team of the
+// entry has its own key, formed by the entry name and the team id, separated
+// by a zero byte.
+func getTeamsByIndex(db *database, prefix string, name string) ([]football.Team, error) {
+	entry := []byte(indexKey(prefix, db.indexName(name), ""))
+
+	ids := []football.TeamId{}
+
+	iter := db.NewIterator(util.BytesPrefix(entry), nil)
+	for iter.Next() {
+		id, err := strconv.Atoi(string(iter.Key()[len(entry):]))
+		if err != nil {
+			iter.Release()
+			return nil, storage.Corrupt(storage.EntityTeam, name, errors.Wrap(err, "decoding index entry id"))
+		}
+
+		ids = append(ids, football.TeamId(id))
+	}
+	iter.Release()
+
+	if err := iter.Error(); err != nil {
+		return nil, errors.Wrapf(err, "getting team %v", name)
+	}
+
+	if len(ids) == 0 {
+		return nil, errors.Wrapf(leveldb.ErrNotFound, "getting team %v", name)
+	}
+
+	teams := make([]football.Team, 0, len(ids))
+	for _, id := range ids {
+		t, err := getTeam(db, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting team %v", name)
+		}
+
+		teams = append(teams, t)
+	}
+
+	sort.Sort(teamsById(teams))
+
+	return teams, nil
+}
+
+func indexKey(prefix string, name string, id interface{}) string {
+	return fmt.Sprintf("%s%s\x00%v", prefix, name, id)
 }
 
-func putTeam(db *leveldb.DB, t football.Team) error {
-	var b bytes.Buffer
+// putTeam stores the team along with its name index entries, replacing the
+// entries of any name it was previously stored with.
+func putTeam(db *database, t football.Team) error {
+	key := []byte(fmt.Sprintf("%s%v", teamPrefix, t.Id))
 
-	enc := gob.NewEncoder(&b)
-	if err := enc.Encode(t); err != nil {
+	v, err := db.encode(key, t)
+	if err != nil {
 		return errors.Wrapf(err, "encoding team %v", t.Id)
 	}
 
 	batch := &leveldb.Batch{}
-	batch.Put([]byte(fmt.Sprintf("%s%v", teamPrefix, t.Id)), b.Bytes())
-	batch.Put([]byte(fmt.Sprintf("%s%v", teamNameIndexPrefix, t.Name)), []byte(fmt.Sprintf("%d", t.Id)))
+
+	previous, err := getTeam(db, t.Id)
+	switch {
+	case err == nil && previous.Name != t.Name:
+		current := map[string]bool{}
+		for _, k := range db.teamIndex(t) {
+			current[k] = true
+		}
+
+		for _, k := range db.teamIndex(previous) {
+			if !current[k] {
+				batch.Delete([]byte(k))
+			}
+		}
+	case err != nil && errors.Cause(err) != leveldb.ErrNotFound:
+		return errors.Wrapf(err, "reading stored team %v", t.Id)
+	}
+
+	batch.Put(key, v)
+	for _, k := range db.teamIndex(t) {
+		batch.Put([]byte(k), nil)
+	}
 
 	if err := db.Write(batch, nil); err != nil {
 		return errors.Wrapf(err, "writing team %v", t.Id)
@@ -272,38 +1188,100 @@ func putTeam(db *leveldb.DB, t football.Team) error {
 	return nil
 }
 
-func getPlayer(db *leveldb.DB, id football.PlayerId) (football.Player, error) {
+func getPlayer(db *database, id football.PlayerId) (football.Player, error) {
 	p := football.Player{}
-	d, err := db.Get([]byte(fmt.Sprintf("%s%v", playerPrefix, id)), nil)
+	key := []byte(fmt.Sprintf("%s%v", playerPrefix, id))
+
+	d, err := db.Get(key, nil)
 	if err != nil {
 		return p, errors.Wrapf(err, "getting player %v", id)
 	}
 
-	dec := gob.NewDecoder(bytes.NewReader(d))
-	if err := dec.Decode(&p); err != nil {
-		return p, errors.Wrapf(err, "decoding player %v", id)
+	if err := db.decode(key, d, &p); err != nil {
+		return p, storage.Corrupt(storage.EntityPlayer, id, err)
 	}
 
 	return p, nil
 }
 
-func putPlayer(db *leveldb.DB, p football.Player) error {
-	var b bytes.Buffer
+// putPlayer stores the player along with its name index entry, replacing the
+// entry of any name it was previously stored with.
+func putPlayer(db *database, p football.Player) error {
+	key := []byte(fmt.Sprintf("%s%v", playerPrefix, p.Id))
 
-	enc := gob.NewEncoder(&b)
-	if err := enc.Encode(p); err != nil {
+	v, err := db.encode(key, p)
+	if err != nil {
 		return errors.Wrapf(err, "encoding player %v", p.Id)
 	}
 
-	if err := db.Put([]byte(fmt.Sprintf("%s%v", playerPrefix, p.Id)), b.Bytes(), nil); err != nil {
+	batch := &leveldb.Batch{}
+
+	previous, err := getPlayer(db, p.Id)
+	switch {
+	case err == nil && alias.Normalize(previous.Name) != alias.Normalize(p.Name):
+		batch.Delete([]byte(db.playerIndex(previous)))
+	case err != nil && errors.Cause(err) != leveldb.ErrNotFound:
+		return errors.Wrapf(err, "reading stored player %v", p.Id)
+	}
+
+	batch.Put(key, v)
+	batch.Put([]byte(db.playerIndex(p)), nil)
+
+	if err := db.Write(batch, nil); err != nil {
 		return errors.Wrapf(err, "writing player %v", p.Id)
 	}
 
 	return nil
 }
 
+type teamsById []football.Team
+
+func (t teamsById) Len() int {
+	return len(t)
+}
+
+func (t teamsById) Less(i int, j int) bool {
+	return t[i].Id < t[j].Id
+}
+
+func (t teamsById) Swap(i int, j int) {
+	t[i], t[j] = t[j], t[i]
+}
+
+// byKey sorts indices into a key slice by their keys.
+type byKey struct {
+	order []int
+	keys  [][]byte
+}
+
+func (b byKey) Len() int {
+	return len(b.order)
+}
+
+func (b byKey) Less(i int, j int) bool {
+	return bytes.Compare(b.keys[b.order[i]], b.keys[b.order[j]]) < 0
+}
+
+func (b byKey) Swap(i int, j int) {
+	b.order[i], b.order[j] = b.order[j], b.order[i]
+}
+
 func (o *options) apply(opts []Option) {
 	for _, op := range opts {
 		op.f(o)
 	}
 }
+
+type teamIds []football.TeamId
+
+func (t teamIds) Len() int {
+	return len(t)
+}
+
+func (t teamIds) Less(i int, j int) bool {
+	return t[i] < t[j]
+}
+
+func (t teamIds) Swap(i int, j int) {
+	t[i], t[j] = t[j], t[i]
+}
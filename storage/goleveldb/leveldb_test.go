@@ -1,204 +1,854 @@
+//go:build go1.7
 // +build go1.7
 
 package goleveldb_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
 	"github.com/urandom/team-search-test/download"
 	"github.com/urandom/team-search-test/football"
 	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
 	"github.com/urandom/team-search-test/storage/goleveldb"
+	"github.com/urandom/team-search-test/storage/storagetest"
 )
 
-type team struct {
-	id       football.TeamId
-	name     string
-	national bool
-	exists   bool
+func TestTeamRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	i := 0
+	storagetest.TestTeamRepository(t, func(data <-chan download.Team) football.TeamRepository {
+		i++
+		return goleveldb.NewTeamRepository(data, goleveldb.Path(filepath.Join(dir, fmt.Sprintf("%d.db", i))))
+	})
 }
 
-type player struct {
-	id      football.PlayerId
-	name    string
-	teamIds []football.TeamId
-	exists  bool
+func TestAliases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	storagetest.TestAliases(t, func(data <-chan download.Team, aliases *alias.Set) football.TeamRepository {
+		return goleveldb.NewTeamRepository(data, goleveldb.Path(dir), goleveldb.Aliases(aliases))
+	})
 }
 
-func TestMemoryStorage(t *testing.T) {
-	cases := []struct {
-		data    []download.Team
-		teams   []team
-		players []player
-	}{
-		{
-			data: []download.Team{
-				{[]byte(team1), 1},
-				{[]byte(team2), 50},
-				{[]byte(team3), 100},
-				{[]byte(team4), 200},
-			},
-			teams: []team{
-				{1, "Apoel FC", false, true},
-				{50, "D2", false, true},
-				{100, "Czech Republic", true, true},
-				{200, "Test 1", true, true},
-				{2500, "sdd", false, false},
-			},
-			players: []player{
-				{"6", "Nuno Morais", []football.TeamId{1, 200}, true},
-				{"235", "Jaroslav Plasil", []football.TeamId{100, 200}, true},
-				{id: "sdasd", exists: false},
-			},
-		},
-	}
-
-	for i, tc := range cases {
-		t.Run(fmt.Sprintf("case %d", i), func(t *testing.T) {
-			data := make(chan download.Team)
-
-			go func() {
-				for _, d := range tc.data {
-					data <- d
-				}
-				close(data)
-			}()
-
-			defer func() {
-				os.RemoveAll("/tmp/football-teams.db")
-			}()
-
-			repo := goleveldb.NewTeamRepository(data)
-
-			for _, td := range tc.teams {
-				team, err := repo.GetTeam(td.id)
-				if td.exists {
-					if err != nil {
-						t.Fatalf("error looking for team %d: %+v", td.id, err)
-					}
-
-					if team.Id != td.id {
-						t.Fatalf("expected id %d, got %d", td.id, team.Id)
-					}
-
-					if team.Name != td.name {
-						t.Fatalf("expected name %s, got %s", td.name, team.Name)
-					}
-
-					if team.IsNational != td.national {
-						t.Fatalf("expected isNational to be %v, got %v", td.national, team.IsNational)
-					}
-				} else {
-					if !storage.IsNotFound(err) {
-						t.Fatalf("expected not found error, got %+v", err)
-					}
-				}
+func TestStoredStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
 
-				team, err = repo.GetTeamByName(td.name)
-				if td.exists {
-					if err != nil {
-						t.Fatalf("error looking for team %s: %+v", td.name, err)
-					}
-
-					if team.Id != td.id {
-						t.Fatalf("expected id %d, got %d", td.id, team.Id)
-					}
-				} else {
-					if !storage.IsNotFound(err) {
-						t.Fatalf("expected not found error, got %+v", err)
-					}
-				}
-			}
+	defer func() {
+		os.RemoveAll(dir)
+	}()
 
-			for _, p := range tc.players {
-				player, err := repo.GetPlayer(p.id)
-				if p.exists {
-					if err != nil {
-						t.Fatalf("error looking for player %s: %+v", p.id, err)
-					}
-
-					if player.Id != p.id {
-						t.Fatalf("expected id %s, got %s", p.id, player.Id)
-					}
-
-					if player.Name != p.name {
-						t.Fatalf("expected name %s, got %s", p.name, player.Name)
-					}
-
-					for j, tid := range p.teamIds {
-						if j >= len(player.Teams) {
-							t.Fatalf("expected more teams than %d", j)
-						}
-
-						if tid != player.Teams[j] {
-							t.Fatalf("expected team id %d, got %d", tid, player.Teams[j])
-						}
-					}
-				} else {
-					if !storage.IsNotFound(err) {
-						t.Fatalf("expected not found error, got %+v", err)
-					}
-				}
-			}
-		})
+	data := make(chan download.Team, 1)
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"}
+	]}}}`), Id: 1}
+	close(data)
+
+	repo := goleveldb.NewTeamRepository(data, goleveldb.Path(dir)).(storage.Monitor)
+	<-repo.Ready()
+	refreshed := repo.Status().RefreshedAt
+
+	if err := repo.Close(); err != nil {
+		t.Fatalf("error closing repository: %+v", err)
+	}
+
+	// The stored data is fresh, so the download data is never consumed.
+	repo = goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir)).(storage.Monitor)
+	defer repo.Close()
+
+	<-repo.Ready()
+
+	status := repo.Status()
+	if status.Source != storage.SourceLeveldb || status.Teams != 1 || status.Players != 1 {
+		t.Fatalf("expected one stored team and player, got %+v", status)
+	}
+
+	if status.RefreshedAt.Unix() != refreshed.Unix() {
+		t.Fatalf("expected the stored refresh time %v, got %v", refreshed, status.RefreshedAt)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	refresh := func(teams ...string) time.Time {
+		data := make(chan download.Team, len(teams))
+		for i, team := range teams {
+			data <- download.Team{Bytes: []byte(team), Id: i + 1}
+		}
+		close(data)
+
+		repo := goleveldb.NewTeamRepository(data, goleveldb.Path(dir), goleveldb.Refresh)
+		if _, err := repo.GetTeam(2); err != nil {
+			t.Fatalf("error getting team: %+v", err)
+		}
+
+		if err := repo.Close(); err != nil {
+			t.Fatalf("error closing repository: %+v", err)
+		}
+
+		return time.Now()
+	}
+
+	apoel := `{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"}
+	]}}}`
+	apoelTransfer := `{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"},
+		{"id": "7", "name": "Tomas Sivok", "age": "33"}
+	]}}}`
+	czech := `{"data": {"team": {"id": 2, "name": "Czech Republic", "isNational": true, "players": [
+		{"id": "7", "name": "Tomas Sivok", "age": "33"}
+	]}}}`
+
+	before := time.Now()
+	first := refresh(apoel, czech)
+	second := refresh(apoel, czech)
+	third := refresh(apoelTransfer, czech)
+	fourth := refresh(czech)
+
+	repo := goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir)).(storage.Historian)
+	defer repo.Close()
+
+	if _, err := repo.GetTeamAt(1, before); !storage.IsNotFound(err) {
+		t.Fatalf("expected no team before the first refresh, got %+v", err)
+	}
+
+	for _, at := range []time.Time{first, second} {
+		team, err := repo.GetTeamAt(1, at)
+		if err != nil || len(team.Team.Players) != 1 {
+			t.Fatalf("expected the first squad at %s, got %+v, %+v", at, team, err)
+		}
+	}
+
+	if team, err := repo.GetTeamAt(1, third); err != nil || len(team.Team.Players) != 2 {
+		t.Fatalf("expected the second squad, got %+v, %+v", team, err)
+	}
+
+	// The players are kept along with the versions, even once removed.
+	version, err := repo.GetTeamAt(1, first)
+	if err != nil {
+		t.Fatalf("error getting the first version: %+v", err)
+	}
+
+	expected := []football.Player{{Id: "6", Name: "Nuno Morais", Age: 32}}
+	if !reflect.DeepEqual(version.Players, expected) {
+		t.Fatalf("expected the players %+v, got %+v", expected, version.Players)
+	}
+
+	if ids, err := repo.GetTeamIdsByPastName("Apoel"); err != nil || !reflect.DeepEqual(ids, []football.TeamId{1}) {
+		t.Fatalf("expected the removed team by its past name, got %v, %+v", ids, err)
+	}
+
+	if _, err := repo.GetTeamIdsByPastName("Sparta"); !storage.IsNotFound(err) {
+		t.Fatalf("expected no team ever named Sparta, got %+v", err)
+	}
+
+	if _, err := repo.GetTeamAt(1, fourth); !storage.IsNotFound(err) {
+		t.Fatalf("expected the team to be removed, got %+v", err)
+	}
+
+	history, err := repo.GetTeamHistory(1)
+	if err != nil {
+		t.Fatalf("error getting team history: %+v", err)
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("expected two versions, got %+v", history)
+	}
+
+	if !history[0].From.Before(first) || history[0].To != history[1].From ||
+		!history[1].To.After(third) || history[1].To.After(fourth) {
+		t.Fatalf("expected consecutive intervals ending with the removal, got %+v", history)
+	}
+
+	if history, err := repo.GetTeamHistory(2); err != nil || len(history) != 1 || !history[0].To.IsZero() {
+		t.Fatalf("expected a single current version, got %+v, %+v", history, err)
+	}
+
+	if _, err := repo.GetTeamHistory(3); !storage.IsNotFound(err) {
+		t.Fatalf("expected no history for an unknown team, got %+v", err)
+	}
+
+	if _, err := repo.GetPlayer("6"); !storage.IsNotFound(err) {
+		t.Fatalf("expected the player of the removed team to be removed, got %+v", err)
+	}
+
+	if p, err := repo.GetPlayer("7"); err != nil || len(p.Teams) != 1 || p.Teams[0] != 2 {
+		t.Fatalf("expected player 7 to only list the remaining team, got %+v, %+v", p, err)
+	}
+
+	pending := make(chan download.Team)
+	waiting := goleveldb.NewTeamRepository(pending, goleveldb.Path(filepath.Join(dir, "pending"))).(storage.ContextHistorian)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := waiting.GetTeamAtContext(ctx, 1, third); !storage.IsNotReady(err) {
+		t.Fatalf("expected not ready error for the team version, got %+v", err)
+	}
+
+	if _, err := waiting.GetTeamHistoryContext(ctx, 1); !storage.IsNotReady(err) {
+		t.Fatalf("expected not ready error for the team history, got %+v", err)
+	}
+
+	close(pending)
+	if err := waiting.(football.TeamRepository).Close(); err != nil {
+		t.Fatalf("error closing repository: %+v", err)
 	}
 }
 
-func TestGarbageData(t *testing.T) {
-	data := make(chan download.Team)
+func TestRefreshChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
 
-	go func() {
-		data <- download.Team{[]byte(team2), 50}
-		data <- download.Team{[]byte("asdasdsad"), 1}
-		data <- download.Team{[]byte(team3), 100}
+	refresh := func(teams ...string) {
+		data := make(chan download.Team, len(teams))
+		for i, team := range teams {
+			data <- download.Team{Bytes: []byte(team), Id: i + 1}
+		}
 		close(data)
+
+		repo := goleveldb.NewTeamRepository(data, goleveldb.Path(dir), goleveldb.Refresh)
+		if _, err := repo.GetTeam(2); err != nil {
+			t.Fatalf("error getting team: %+v", err)
+		}
+
+		if err := repo.Close(); err != nil {
+			t.Fatalf("error closing repository: %+v", err)
+		}
+	}
+
+	refresh(`{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"},
+		{"id": "7", "name": "Tomas Sivok", "age": "33"}
+	]}}}`, `{"data": {"team": {"id": 2, "name": "Czech Republic", "isNational": true, "players": [
+		{"id": "8", "name": "Tomas Vaclik", "age": "27"}
+	]}}}`)
+
+	// The team and a player are renamed, while another player transfers.
+	refresh(`{"data": {"team": {"id": 1, "name": "Apoel Nicosia", "players": [
+		{"id": "6", "name": "Nuno Morais Barbosa", "age": "33"}
+	]}}}`, `{"data": {"team": {"id": 2, "name": "Czech Republic", "isNational": true, "players": [
+		{"id": "7", "name": "Tomas Sivok", "age": "33"},
+		{"id": "8", "name": "Tomas Vaclik", "age": "27"}
+	]}}}`)
+
+	repo := goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir), goleveldb.ReadOnly)
+
+	if p, err := repo.GetPlayer("7"); err != nil || len(p.Teams) != 1 || p.Teams[0] != 2 {
+		t.Fatalf("expected the transferred player to only list the new team, got %+v, %+v", p, err)
+	}
+
+	if p, err := repo.GetPlayer("6"); err != nil || p.Name != "Nuno Morais Barbosa" || p.Age != 33 {
+		t.Fatalf("expected the player to be updated, got %+v, %+v", p, err)
+	}
+
+	if _, err := repo.GetTeamByName("Apoel FC"); !storage.IsNotFound(err) {
+		t.Fatalf("expected the previous team name not to be found, got %+v", err)
+	}
+
+	if team, err := repo.GetTeamByName("Apoel Nicosia"); err != nil || team.Id != 1 {
+		t.Fatalf("expected the renamed team to be found, got %+v, %+v", team, err)
+	}
+	repo.Close()
+
+	if issues, err := goleveldb.Check(goleveldb.Path(dir)); err != nil || len(issues) > 0 {
+		t.Fatalf("expected no issues after refreshing, got %v, %+v", issues, err)
+	}
+
+	stats, err := goleveldb.GetStats(goleveldb.Path(dir))
+	if err != nil {
+		t.Fatalf("error getting stats: %+v", err)
+	}
+
+	db, err := leveldb.OpenFile(stats.Generation, nil)
+	if err != nil {
+		t.Fatalf("error opening database: %+v", err)
+	}
+
+	entries := 0
+	iter := db.NewIterator(util.BytesPrefix([]byte("player_name_index_")), nil)
+	for iter.Next() {
+		if bytes.HasSuffix(iter.Key(), []byte("\x006")) {
+			entries++
+		}
+	}
+	iter.Release()
+
+	if entries != 1 {
+		t.Fatalf("expected a single name index entry of the renamed player, got %d", entries)
+	}
+	db.Close()
+
+	// Teams missing from a refresh are only kept in the history.
+	refresh(`{"data": {"team": {"id": 3, "name": "D2", "players": []}}}`, `{"data": {"team": {"id": 2, "name": "Czech Republic", "isNational": true, "players": [
+		{"id": "8", "name": "Tomas Vaclik", "age": "27"}
+	]}}}`)
+
+	repo = goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir), goleveldb.ReadOnly)
+	defer repo.Close()
+
+	if _, err := repo.GetTeam(1); !storage.IsNotFound(err) {
+		t.Fatalf("expected the missing team to be removed, got %+v", err)
+	}
+
+	if _, err := repo.GetPlayer("7"); !storage.IsNotFound(err) {
+		t.Fatalf("expected the player no longer listed by any team to be removed, got %+v", err)
+	}
+
+	if teams, _, err := repo.(football.Lister).ListTeams("", 0); err != nil || len(teams) != 2 {
+		t.Fatalf("expected the two refreshed teams, got %+v, %+v", teams, err)
+	}
+
+	if history, err := repo.(storage.Historian).GetTeamHistory(1); err != nil || len(history) != 2 {
+		t.Fatalf("expected the history of the missing team to be kept, got %+v, %+v", history, err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	data := make(chan download.Team, 2)
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"}
+	]}}}`), Id: 1}
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 2, "name": "Czech Republic", "isNational": true, "players": [
+		{"id": "7", "name": "Tomas Sivok", "age": "33"}
+	]}}}`), Id: 2}
+	close(data)
+
+	repo := goleveldb.NewTeamRepository(data, goleveldb.Path(dir))
+	if _, err := repo.GetTeam(1); err != nil {
+		t.Fatalf("error getting team: %+v", err)
+	}
+
+	if err := repo.Close(); err != nil {
+		t.Fatalf("error closing repository: %+v", err)
+	}
+
+	if issues, err := goleveldb.Check(goleveldb.Path(dir)); err != nil || len(issues) != 0 {
+		t.Fatalf("expected no issues, got %v, %+v", issues, err)
+	}
+
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(football.Player{
+		Id: "6", Name: "Nuno Morais", Age: 32, Teams: []football.TeamId{1, 1, 2},
+	}); err != nil {
+		t.Fatalf("error encoding player: %+v", err)
+	}
+
+	stats, err := goleveldb.GetStats(goleveldb.Path(dir))
+	if err != nil {
+		t.Fatalf("error getting stats: %+v", err)
+	}
+
+	db, err := leveldb.OpenFile(stats.Generation, nil)
+	if err != nil {
+		t.Fatalf("error opening database: %+v", err)
+	}
+
+	batch := &leveldb.Batch{}
+	batch.Put([]byte("data_player_6"), b.Bytes())
+	batch.Put([]byte("data_team_3"), []byte("garbage"))
+	batch.Put([]byte("team_name_index_Levski Sofia\x004"), nil)
+
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("error corrupting database: %+v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("error closing database: %+v", err)
+	}
+
+	expected := []goleveldb.IssueKind{
+		goleveldb.Undecodable, goleveldb.DanglingIndex,
+		goleveldb.DuplicateMembership, goleveldb.AsymmetricMembership,
+	}
+
+	for _, fix := range []func(...goleveldb.Option) ([]goleveldb.Issue, error){goleveldb.Check, goleveldb.Repair} {
+		issues, err := fix(goleveldb.Path(dir))
+		if err != nil {
+			t.Fatalf("error checking database: %+v", err)
+		}
+
+		if len(issues) != len(expected) {
+			t.Fatalf("expected %v issues, got %v", expected, issues)
+		}
+
+		for i := range expected {
+			if issues[i].Kind != expected[i] {
+				t.Fatalf("expected %v issues, got %v", expected, issues)
+			}
+		}
+	}
+
+	if issues, err := goleveldb.Check(goleveldb.Path(dir)); err != nil || len(issues) != 0 {
+		t.Fatalf("expected no issues after repairing, got %v, %+v", issues, err)
+	}
+
+	// The repaired data is stale, and would be replaced if not read only.
+	repo = goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir), goleveldb.ReadOnly)
+	defer repo.Close()
+
+	if p, err := repo.GetPlayer("6"); err != nil || len(p.Teams) != 1 || p.Teams[0] != 1 {
+		t.Fatalf("expected player 6 to only be in team 1, got %+v, %+v", p, err)
+	}
+
+	if _, err := repo.GetTeamByName("Levski Sofia"); !storage.IsNotFound(err) {
+		t.Fatalf("expected the dangling index entry to be removed, got %+v", err)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
 	}()
 
+	apoel := `{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"},
+		{"id": "7", "name": "Tomas Sivok", "age": "33"}
+	]}}}`
+	czech := `{"data": {"team": {"id": 2, "name": "Czech Republic", "isNational": true, "players": [
+		{"id": "7", "name": "Tomas Sivok", "age": "33"}
+	]}}}`
+
+	for _, teams := range [][]string{{apoel, czech}, {czech}} {
+		data := make(chan download.Team, len(teams))
+		for i, team := range teams {
+			data <- download.Team{Bytes: []byte(team), Id: i + 1}
+		}
+		close(data)
+
+		repo := goleveldb.NewTeamRepository(data, goleveldb.Path(dir), goleveldb.Refresh)
+		if _, err := repo.GetTeam(2); err != nil {
+			t.Fatalf("error getting team: %+v", err)
+		}
+
+		if err := repo.Close(); err != nil {
+			t.Fatalf("error closing repository: %+v", err)
+		}
+	}
+
+	stats, err := goleveldb.GetStats(goleveldb.Path(dir))
+	if err != nil {
+		t.Fatalf("error getting stats: %+v", err)
+	}
+
+	if stats.Size == 0 || stats.UpdatedAt.IsZero() || stats.IndexVersion == "" {
+		t.Fatalf("expected the size and metadata to be set, got %+v", stats)
+	}
+
+	counts := map[string]int{}
+	for _, r := range stats.Records {
+		counts[r.Prefix] = r.Count
+	}
+
+	// The team missing from the latest refresh is only kept in the history.
+	if counts["data_team_"] != 1 || counts["data_player_"] != 1 || counts["team_history_"] != 3 || counts[""] != 2 {
+		t.Fatalf("expected a team and player, three versions and the metadata, got %+v", stats.Records)
+	}
+
+	encode := func(v interface{}) []byte {
+		var b bytes.Buffer
+		if err := gob.NewEncoder(&b).Encode(v); err != nil {
+			t.Fatalf("error encoding record: %+v", err)
+		}
+
+		return b.Bytes()
+	}
+
+	// Records of the removed team, and an index entry of an earlier name, as
+	// left behind by older versions.
+	db, err := leveldb.OpenFile(stats.Generation, nil)
+	if err != nil {
+		t.Fatalf("error opening database: %+v", err)
+	}
+
+	batch := &leveldb.Batch{}
+	batch.Put([]byte("data_team_1"), encode(football.Team{
+		Id: 1, Name: "Apoel FC", Players: []football.PlayerId{"6", "7"},
+	}))
+	batch.Put([]byte("data_player_6"), encode(football.Player{
+		Id: "6", Name: "Nuno Morais", Age: 32, Teams: []football.TeamId{1},
+	}))
+	batch.Put([]byte("data_player_7"), encode(football.Player{
+		Id: "7", Name: "Tomas Sivok", Age: 33, Teams: []football.TeamId{1, 2},
+	}))
+	batch.Put([]byte("team_name_index_Apoel FC\x001"), nil)
+	batch.Put([]byte("team_name_index_Czech Rep\x002"), nil)
+
+	if err := db.Write(batch, nil); err != nil {
+		t.Fatalf("error adding records: %+v", err)
+	}
+	db.Close()
+
+	teams, players, err := goleveldb.Prune(goleveldb.Path(dir))
+	if err != nil {
+		t.Fatalf("error pruning database: %+v", err)
+	}
+
+	if len(teams) != 1 || teams[0].Id != 1 || len(players) != 1 || players[0].Id != "6" {
+		t.Fatalf("expected team 1 and player 6 to be pruned, got %+v, %+v", teams, players)
+	}
+
+	if err := goleveldb.Compact(goleveldb.Path(dir)); err != nil {
+		t.Fatalf("error compacting database: %+v", err)
+	}
+
+	if issues, err := goleveldb.Check(goleveldb.Path(dir)); err != nil || len(issues) != 0 {
+		t.Fatalf("expected no issues after pruning, got %v, %+v", issues, err)
+	}
+
+	repo := goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir))
+	defer repo.Close()
+
+	if _, err := repo.GetTeamByName("Apoel FC"); !storage.IsNotFound(err) {
+		t.Fatalf("expected the pruned team to be removed, got %+v", err)
+	}
+
+	if _, err := repo.GetTeamByName("Czech Rep"); !storage.IsNotFound(err) {
+		t.Fatalf("expected the earlier team name to be removed, got %+v", err)
+	}
+
+	if _, err := repo.GetPlayer("6"); !storage.IsNotFound(err) {
+		t.Fatalf("expected the orphaned player to be removed, got %+v", err)
+	}
+
+	if p, err := repo.GetPlayer("7"); err != nil || len(p.Teams) != 1 || p.Teams[0] != 2 {
+		t.Fatalf("expected player 7 to only be in team 2, got %+v, %+v", p, err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
 	defer func() {
-		os.RemoveAll("/tmp/football-teams.db")
+		os.RemoveAll(dir)
 	}()
 
-	repo := goleveldb.NewTeamRepository(data)
-	_, err := repo.GetTeam(football.TeamId(1))
+	reader := goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir), goleveldb.ReadOnly)
+	if _, err := reader.GetTeam(1); !storage.IsInitializer(err) {
+		t.Fatalf("expected a read only repository without any data to be unavailable, got %+v", err)
+	}
+	reader.Close()
+
+	feed := func(teams ...string) <-chan download.Team {
+		data := make(chan download.Team, len(teams))
+		for i, team := range teams {
+			data <- download.Team{Bytes: []byte(team), Id: i + 1}
+		}
+		close(data)
+
+		return data
+	}
+
+	apoel := `{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"}
+	]}}}`
+	czech := `{"data": {"team": {"id": 2, "name": "Czech Republic", "isNational": true, "players": [
+		{"id": "7", "name": "Tomas Sivok", "age": "33"}
+	]}}}`
+
+	poll := goleveldb.Poll(10 * time.Millisecond)
+
+	writer := goleveldb.NewTeamRepository(feed(apoel), goleveldb.Path(dir), poll)
+	defer writer.Close()
+
+	if _, err := writer.GetTeam(1); err != nil {
+		t.Fatalf("error getting team: %+v", err)
+	}
+
+	// Any number of processes can read the stored data meanwhile.
+	reader = goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir), goleveldb.ReadOnly, poll)
+	defer reader.Close()
+
+	if _, err := reader.GetTeam(1); err != nil {
+		t.Fatalf("error getting team from a read only repository: %+v", err)
+	}
+
+	if _, err := reader.GetTeam(2); !storage.IsNotFound(err) {
+		t.Fatalf("expected team 2 to be missing, got %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := reader.(storage.Watcher).Subscribe(ctx)
+
+	refresher := goleveldb.NewTeamRepository(feed(apoel, czech), goleveldb.Path(dir), goleveldb.Refresh, poll)
+	defer refresher.Close()
+
+	if _, err := refresher.GetTeam(2); err != nil {
+		t.Fatalf("error getting refreshed team: %+v", err)
+	}
+
+	for _, repo := range []football.TeamRepository{reader, writer} {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, err := repo.GetTeam(2)
+			if err == nil {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the refreshed data to be picked up, got %+v", err)
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
 
-	type init interface {
-		IsInitializer() bool
+	if history, err := reader.(storage.Historian).GetTeamHistory(1); err != nil || len(history) != 1 {
+		t.Fatalf("expected the history to carry over to the new generation, got %+v, %+v", history, err)
 	}
 
-	if !storage.IsInitializer(err) {
-		t.Fatalf("expected init error, got %+v", err)
+	// The reader publishes the changes of the generation it switched to.
+	expected := []struct {
+		kind   football.ChangeKind
+		team   football.TeamId
+		player football.PlayerId
+	}{
+		{football.TeamAdded, 2, ""},
+		{football.PlayerJoined, 2, "7"},
+	}
+
+	for _, e := range expected {
+		select {
+		case c := <-changes:
+			if c.Kind != e.kind || c.Team.Id != e.team || c.Player.Id != e.player {
+				t.Fatalf("expected %s of team %d and player %s, got %+v", e.kind, e.team, e.player, c)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %s of team %d and player %s", e.kind, e.team, e.player)
+		}
+	}
+
+	reader.Close()
+	if _, ok := <-changes; ok {
+		t.Fatalf("expected the subscription to end once the repository is closed")
 	}
 }
 
-const (
-	team1 = `{"status":"ok","code":0,"data":{"team":{"id":1,"optaId":479,"name":"Apoel FC","logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/1.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/1.png"}],"isNational":false,"matches":{"last":{"scoreaway":"1","scorehome":"3","status":"FullTime","id":504345,"competitionId":7,"seasonId":1709,"stadiumId":335,"matchdayId":5669746,"matchday":{"id":5669746},"kickoff":"2016-10-20T19:05:00Z","minute":94,"teamhome":{"idInternal":347,"id":1963,"name":"BSC YB","colors":{"shirtColorHome":"FF9900","shirtColorAway":"FFFFFF","crestMainColor":"","mainColor":"FF9900"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/347.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/347.png"}]},"teamaway":{"idInternal":1,"id":479,"name":"Apoel FC","colors":{"shirtColorHome":"0066CC","shirtColorAway":"FF9966","crestMainColor":"4F2C7D","mainColor":"0066CC"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/1.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/1.png"}]}},"next":{"scoreaway":"-1","scorehome":"-1","status":"PreMatch","id":504367,"competitionId":7,"seasonId":1709,"stadiumId":24,"matchdayId":5669747,"matchday":{"id":5669747},"kickoff":"2016-11-03T18:00:00Z","minute":0,"teamhome":{"idInternal":1,"id":479,"name":"Apoel FC","colors":{"shirtColorHome":"0066CC","shirtColorAway":"FF9966","crestMainColor":"4F2C7D","mainColor":"0066CC"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/1.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/1.png"}]},"teamaway":{"idInternal":347,"id":1963,"name":"BSC YB","colors":{"shirtColorHome":"FF9900","shirtColorAway":"FFFFFF","crestMainColor":"","mainColor":"FF9900"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/347.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/347.png"}]}},"following":{"scoreaway":"-1","scorehome":"-1","status":"PreMatch","id":504395,"competitionId":7,"seasonId":1709,"stadiumId":681,"matchdayId":5669748,"matchday":{"id":5669748},"kickoff":"2016-11-24T16:00:00Z","minute":0,"teamhome":{"idInternal":1874,"id":3751,"name":"FC Astana","colors":{"shirtColorHome":"","shirtColorAway":"","crestMainColor":"2B2667","mainColor":"2B2667"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/1874.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/1874.png"}]},"teamaway":{"idInternal":1,"id":479,"name":"Apoel FC","colors":{"shirtColorHome":"0066CC","shirtColorAway":"FF9966","crestMainColor":"4F2C7D","mainColor":"0066CC"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/1.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/1.png"}]}}},"competitions":[{"competitionId":140},{"competitionId":21},{"competitionId":7}],"players":[{"country":"Portugal","id":"6","firstName":"Nuno Miguel","lastName":"Morais Barbosa","name":"Nuno Morais","position":"Midfielder","number":26,"birthDate":"1984-01-29","age":"32","height":185,"weight":76,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"19","firstName":"Nektarious","lastName":"Alexandrou","name":"Nektarious Alexandrou","position":"Midfielder","number":11,"birthDate":"1983-12-19","age":"32","height":182,"weight":76,"thumbnailSrc":"https:\/\/images.onefootball.com\/player\/98\/98bdd1b3e9ba596ffb0d8c09071a0577.jpg"},{"country":"Spain","id":"770","firstName":"Urko","lastName":"Pardo","name":"Urko Pardo","position":"Goalkeeper","number":78,"birthDate":"1983-01-28","age":"33","height":189,"weight":85,"thumbnailSrc":"https:\/\/images.onefootball.com\/player\/36\/36a9143ede9200fff4fbae81db38da60.jpg"},{"country":"Belgium","id":"915","firstName":"Igor","lastName":"de Camargo","name":"Igor de Camargo","position":"Forward","number":9,"birthDate":"1983-05-12","age":"33","height":187,"weight":83,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/915.jpg"},{"country":"Argentina","id":"2311","firstName":"Facundo","lastName":"Bertoglio","name":"Facundo Bertoglio","position":"Midfielder","number":10,"birthDate":"1990-06-30","age":"26","height":172,"weight":65,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Brazil","id":"5075","firstName":"Carlos Roberto","lastName":"da Cruz Junior","name":"Carlao","position":"Defender","number":5,"birthDate":"1986-01-19","age":"30","height":183,"weight":76,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Belarus","id":"6922","firstName":"Renan","lastName":"Bardini Bressan","name":"Renan Bressan","position":"Midfielder","number":88,"birthDate":"1988-11-03","age":"27","height":182,"weight":77,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"7586","firstName":"Efstathios","lastName":"Aloneftis","name":"Efstathios Aloneftis","position":"Midfielder","number":46,"birthDate":"1983-03-29","age":"33","height":166,"weight":62,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"7598","firstName":"Georgios","lastName":"Efrem","name":"Georgios Efrem","position":"Midfielder","number":7,"birthDate":"1989-07-05","age":"27","height":174,"weight":70,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"8029","firstName":"Giorgos","lastName":"Merkis","name":"Giorgos Merkis","position":"Defender","number":30,"birthDate":"1984-07-30","age":"32","height":183,"weight":78,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Spain","id":"12108","firstName":"Andrea","lastName":"Orlandi","name":"Andrea Orlandi","position":"Midfielder","number":8,"birthDate":"1984-08-03","age":"32","height":180,"weight":78,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Spain","id":"12204","firstName":"Roberto","lastName":"Lago","name":"Roberto Lago","position":"Defender","number":3,"birthDate":"1985-08-30","age":"31","height":178,"weight":70,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/12204.jpg"},{"country":"Bulgaria","id":"14775","firstName":"Zhivko","lastName":"Milanov","name":"Zhivko Milanov","position":"Defender","number":21,"birthDate":"1984-07-15","age":"32","height":177,"weight":71,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Brazil","id":"18651","firstName":"Vinicius","lastName":"Oliveira Franco","name":"Vinicius","position":"Midfielder","number":16,"birthDate":"1986-05-16","age":"30","height":186,"weight":74,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Netherlands","id":"20459","firstName":"Boy","lastName":"Waterman","name":"Boy Waterman","position":"Goalkeeper","number":99,"birthDate":"1984-01-24","age":"32","height":188,"weight":91,"thumbnailSrc":"https:\/\/images.onefootball.com\/player\/b4\/b4e7fe7ff16121d2ece4f7ad7cc7391a.jpg"},{"country":"Spain","id":"23382","firstName":"Inaki","lastName":"Astiz","name":"Inaki Astiz","position":"Defender","number":23,"birthDate":"1983-11-05","age":"32","height":185,"weight":73,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/23382.jpg"},{"country":"Portugal","id":"27915","firstName":"Mario","lastName":"Sergio","name":"Mario Sergio","position":"Defender","number":28,"birthDate":"1981-07-28","age":"35","height":174,"weight":70,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Greece","id":"33568","firstName":"Giannis","lastName":"Gianniotas","name":"Giannis Gianniotas","position":"Midfielder","number":70,"birthDate":"1993-04-29","age":"23","height":174,"weight":71,"thumbnailSrc":"https:\/\/images.onefootball.com\/player\/49\/49b89c316379e14fdb785c602fdc1039.jpg"},{"country":"Cyprus","id":"36113","firstName":"Kostakis","lastName":"Artymatas","name":"Kostakis Artymatas","position":"Midfielder","number":4,"birthDate":"1993-04-15","age":"23","height":184,"weight":77,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"36114","firstName":"Pieros","lastName":"Soteriou","name":"Pieros Soteriou","position":"Forward","number":20,"birthDate":"1993-01-13","age":"23","height":186,"weight":81,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Brazil","id":"50382","firstName":"Vander","lastName":"Vieira","name":"Vander Vieira","position":"Midfielder","number":77,"birthDate":"1988-10-03","age":"28","height":172,"weight":76,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"62036","firstName":"Vasilios","lastName":"Papafotis","name":"Vasilios Papafotis","position":"Midfielder","number":31,"birthDate":"1995-08-10","age":"21","height":178,"weight":66,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"68641","firstName":"Nicholas","lastName":"Ioannou","name":"Nicholas Ioannou","position":"Defender","number":44,"birthDate":"1995-11-10","age":"20","height":183,"weight":77,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Albania","id":"111745","firstName":"Qazim","lastName":"Laci","name":"Qazim Laci","position":"Midfielder","number":14,"birthDate":"1996-01-19","age":"20","height":176,"weight":80,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"179472","firstName":"Kypros","lastName":"Christoforou","name":"Kypros Christoforou","position":"Defender","number":0,"birthDate":"1993-04-23","age":"23","height":0,"weight":0,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"185880","firstName":"Andreas","lastName":"Paraskevas","name":"Andreas Paraskevas","position":"Goalkeeper","number":98,"birthDate":"1998-09-15","age":"18","height":187,"weight":79,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Cyprus","id":"185884","firstName":"Michalis","lastName":"Charalampous","name":"Michalis Charalampous","position":"Forward","number":19,"birthDate":"1999-01-29","age":"17","height":0,"weight":0,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"}],"officials":[{"countryName":"Spain","id":"49381","firstName":"Thomas","lastName":"Christiansen","country":"ES","position":"Coach"}],"colors":{"shirtColorHome":"0066CC","shirtColorAway":"FF9966","crestMainColor":"4F2C7D","mainColor":"0066CC"}}},"message":"Team feed successfully generated. Api Version: 1"}`
-	team2 = `{"status":"ok","code":0,"data":{"team":{"id":50,"optaId":5382,"name":"D2","logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/50.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/50.png"}],"isNational":false,"matches":{},"competitions":[],"players":[],"officials":[],"colors":{"shirtColorHome":"","shirtColorAway":"","crestMainColor":"","mainColor":""}}},"message":"Team feed successfully generated. Api Version: 1"}`
-	team3 = `{"status":"ok","code":0,"data":{"team":{"id":100,"optaId":367,"name":"Czech Republic","logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/100.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/100.png"}],"isNational":true,"matches":{"last":{"scoreaway":"0","scorehome":"0","status":"FullTime","id":450538,"competitionId":69,"seasonId":1319,"stadiumId":6512,"matchdayId":5663870,"matchday":{"id":5663870},"kickoff":"2016-10-11T18:45:00Z","minute":96,"teamhome":{"idInternal":100,"id":367,"name":"Czech Republic","colors":{"shirtColorHome":"CC0000","shirtColorAway":"FFFFFF","crestMainColor":"D5131A","mainColor":"CC0000"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/100.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/100.png"}]},"teamaway":{"idInternal":309,"id":505,"name":"Azerbaijan","colors":{"shirtColorHome":"FF0000","shirtColorAway":"3366FF","crestMainColor":"0E8EBA","mainColor":"FF0000"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/309.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/309.png"}]}},"next":{"scoreaway":"-1","scorehome":"-1","status":"PreMatch","id":450548,"competitionId":69,"seasonId":1319,"stadiumId":478,"matchdayId":5663871,"matchday":{"id":5663871},"kickoff":"2016-11-11T19:45:00Z","minute":0,"teamhome":{"idInternal":100,"id":367,"name":"Czech Republic","colors":{"shirtColorHome":"CC0000","shirtColorAway":"FFFFFF","crestMainColor":"D5131A","mainColor":"CC0000"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/100.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/100.png"}]},"teamaway":{"idInternal":115,"id":363,"name":"Norway","colors":{"shirtColorHome":"CC0000","shirtColorAway":"FFFFFF","crestMainColor":"EE2B2C","mainColor":"CC0000"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/115.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/115.png"}]}},"following":{"scoreaway":"-1","scorehome":"-1","status":"PreMatch","id":450610,"competitionId":69,"seasonId":1319,"stadiumId":266,"matchdayId":5663872,"matchday":{"id":5663872},"kickoff":"2017-03-26T16:00:00Z","minute":0,"teamhome":{"idInternal":300,"id":495,"name":"San Marino","colors":{"shirtColorHome":"0000FF","shirtColorAway":"FFFFFF","crestMainColor":"5EB6E3","mainColor":"0000FF"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/300.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/300.png"}]},"teamaway":{"idInternal":100,"id":367,"name":"Czech Republic","colors":{"shirtColorHome":"CC0000","shirtColorAway":"FFFFFF","crestMainColor":"D5131A","mainColor":"CC0000"},"logoUrls":[{"size":"56x56","url":"https:\/\/images.onefootball.com\/icons\/internal\/56\/100.png"},{"size":"164x164","url":"https:\/\/images.onefootball.com\/icons\/internal\/164\/100.png"}]}}},"competitions":[{"competitionId":69},{"competitionId":20},{"competitionId":24}],"players":[{"country":"Czech Republic","id":"46","firstName":"Tomas","lastName":"Rosicky","name":"Tomas Rosicky","position":"Midfielder","number":0,"birthDate":"1980-10-04","age":"36","height":178,"weight":65,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/46.jpg"},{"country":"Czech Republic","id":"194","firstName":"Tomas","lastName":"Sivok","name":"Tomas Sivok","position":"Defender","number":0,"birthDate":"1983-09-15","age":"33","height":184,"weight":77,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/194.jpg"},{"country":"Czech Republic","id":"235","firstName":"Jaroslav","lastName":"Plasil","name":"Jaroslav Plasil","position":"Midfielder","number":0,"birthDate":"1982-01-05","age":"34","height":182,"weight":72,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/235.jpg"},{"country":"Czech Republic","id":"300","firstName":"Tomas","lastName":"Necid","name":"Tomas Necid","position":"Forward","number":0,"birthDate":"1989-08-13","age":"27","height":190,"weight":89,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/300.jpg"},{"country":"Czech Republic","id":"1846","firstName":"Daniel","lastName":"Pudil","name":"Daniel Pudil","position":"Defender","number":0,"birthDate":"1985-09-27","age":"31","height":185,"weight":81,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/1846.jpg"},{"country":"Czech Republic","id":"1853","firstName":"Michal","lastName":"Kadlec","name":"Michal Kadlec","position":"Defender","number":0,"birthDate":"1984-12-13","age":"31","height":185,"weight":76,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/1853.jpg"},{"country":"Czech Republic","id":"1857","firstName":"Jaroslav","lastName":"Drobny","name":"Jaroslav Drobny","position":"Goalkeeper","number":0,"birthDate":"1979-10-18","age":"37","height":192,"weight":90,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/1857.jpg"},{"country":"Czech Republic","id":"1860","firstName":"David","lastName":"Lafata","name":"David Lafata","position":"Forward","number":0,"birthDate":"1981-09-18","age":"35","height":180,"weight":69,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/1860.jpg"},{"country":"Czech Republic","id":"1861","firstName":"Marek","lastName":"Suchy","name":"Marek Suchy","position":"Defender","number":0,"birthDate":"1988-03-29","age":"28","height":183,"weight":80,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/1861.jpg"},{"country":"Czech Republic","id":"1863","firstName":"Roman","lastName":"Hubnik","name":"Roman Hubnik","position":"Defender","number":0,"birthDate":"1984-06-06","age":"32","height":192,"weight":83,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/1863.jpg"},{"country":"Czech Republic","id":"2831","firstName":"Matej","lastName":"Vydra","name":"Matej Vydra","position":"Forward","number":0,"birthDate":"1992-05-01","age":"24","height":180,"weight":71,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"5556","firstName":"Borek","lastName":"Dockal","name":"Borek Dockal","position":"Midfielder","number":0,"birthDate":"1988-09-30","age":"28","height":182,"weight":71,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/5556.jpg"},{"country":"Czech Republic","id":"7805","firstName":"Vaclav","lastName":"Kadlec","name":"Vaclav Kadlec","position":"Forward","number":0,"birthDate":"1992-05-20","age":"24","height":181,"weight":77,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/7805.jpg"},{"country":"Czech Republic","id":"7815","firstName":"Ladislav","lastName":"Krejci","name":"Ladislav Krejci","position":"Midfielder","number":0,"birthDate":"1992-07-05","age":"24","height":180,"weight":68,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/7815.jpg"},{"country":"Czech Republic","id":"11122","firstName":"David","lastName":"Limbersky","name":"David Limbersky","position":"Defender","number":0,"birthDate":"1983-10-06","age":"33","height":178,"weight":73,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/11122.jpg"},{"country":"Czech Republic","id":"11126","firstName":"Daniel","lastName":"Kol\u00e1r","name":"Daniel Kol\u00e1r","position":"Midfielder","number":0,"birthDate":"1985-10-27","age":"30","height":179,"weight":76,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/11126.jpg"},{"country":"Czech Republic","id":"17050","firstName":"Pavel","lastName":"Kader\u00e1bek","name":"Pavel Kader\u00e1bek","position":"Defender","number":0,"birthDate":"1992-04-25","age":"24","height":182,"weight":81,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/17050.jpg"},{"country":"Czech Republic","id":"17051","firstName":"Jiri","lastName":"Skalak","name":"Jiri Skalak","position":"Midfielder","number":0,"birthDate":"1992-03-12","age":"24","height":177,"weight":76,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/17051.jpg"},{"country":"Czech Republic","id":"19492","firstName":"Tom\u00e1s","lastName":"Vaclik","name":"Tom\u00e1s Vaclik","position":"Goalkeeper","number":0,"birthDate":"1989-03-29","age":"27","height":188,"weight":84,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/19492.jpg"},{"country":"Czech Republic","id":"20152","firstName":"Theodor","lastName":"Gebre Selassie","name":"Theodor Gebre Selassie","position":"Defender","number":0,"birthDate":"1986-12-24","age":"29","height":181,"weight":71,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/20152.jpg"},{"country":"Czech Republic","id":"22864","firstName":"Vladimir","lastName":"Darida","name":"Vladimir Darida","position":"Midfielder","number":0,"birthDate":"1990-08-08","age":"26","height":171,"weight":64,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/22864.jpg"},{"country":"Czech Republic","id":"23155","firstName":"Filip","lastName":"Novak","name":"Filip Novak","position":"Defender","number":0,"birthDate":"1990-06-26","age":"26","height":0,"weight":0,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"23163","firstName":"Jan","lastName":"Kopic","name":"Jan Kopic","position":"Midfielder","number":0,"birthDate":"1990-06-04","age":"26","height":177,"weight":71,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"23444","firstName":"Ondrej","lastName":"Zahustel","name":"Ondrej Zahustel","position":"Midfielder","number":25,"birthDate":"1991-06-18","age":"25","height":183,"weight":70,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"23596","firstName":"Jakub","lastName":"Brabec","name":"Jakub Brabec","position":"Defender","number":5,"birthDate":"1992-08-06","age":"24","height":186,"weight":78,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"23598","firstName":"David","lastName":"Pavelka","name":"David Pavelka","position":"Midfielder","number":0,"birthDate":"1991-05-18","age":"25","height":184,"weight":74,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/23598.jpg"},{"country":"Czech Republic","id":"26476","firstName":"Josef","lastName":"Sural","name":"Josef Sural","position":"Midfielder","number":0,"birthDate":"1990-05-30","age":"26","height":184,"weight":81,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/26476.jpg"},{"country":"Czech Republic","id":"26659","firstName":"Martin","lastName":"Frydek","name":"Martin Frydek","position":"Midfielder","number":0,"birthDate":"1992-03-24","age":"24","height":180,"weight":76,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"27321","firstName":"Tomas","lastName":"Kalas","name":"Tomas Kalas","position":"Defender","number":0,"birthDate":"1993-05-15","age":"23","height":182,"weight":73,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/27321.jpg"},{"country":"Czech Republic","id":"38968","firstName":"Martin","lastName":"Pospisil","name":"Martin Pospisil","position":"Midfielder","number":0,"birthDate":"1991-06-26","age":"25","height":178,"weight":73,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"38978","firstName":"Tomas","lastName":"Horava","name":"Tomas Horava","position":"Midfielder","number":0,"birthDate":"1988-05-29","age":"28","height":180,"weight":68,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"94163","firstName":"Jiri","lastName":"Pavlenka","name":"Jiri Pavlenka","position":"Goalkeeper","number":23,"birthDate":"1992-04-14","age":"24","height":0,"weight":0,"thumbnailSrc":"https:\/\/images.onefootball.com\/default\/default_player.png"},{"country":"Czech Republic","id":"96191","firstName":"Milan","lastName":"Skoda","name":"Milan Skoda","position":"Forward","number":0,"birthDate":"1986-01-16","age":"30","height":190,"weight":80,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/96191.jpg"},{"country":"Czech Republic","id":"103943","firstName":"Tomas","lastName":"Koubek","name":"Tomas Koubek","position":"Goalkeeper","number":0,"birthDate":"1992-08-26","age":"24","height":197,"weight":99,"thumbnailSrc":"https:\/\/images.onefootball.com\/players\/103943.jpg"}],"officials":[{"countryName":"Czech Republic","id":"33779","firstName":"Karel","lastName":"Jarol\u00edm","country":"CZ","position":"Coach"}],"colors":{"shirtColorHome":"CC0000","shirtColorAway":"FFFFFF","crestMainColor":"D5131A","mainColor":"CC0000"}}},"message":"Team feed successfully generated. Api Version: 1"}`
-	team4 = `
-{
-  "status": "ok",
-  "code": 0,
-  "data": {
-    "team": {
-      "id": 200,
-      "name": "Test 1",
-	  "IsNational": true,
-	  "players": [
-		  {
-			  "id": "235",
-			  "name": "Jaroslav Plasil",
-			  "age": 34
-		  },
-		  {
-			  "id": "6",
-			  "name": "Nuno Morais",
-			  "age": "32"
-		  }
-	  ]
-	}
-  }
+func TestEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	key, err := goleveldb.ParseKey("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Fatalf("error parsing key: %+v", err)
+	}
+
+	rotated, err := goleveldb.ParseKey("ICEiIyQlJicoKSorLC0uLzAxMjM0NTY3ODk6Ozw9Pj8=\n")
+	if err != nil {
+		t.Fatalf("error parsing base64 key: %+v", err)
+	}
+
+	if _, err := goleveldb.ParseKey("0001"); err == nil {
+		t.Fatalf("expected a short key to be rejected")
+	}
+
+	data := make(chan download.Team, 1)
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 1, "name": "Apoel FC", "players": [
+		{"id": "6", "name": "Nuno Morais", "age": "32"}
+	]}}}`), Id: 1}
+	close(data)
+
+	repo := goleveldb.NewTeamRepository(data, goleveldb.Path(dir), goleveldb.Encryption(key))
+	if _, err := repo.GetTeam(1); err != nil {
+		t.Fatalf("error getting team: %+v", err)
+	}
+	repo.Close()
+
+	stats, err := goleveldb.GetStats(goleveldb.Path(dir))
+	if err != nil {
+		t.Fatalf("error getting stats: %+v", err)
+	}
+
+	if !stats.Encrypted {
+		t.Fatalf("expected the stats to report encryption")
+	}
+
+	// Neither the records nor their keys, including those of the name
+	// indices, may reveal the names.
+	scan := func() {
+		stats, err := goleveldb.GetStats(goleveldb.Path(dir))
+		if err != nil {
+			t.Fatalf("error getting stats: %+v", err)
+		}
+
+		db, err := leveldb.OpenFile(stats.Generation, nil)
+		if err != nil {
+			t.Fatalf("error opening database: %+v", err)
+		}
+		defer db.Close()
+
+		iter := db.NewIterator(nil, nil)
+		defer iter.Release()
+
+		for iter.Next() {
+			for _, name := range []string{"apoel", "nuno", "morais"} {
+				if bytes.Contains(bytes.ToLower(iter.Key()), []byte(name)) || bytes.Contains(bytes.ToLower(iter.Value()), []byte(name)) {
+					t.Fatalf("expected %q not to be stored in plain form, got %q", name, iter.Key())
+				}
+			}
+		}
+	}
+
+	scan()
+
+	open := func(opts ...goleveldb.Option) error {
+		repo := goleveldb.NewTeamRepository(make(chan download.Team), append(opts, goleveldb.Path(dir), goleveldb.ReadOnly)...)
+		defer repo.Close()
+
+		if _, err := repo.GetTeam(1); err != nil {
+			return err
+		}
+
+		if _, err := repo.GetTeamByName("apoel"); err != nil {
+			return err
+		}
+
+		if players, err := repo.(football.Searcher).SearchPlayers("morais", 0); err != nil || len(players) != 1 {
+			return fmt.Errorf("expected to find the player, got %+v, %+v", players, err)
+		}
+
+		return nil
+	}
+
+	if err := open(); !storage.IsInitializer(err) {
+		t.Fatalf("expected the data to be unavailable without a key, got %+v", err)
+	}
+
+	if err := open(goleveldb.Encryption(rotated)); !storage.IsInitializer(err) {
+		t.Fatalf("expected the data to be unavailable with a different key, got %+v", err)
+	}
+
+	if err := open(goleveldb.Encryption(key)); err != nil {
+		t.Fatalf("error getting team with the key: %+v", err)
+	}
+
+	if issues, err := goleveldb.Check(goleveldb.Path(dir), goleveldb.Encryption(key)); err != nil || len(issues) > 0 {
+		t.Fatalf("expected no issues, got %v, %+v", issues, err)
+	}
+
+	if err := goleveldb.Reencrypt(rotated, goleveldb.Path(dir), goleveldb.Encryption(rotated)); !storage.IsInitializer(err) {
+		t.Fatalf("expected re-encrypting with the wrong current key to fail, got %+v", err)
+	}
+
+	// A repository still using the previous key keeps serving the data it
+	// has, while reporting why it cannot serve the re-encrypted data.
+	watching := goleveldb.NewTeamRepository(make(chan download.Team), goleveldb.Path(dir), goleveldb.ReadOnly,
+		goleveldb.Encryption(key), goleveldb.Poll(10*time.Millisecond))
+	defer watching.Close()
+
+	if _, err := watching.GetTeam(1); err != nil {
+		t.Fatalf("error getting team before re-encrypting: %+v", err)
+	}
+
+	if err := goleveldb.Reencrypt(rotated, goleveldb.Path(dir), goleveldb.Encryption(key)); err != nil {
+		t.Fatalf("error re-encrypting: %+v", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if err := watching.(storage.Monitor).Status().Err; err != nil {
+			if !storage.IsInitializer(err) {
+				t.Fatalf("expected the key mismatch to be reported, got %+v", err)
+			}
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the key mismatch to be reported")
+		}
+	}
+
+	if _, err := watching.GetTeam(1); err != nil {
+		t.Fatalf("error getting team after re-encrypting: %+v", err)
+	}
+
+	if err := open(goleveldb.Encryption(key)); !storage.IsInitializer(err) {
+		t.Fatalf("expected the previous key to be rejected, got %+v", err)
+	}
+
+	if err := open(goleveldb.Encryption(rotated)); err != nil {
+		t.Fatalf("error getting team with the rotated key: %+v", err)
+	}
+
+	scan()
+
+	if err := goleveldb.Reencrypt(nil, goleveldb.Path(dir), goleveldb.Encryption(rotated)); err != nil {
+		t.Fatalf("error decrypting: %+v", err)
+	}
+
+	if err := open(); err != nil {
+		t.Fatalf("error getting decrypted team: %+v", err)
+	}
+
+	if stats, err := goleveldb.GetStats(goleveldb.Path(dir)); err != nil || stats.Encrypted {
+		t.Fatalf("expected the stats to report no encryption, got %+v, %+v", stats, err)
+	}
 }
-`
-)
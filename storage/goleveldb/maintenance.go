@@ -0,0 +1,350 @@
+package goleveldb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+// Stats describes the contents of the database.
+type Stats struct {
+	// Records are the record counts of each key prefix, ordered by prefix.
+	// Any keys without one of the known prefixes, such as the metadata, are
+	// counted last, under an empty prefix.
+	Records []PrefixCount
+	// Size is the total size of the files of all generations, in bytes.
+	Size int64
+	// UpdatedAt is the time of the last refresh, or zero if there hasn't been
+	// one.
+	UpdatedAt time.Time
+	// IndexVersion is the stored index format, which is refreshed if it
+	// differs from the current one.
+	IndexVersion string
+	// Generation is the directory of the current generation.
+	Generation string
+	// Encrypted tells whether the records are encrypted.
+	Encrypted bool
+}
+
+// PrefixCount is the number of records with a key prefix.
+type PrefixCount struct {
+	Prefix string
+	Count  int
+}
+
+// GetStats counts the records of the current generation at the path, and
+// reads its size and metadata. The generation is opened read only, and may be
+// in use by other repositories.
+func GetStats(opts ...Option) (Stats, error) {
+	o := options{path: defaultPath}
+	o.apply(opts)
+
+	path, err := generationPath(o.path)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	db, err := openMaintained(path, true)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer db.Close()
+
+	prefixes := []string{
+		teamPrefix, playerPrefix, teamNameIndexPrefix, aliasIndexPrefix, shortIndexPrefix, playerIndexPrefix,
+		historyPrefix,
+	}
+	sort.Strings(prefixes)
+
+	counts := map[string]int{}
+
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		prefix := ""
+		for _, p := range prefixes {
+			if bytes.HasPrefix(iter.Key(), []byte(p)) {
+				prefix = p
+				break
+			}
+		}
+
+		counts[prefix]++
+	}
+	iter.Release()
+
+	if err := iter.Error(); err != nil {
+		return Stats{}, errors.Wrap(err, "counting records")
+	}
+
+	s := Stats{Records: []PrefixCount{}, Generation: path}
+	for _, p := range prefixes {
+		s.Records = append(s.Records, PrefixCount{Prefix: p, Count: counts[p]})
+	}
+
+	if counts[""] > 0 {
+		s.Records = append(s.Records, PrefixCount{Count: counts[""]})
+	}
+
+	if s.Size, err = diskSize(o.path); err != nil {
+		return Stats{}, err
+	}
+
+	stamp, err := db.Get(updateTimestampKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return Stats{}, errors.Wrap(err, "getting update timestamp data")
+	}
+
+	if seconds, err := strconv.ParseInt(string(stamp), 10, 64); err == nil {
+		s.UpdatedAt = time.Unix(seconds, 0)
+	}
+
+	version, err := db.Get(indexVersionKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return Stats{}, errors.Wrap(err, "getting index version")
+	}
+	s.IndexVersion = string(version)
+
+	if _, err := db.Get(encryptionCheckKey, nil); err == nil {
+		s.Encrypted = true
+	} else if err != leveldb.ErrNotFound {
+		return Stats{}, errors.Wrap(err, "getting encryption check")
+	}
+
+	return s, nil
+}
+
+// Compact compacts the whole key range of the current generation at the path,
+// discarding deleted and overwritten records. Older generations, no longer in
+// use by any repository, are removed. The current generation must not be in
+// use either.
+func Compact(opts ...Option) error {
+	o := options{path: defaultPath}
+	o.apply(opts)
+
+	path, unlock, err := lockGeneration(o.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	db, err := openMaintained(path, false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := db.CompactRange(util.Range{}); err != nil {
+		return errors.Wrapf(err, "compacting database %s", path)
+	}
+
+	return removeStale(o.path, filepath.Base(path))
+}
+
+// Prune removes the orphaned teams and players of the current generation at
+// the path, and returns them. Teams are orphaned if the latest refresh no
+// longer contained them, and players if no remaining team lists them. The
+// removed teams are also removed from the teams of the remaining players,
+// while their history is kept. Name index entries left behind by earlier names
+// of the remaining teams are removed as well. Refreshes don't keep any of
+// these, so they are only found in data stored by earlier versions, or after a
+// repair. The current generation must not be in use by
+// any repository.
+func Prune(opts ...Option) ([]football.Team, []football.Player, error) {
+	o := options{path: defaultPath}
+	o.apply(opts)
+
+	path, unlock, err := lockGeneration(o.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	opened, err := openMaintained(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer opened.Close()
+
+	db, err := withCipher(opened, o.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	teams, err := getTeams(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pruned := []football.Team{}
+	prunedIds := map[football.TeamId]bool{}
+	members := map[football.PlayerId]bool{}
+
+	for _, t := range teams {
+		versions, err := getVersions(db, t.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if n := len(versions); n > 0 && versions[n-1].Removed {
+			pruned = append(pruned, t)
+			prunedIds[t.Id] = true
+			continue
+		}
+
+		for _, pid := range t.Players {
+			members[pid] = true
+		}
+	}
+
+	players, err := getPlayers(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	orphans := []football.Player{}
+	orphanIds := map[football.PlayerId]bool{}
+	batch := &leveldb.Batch{}
+
+	for _, p := range players {
+		if !members[p.Id] {
+			orphans = append(orphans, p)
+			orphanIds[p.Id] = true
+			batch.Delete([]byte(playerPrefix + string(p.Id)))
+			continue
+		}
+
+		remaining := make([]football.TeamId, 0, len(p.Teams))
+		for _, tid := range p.Teams {
+			if !prunedIds[tid] {
+				remaining = append(remaining, tid)
+			}
+		}
+
+		if len(remaining) != len(p.Teams) {
+			p.Teams = remaining
+			if err := putPlayer(db, p); err != nil {
+				return nil, nil, errors.Wrapf(err, "pruning teams of player %s", p.Id)
+			}
+		}
+	}
+
+	for _, t := range pruned {
+		batch.Delete([]byte(teamPrefix + strconv.Itoa(int(t.Id))))
+	}
+
+	// Index entries of pruned teams, and of earlier names of the remaining
+	// ones, are removed as well.
+	current := map[string]bool{}
+	for _, t := range teams {
+		if prunedIds[t.Id] {
+			continue
+		}
+
+		for _, k := range db.teamIndex(t) {
+			current[k] = true
+		}
+	}
+
+	for _, prefix := range []string{teamNameIndexPrefix, aliasIndexPrefix, shortIndexPrefix} {
+		err := deleteIndexed(db, batch, prefix, func(key string, id string) bool {
+			return !current[key]
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = deleteIndexed(db, batch, playerIndexPrefix, func(key string, id string) bool {
+		return orphanIds[football.PlayerId(id)]
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := db.Write(batch, nil); err != nil {
+		return nil, nil, errors.Wrap(err, "deleting orphaned records")
+	}
+
+	return pruned, orphans, nil
+}
+
+// openMaintained opens the generation at the path for maintenance, failing if
+// it doesn't exist, or is in use by another process.
+func openMaintained(path string, readOnly bool) (*leveldb.DB, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: readOnly, ErrorIfMissing: true})
+	if err != nil {
+		return nil, storage.Unavailable("", nil, errors.Wrapf(err, "opening database %s", path))
+	}
+
+	return db, nil
+}
+
+// deleteIndexed adds the deletion of the index entries with the prefix, whose
+// key and id match, to the batch.
+func deleteIndexed(db *database, batch *leveldb.Batch, prefix string, match func(key string, id string) bool) error {
+	iter := db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := string(iter.Key())
+		if sep := strings.LastIndexByte(key, 0); sep != -1 && match(key, key[sep+1:]) {
+			batch.Delete([]byte(key))
+		}
+	}
+
+	return errors.Wrapf(iter.Error(), "iterating over %s keys", prefix)
+}
+
+// getPlayers decodes all stored players, in key order.
+func getPlayers(db *database) ([]football.Player, error) {
+	players := []football.Player{}
+
+	iter := db.NewIterator(util.BytesPrefix([]byte(playerPrefix)), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		p := football.Player{}
+
+		if err := db.decode(iter.Key(), iter.Value(), &p); err != nil {
+			return nil, storage.Corrupt(storage.EntityPlayer, string(iter.Key()[len(playerPrefix):]), err)
+		}
+
+		players = append(players, p)
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, errors.Wrap(err, "iterating over players")
+	}
+
+	return players, nil
+}
+
+// diskSize sums the sizes of the files under the path.
+func diskSize(path string) (int64, error) {
+	var size int64
+
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size, errors.Wrapf(err, "getting size of %s", path)
+}
@@ -0,0 +1,306 @@
+// Package graph queries the teammate graph of a team repository, in which
+// players are linked through the teams they have shared.
+package graph
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+// pageSize is the number of teams or players listed from the repository at
+// once.
+const pageSize = 500
+
+// Graph answers teammate queries using the teams and players of the
+// repository. It holds no data of its own, so it always reflects the current
+// contents of the repository.
+type Graph struct {
+	repo football.TeamRepository
+}
+
+// Teammate is a player who has shared at least one team with another.
+type Teammate struct {
+	Player football.Player
+	// Teams are the shared teams, ordered by id.
+	Teams []football.Team
+}
+
+// Link is a step of a connection between two players.
+type Link struct {
+	// Team is the team shared with the player of the previous link. It is
+	// the zero value for the first link.
+	Team   football.Team
+	Player football.Player
+}
+
+// Connection is a club linked to other teams through the players they share.
+type Connection struct {
+	Team football.Team
+	// Teams is the number of other teams, clubs and national ones alike,
+	// sharing a player with the club.
+	Teams int
+	// Players is the number of players of the club who have also played for
+	// another team.
+	Players int
+}
+
+type parent struct {
+	team   football.TeamId
+	player football.PlayerId
+}
+
+type byConnections []Connection
+
+// New creates a graph over the repository.
+func New(repo football.TeamRepository) Graph {
+	return Graph{repo: repo}
+}
+
+// Teammates returns the players who have shared a team with the player,
+// ordered by id.
+func (g Graph) Teammates(id football.PlayerId) ([]Teammate, error) {
+	player, err := g.repo.GetPlayer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	teams, _, err := storage.GetTeams(g.repo, player.Teams)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting teams of player %s", id)
+	}
+
+	shared := map[football.PlayerId][]football.Team{}
+	ids := []football.PlayerId{}
+
+	for _, t := range teams {
+		for _, pid := range t.Players {
+			if pid == id {
+				continue
+			}
+
+			if _, ok := shared[pid]; !ok {
+				ids = append(ids, pid)
+			}
+			shared[pid] = append(shared[pid], t)
+		}
+	}
+
+	players, _, err := storage.GetPlayers(g.repo, ids)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting teammates of player %s", id)
+	}
+
+	sort.Sort(football.PlayersById(players))
+
+	teammates := make([]Teammate, len(players))
+	for i, p := range players {
+		teammates[i] = Teammate{Player: p, Teams: shared[p.Id]}
+	}
+
+	return teammates, nil
+}
+
+// Path returns the shortest connection from one player to another, starting
+// with the first player and ending with the second one. Its number of links,
+// other than the first one, is the degree of separation between the players.
+// A NotFound error is returned if the players aren't connected.
+func (g Graph) Path(from, to football.PlayerId) ([]Link, error) {
+	start, err := g.repo.GetPlayer(from)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := g.repo.GetPlayer(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if from == to {
+		return []Link{{Player: start}}, nil
+	}
+
+	players := map[football.PlayerId]football.Player{from: start, to: target}
+	teams := map[football.TeamId]football.Team{}
+	parents := map[football.PlayerId]parent{from: {}}
+
+	// The graph is searched breadth first, visiting each team only once.
+	for frontier := []football.Player{start}; len(frontier) > 0; {
+		ids := []football.TeamId{}
+		reachedBy := map[football.TeamId]football.PlayerId{}
+
+		for _, p := range frontier {
+			players[p.Id] = p
+
+			for _, tid := range p.Teams {
+				if _, ok := teams[tid]; ok {
+					continue
+				}
+
+				if _, ok := reachedBy[tid]; !ok {
+					reachedBy[tid] = p.Id
+					ids = append(ids, tid)
+				}
+			}
+		}
+
+		found, _, err := storage.GetTeams(g.repo, ids)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting teams")
+		}
+
+		next := []football.PlayerId{}
+		for _, t := range found {
+			teams[t.Id] = t
+
+			for _, pid := range t.Players {
+				if _, ok := parents[pid]; ok {
+					continue
+				}
+
+				parents[pid] = parent{team: t.Id, player: reachedBy[t.Id]}
+				if pid == to {
+					return path(to, parents, players, teams), nil
+				}
+
+				next = append(next, pid)
+			}
+		}
+
+		if frontier, _, err = storage.GetPlayers(g.repo, next); err != nil {
+			return nil, errors.Wrap(err, "getting players")
+		}
+	}
+
+	return nil, storage.NotFound(storage.EntityPlayer, string(to), errors.Errorf("no connection to player %s", from))
+}
+
+// MostConnected returns at most limit clubs with the most teams sharing their
+// players, most connected first. Clubs equally connected are ordered by their
+// number of shared players, and then by id. Clubs without any shared players
+// are left out.
+func (g Graph) MostConnected(limit int) ([]Connection, error) {
+	linked := map[football.TeamId]map[football.TeamId]bool{}
+	shared := map[football.TeamId]int{}
+
+	err := g.listPlayers(func(p football.Player) {
+		if len(p.Teams) < 2 {
+			return
+		}
+
+		for _, tid := range p.Teams {
+			if linked[tid] == nil {
+				linked[tid] = map[football.TeamId]bool{}
+			}
+
+			for _, other := range p.Teams {
+				if other != tid {
+					linked[tid][other] = true
+				}
+			}
+
+			shared[tid]++
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conns := []Connection{}
+	err = g.listTeams(func(t football.Team) {
+		if !t.IsNational && len(linked[t.Id]) > 0 {
+			conns = append(conns, Connection{Team: t, Teams: len(linked[t.Id]), Players: shared[t.Id]})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(byConnections(conns))
+
+	if limit > 0 && len(conns) > limit {
+		conns = conns[:limit]
+	}
+
+	return conns, nil
+}
+
+func (g Graph) listPlayers(visit func(p football.Player)) error {
+	for cursor := ""; ; {
+		page, next, err := storage.ListPlayers(g.repo, cursor, pageSize)
+		if err != nil {
+			return errors.Wrap(err, "listing players")
+		}
+
+		for _, p := range page {
+			visit(p)
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (g Graph) listTeams(visit func(t football.Team)) error {
+	for cursor := ""; ; {
+		page, next, err := storage.ListTeams(g.repo, cursor, pageSize)
+		if err != nil {
+			return errors.Wrap(err, "listing teams")
+		}
+
+		for _, t := range page {
+			visit(t)
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// path follows the parents from the player back to the first one.
+func path(id football.PlayerId, parents map[football.PlayerId]parent, players map[football.PlayerId]football.Player, teams map[football.TeamId]football.Team) []Link {
+	links := []Link{}
+
+	for {
+		p := parents[id]
+		if p.player == "" {
+			links = append(links, Link{Player: players[id]})
+			break
+		}
+
+		links = append(links, Link{Team: teams[p.team], Player: players[id]})
+		id = p.player
+	}
+
+	for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+		links[i], links[j] = links[j], links[i]
+	}
+
+	return links
+}
+
+func (c byConnections) Len() int {
+	return len(c)
+}
+
+func (c byConnections) Less(i int, j int) bool {
+	if c[i].Teams != c[j].Teams {
+		return c[i].Teams > c[j].Teams
+	}
+
+	if c[i].Players != c[j].Players {
+		return c[i].Players > c[j].Players
+	}
+
+	return c[i].Team.Id < c[j].Team.Id
+}
+
+func (c byConnections) Swap(i int, j int) {
+	c[i], c[j] = c[j], c[i]
+}
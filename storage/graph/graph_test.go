@@ -0,0 +1,126 @@
+// +build go1.7
+
+package graph_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/graph"
+	"github.com/urandom/team-search-test/storage/memory"
+)
+
+func TestGraph(t *testing.T) {
+	team := func(id int, name string, national bool, players ...string) download.Team {
+		entries := make([]string, len(players))
+		for i, p := range players {
+			entries[i] = fmt.Sprintf(`{"id": %q, "name": "Player %s", "age": "30"}`, p, p)
+		}
+
+		return download.Team{Bytes: []byte(fmt.Sprintf(
+			`{"data": {"team": {"id": %d, "name": %q, "isNational": %t, "players": [%s]}}}`,
+			id, name, national, strings.Join(entries, ", "),
+		)), Id: id}
+	}
+
+	teams := []download.Team{
+		team(1, "Apoel FC", false, "1", "2", "3"),
+		team(2, "Levski Sofia", false, "3", "4"),
+		team(3, "Bulgaria", true, "4", "5", "2"),
+		team(4, "Crotone", false, "5", "6"),
+		team(5, "D2", false, "7", "8"),
+	}
+
+	data := make(chan download.Team, len(teams))
+	for _, d := range teams {
+		data <- d
+	}
+	close(data)
+
+	repo := memory.NewTeamRepository(data)
+	defer repo.Close()
+
+	g := graph.New(repo)
+
+	teammates, err := g.Teammates("2")
+	if err != nil {
+		t.Fatalf("error getting teammates: %+v", err)
+	}
+
+	ids := []football.PlayerId{}
+	for _, tm := range teammates {
+		ids = append(ids, tm.Player.Id)
+	}
+
+	if fmt.Sprint(ids) != "[1 3 4 5]" {
+		t.Fatalf("expected the teammates of player 2 to be 1, 3, 4 and 5, got %v", ids)
+	}
+
+	if len(teammates[0].Teams) != 1 || teammates[0].Teams[0].Id != 1 {
+		t.Fatalf("expected player 1 to share Apoel FC with player 2, got %+v", teammates[0].Teams)
+	}
+
+	// Player 4 meets player 5 and 2 in Bulgaria after player 3 in Levski
+	// Sofia, but the teammates are ordered by id.
+	if teammates, err = g.Teammates("4"); err != nil {
+		t.Fatalf("error getting teammates: %+v", err)
+	}
+
+	ids = ids[:0]
+	for _, tm := range teammates {
+		ids = append(ids, tm.Player.Id)
+	}
+
+	if fmt.Sprint(ids) != "[2 3 5]" {
+		t.Fatalf("expected the teammates of player 4 to be 2, 3 and 5, got %v", ids)
+	}
+
+	links, err := g.Path("1", "6")
+	if err != nil {
+		t.Fatalf("error getting path: %+v", err)
+	}
+
+	// Player 1 reaches Bulgaria through player 2, skipping Levski Sofia.
+	steps := []string{}
+	for _, l := range links {
+		steps = append(steps, fmt.Sprintf("%d:%s", l.Team.Id, l.Player.Id))
+	}
+
+	if got := strings.Join(steps, " "); got != "0:1 1:2 3:5 4:6" {
+		t.Fatalf("expected the shortest path from player 1 to 6, got %s", got)
+	}
+
+	if links, err := g.Path("3", "3"); err != nil || len(links) != 1 {
+		t.Fatalf("expected a player to be connected to itself, got %+v, %+v", links, err)
+	}
+
+	if _, err := g.Path("1", "7"); !storage.IsNotFound(err) {
+		t.Fatalf("expected players 1 and 7 not to be connected, got %+v", err)
+	}
+
+	if _, err := g.Path("1", "missing"); !storage.IsNotFound(err) {
+		t.Fatalf("expected a missing player not to be found, got %+v", err)
+	}
+
+	conns, err := g.MostConnected(0)
+	if err != nil {
+		t.Fatalf("error getting most connected clubs: %+v", err)
+	}
+
+	got := []string{}
+	for _, c := range conns {
+		got = append(got, fmt.Sprintf("%d:%d:%d", c.Team.Id, c.Teams, c.Players))
+	}
+
+	if strings.Join(got, " ") != "1:2:2 2:2:2 4:1:1" {
+		t.Fatalf("expected the clubs ordered by their connections, got %v", got)
+	}
+
+	if conns, err := g.MostConnected(1); err != nil || len(conns) != 1 || conns[0].Team.Id != 1 {
+		t.Fatalf("expected only the most connected club, got %+v, %+v", conns, err)
+	}
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/urandom/team-search-test/football"
+)
+
+// TeamVersion is a team as it was stored during a time interval.
+type TeamVersion struct {
+	Team football.Team
+	// Players are the players of the team as they were stored along with
+	// the version, without their teams. They are nil for versions stored
+	// before the players were kept.
+	Players []football.Player
+	// From is when the version was stored. To is when it was replaced by a
+	// newer version, or the team was removed, and is zero for the current
+	// version.
+	From time.Time
+	To   time.Time
+}
+
+// Historian is a team repository that keeps the previous versions of its
+// teams across refreshes.
+type Historian interface {
+	football.TeamRepository
+
+	// GetTeamAt looks for the version of a team, including its players, at
+	// the given time.
+	GetTeamAt(id football.TeamId, at time.Time) (TeamVersion, error)
+	// GetTeamHistory returns all versions of a team, oldest first.
+	GetTeamHistory(id football.TeamId) ([]TeamVersion, error)
+	// GetTeamIdsByPastName looks for the teams with a version matching the
+	// name, including renamed and removed teams, ordered by id.
+	GetTeamIdsByPastName(name string) ([]football.TeamId, error)
+}
+
+// ContextHistorian is a Historian whose queries give up once the given
+// context is done.
+type ContextHistorian interface {
+	GetTeamAtContext(ctx context.Context, id football.TeamId, at time.Time) (TeamVersion, error)
+	GetTeamHistoryContext(ctx context.Context, id football.TeamId) ([]TeamVersion, error)
+	GetTeamIdsByPastNameContext(ctx context.Context, name string) ([]football.TeamId, error)
+}
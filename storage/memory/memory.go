@@ -1,22 +1,107 @@
 package memory
 
 import (
-	"encoding/json"
+	"context"
+	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/urandom/team-search-test/download"
 	"github.com/urandom/team-search-test/football"
 	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
+	"github.com/urandom/team-search-test/storage/search"
+	"github.com/urandom/team-search-test/tracing"
 )
 
 type memory struct {
+	// data holds the *snapshot being served. It is replaced as a whole by
+	// each refresh.
+	data atomic.Value
+
+	opts options
+	init chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	mu     sync.Mutex
+	status storage.Status
+
+	publisher *storage.Publisher
+}
+
+// snapshot is a complete, immutable copy of the repository data.
+type snapshot struct {
 	teams         map[football.TeamId]football.Team
 	players       map[football.PlayerId]football.Player
-	teamNameIndex map[string]football.TeamId
+	teamNameIndex map[string][]football.TeamId
+	aliasIndex    map[string][]football.TeamId
+	shortIndex    map[string][]football.TeamId
+	// playerNameIndex is keyed by normalized player names.
+	playerNameIndex map[string][]football.PlayerId
+	// teamIds and playerIds are the sorted keys used for listing.
+	teamIds   []int
+	playerIds []string
+
+	err error
+}
+
+type options struct {
+	tracer   *tracing.Tracer
+	aliases  *alias.Set
+	interval time.Duration
+	signal   <-chan struct{}
+	replay   func(data chan<- download.Team) error
+}
+
+// Option represents the options for the in-memory storage
+type Option struct {
+	f func(o *options)
+}
+
+// Tracer sets the tracer used to create a span for each ingested team
+func Tracer(tracer *tracing.Tracer) Option {
+	return Option{func(o *options) {
+		o.tracer = tracer
+	}}
+}
 
-	init      chan struct{}
-	initError error
+// Aliases sets the user maintained team name aliases
+func Aliases(aliases *alias.Set) Option {
+	return Option{func(o *options) {
+		o.aliases = aliases
+	}}
+}
+
+// Refresh periodically rebuilds the data from a new download channel returned
+// by the source, once the repository is initialized. A rebuilt copy replaces
+// the served data at once, only if it is complete, so that queries neither
+// block nor see partial data. A failed rebuild leaves the data as it was,
+// while a successful one also replaces a failed initialization.
+func Refresh(interval time.Duration, source func() <-chan download.Team) Option {
+	return Option{func(o *options) {
+		o.interval = interval
+		o.replay = func(data chan<- download.Team) error {
+			for t := range source() {
+				data <- t
+			}
+
+			return nil
+		}
+	}}
+}
+
+// Reload rebuilds the data like Refresh, whenever a value is received from the
+// signal channel, from the teams sent by the replay function. A rebuilt copy
+// is also discarded if the replay function returns an error.
+func Reload(signal <-chan struct{}, replay func(data chan<- download.Team) error) Option {
+	return Option{func(o *options) {
+		o.signal = signal
+		o.replay = replay
+	}}
 }
 
 // NewTeamRepository creates an in-memory team repository from the download
@@ -24,117 +109,650 @@ type memory struct {
 // blocking any queries until done. If an error occurs during initialization,
 // all repository methods will return an initializer error.
 //
-// If a query cannot find a valid entry given the input, a not-found error will
-// be returned.
+// Teams can be looked up by their exact name, a user maintained alias, or a
+// generated variant of their name, as described in the alias package. If a
+// query cannot find a valid entry given the input, a not-found error will be
+// returned. GetTeamByName returns an ambiguous error if more than one team
+// matches the name. Search ranks all teams as described in the search
+// package, and SearchPlayers does the same for the players, using an index of
+// their normalized names. ListTeams and ListPlayers order the entries by id,
+// using the last id of a page as the cursor of the next one.
 //
-// The in-memory storage doesn't require to be closed.
-func NewTeamRepository(data <-chan download.Team) football.TeamRepository {
+// The in-memory storage only requires to be closed in order to stop the
+// refreshes enabled by the Refresh and Reload options, and the subscriptions
+// to them. The returned repository implements all optional football
+// interfaces, along with storage.Replayer, storage.Monitor and
+// storage.Watcher, whose context methods return a not-ready error if the
+// context is done before the repository is initialized. Subscribers receive the changes of each refresh
+// once it is swapped in, and a slow subscriber delays the following refresh.
+func NewTeamRepository(data <-chan download.Team, opts ...Option) football.TeamRepository {
+	o := options{}
+	o.apply(opts)
+
 	m := &memory{
-		teams:         make(map[football.TeamId]football.Team),
-		players:       make(map[football.PlayerId]football.Player),
-		teamNameIndex: make(map[string]football.TeamId),
-		init:          make(chan struct{}),
+		opts:   o,
+		init:   make(chan struct{}),
+		done:   make(chan struct{}),
+		status: storage.Status{StartedAt: time.Now(), Source: storage.SourceDownload},
 	}
+	m.publisher = storage.NewPublisher(m.done)
 
 	go m.initialize(data)
 
+	if o.replay != nil && (o.interval > 0 || o.signal != nil) {
+		go m.refresh()
+	}
+
 	return m
 }
 
 func (m *memory) GetTeam(id football.TeamId) (football.Team, error) {
-	<-m.init
+	return m.GetTeamContext(context.Background(), id)
+}
+
+func (m *memory) GetTeamContext(ctx context.Context, id football.TeamId) (football.Team, error) {
+	if err := m.wait(ctx); err != nil {
+		return football.Team{}, storage.NotReady(storage.EntityTeam, id, err)
+	}
 
-	if m.initError != nil {
-		return football.Team{}, initError{errors.Wrapf(m.initError, "getting team %d", id)}
+	d := m.snapshot()
+	if d.err != nil {
+		return football.Team{}, storage.Unavailable(storage.EntityTeam, id, d.err)
 	}
 
-	return m.getTeam(id)
+	return d.getTeam(id)
 }
 
 func (m *memory) GetTeamByName(name string) (football.Team, error) {
-	<-m.init
+	return m.GetTeamByNameContext(context.Background(), name)
+}
 
-	if m.initError != nil {
-		return football.Team{}, initError{errors.Wrapf(m.initError, "getting team %s", name)}
+func (m *memory) GetTeamByNameContext(ctx context.Context, name string) (football.Team, error) {
+	if err := m.wait(ctx); err != nil {
+		return football.Team{}, storage.NotReady(storage.EntityTeam, name, err)
 	}
 
-	if id, ok := m.teamNameIndex[name]; ok {
-		return m.getTeam(id)
-	} else {
-		return football.Team{}, notFoundError{errors.Errorf("no team for %s", name)}
+	d := m.snapshot()
+	if d.err != nil {
+		return football.Team{}, storage.Unavailable(storage.EntityTeam, name, d.err)
+	}
+
+	ids := m.lookup(d, name)
+	switch len(ids) {
+	case 0:
+		return football.Team{}, storage.NotFound(storage.EntityTeam, name, nil)
+	case 1:
+		return d.getTeam(ids[0])
+	default:
+		return football.Team{}, storage.Ambiguous(storage.EntityTeam, name, errors.Errorf("%d teams match", len(ids)))
+	}
+}
+
+func (m *memory) GetTeamsByName(name string) ([]football.Team, error) {
+	return m.GetTeamsByNameContext(context.Background(), name)
+}
+
+func (m *memory) GetTeamsByNameContext(ctx context.Context, name string) ([]football.Team, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, name, err)
+	}
+
+	d := m.snapshot()
+	if d.err != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, name, d.err)
 	}
+
+	ids := m.lookup(d, name)
+	if len(ids) == 0 {
+		return nil, storage.NotFound(storage.EntityTeam, name, nil)
+	}
+
+	teams := make([]football.Team, 0, len(ids))
+	for _, id := range ids {
+		t, err := d.getTeam(id)
+		if err != nil {
+			return nil, err
+		}
+
+		teams = append(teams, t)
+	}
+
+	return teams, nil
+}
+
+func (m *memory) Search(query string, limit int) ([]football.Team, error) {
+	return m.SearchContext(context.Background(), query, limit)
+}
+
+func (m *memory) SearchContext(ctx context.Context, query string, limit int) ([]football.Team, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, nil, errors.Wrapf(err, "searching teams %s", query))
+	}
+
+	d := m.snapshot()
+	if d.err != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, nil, errors.Wrapf(d.err, "searching teams %s", query))
+	}
+
+	teams := make([]football.Team, 0, len(d.teams))
+	for _, t := range d.teams {
+		teams = append(teams, t)
+	}
+
+	return search.Rank(query, teams, limit), nil
 }
 
 func (m *memory) GetPlayer(id football.PlayerId) (football.Player, error) {
-	<-m.init
+	return m.GetPlayerContext(context.Background(), id)
+}
 
-	if m.initError != nil {
-		return football.Player{}, initError{errors.Wrapf(m.initError, "getting player %d", id)}
+func (m *memory) GetPlayerContext(ctx context.Context, id football.PlayerId) (football.Player, error) {
+	if err := m.wait(ctx); err != nil {
+		return football.Player{}, storage.NotReady(storage.EntityPlayer, id, err)
 	}
 
-	if p, ok := m.players[id]; ok {
+	d := m.snapshot()
+	if d.err != nil {
+		return football.Player{}, storage.Unavailable(storage.EntityPlayer, id, d.err)
+	}
+
+	if p, ok := d.players[id]; ok {
 		return p, nil
 	} else {
-		return football.Player{}, notFoundError{errors.Errorf("no player for %d", id)}
+		return football.Player{}, storage.NotFound(storage.EntityPlayer, id, nil)
+	}
+}
+
+func (m *memory) GetTeams(ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	return m.GetTeamsContext(context.Background(), ids)
+}
+
+func (m *memory) GetTeamsContext(ctx context.Context, ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, nil, storage.NotReady(storage.EntityTeam, nil, errors.Wrapf(err, "getting %d teams", len(ids)))
+	}
+
+	d := m.snapshot()
+	if d.err != nil {
+		return nil, nil, storage.Unavailable(storage.EntityTeam, nil, errors.Wrapf(d.err, "getting %d teams", len(ids)))
+	}
+
+	teams := make([]football.Team, 0, len(ids))
+	missing := []football.TeamId{}
+
+	for _, id := range ids {
+		if t, ok := d.teams[id]; ok {
+			teams = append(teams, t)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	return teams, missing, nil
+}
+
+func (m *memory) GetPlayers(ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	return m.GetPlayersContext(context.Background(), ids)
+}
+
+func (m *memory) GetPlayersContext(ctx context.Context, ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrapf(err, "getting %d players", len(ids)))
+	}
+
+	d := m.snapshot()
+	if d.err != nil {
+		return nil, nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrapf(d.err, "getting %d players", len(ids)))
+	}
+
+	players := make([]football.Player, 0, len(ids))
+	missing := []football.PlayerId{}
+
+	for _, id := range ids {
+		if p, ok := d.players[id]; ok {
+			players = append(players, p)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	return players, missing, nil
+}
+
+func (m *memory) SearchPlayers(query string, limit int) ([]football.Player, error) {
+	return m.SearchPlayersContext(context.Background(), query, limit)
+}
+
+func (m *memory) SearchPlayersContext(ctx context.Context, query string, limit int) ([]football.Player, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrapf(err, "searching players %s", query))
+	}
+
+	d := m.snapshot()
+	if d.err != nil {
+		return nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrapf(d.err, "searching players %s", query))
+	}
+
+	matcher := search.NewMatcher(query)
+
+	players := []football.Player{}
+	for name, ids := range d.playerNameIndex {
+		if matcher.ScoreNormalized(name) == 0 {
+			continue
+		}
+
+		for _, id := range ids {
+			players = append(players, d.players[id])
+		}
+	}
+
+	return search.RankPlayers(query, players, limit), nil
+}
+
+func (m *memory) QueryPlayers(query football.PlayerQuery) ([]football.Player, error) {
+	return m.QueryPlayersContext(context.Background(), query)
+}
+
+func (m *memory) QueryPlayersContext(ctx context.Context, query football.PlayerQuery) ([]football.Player, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrap(err, "querying players"))
+	}
+
+	d := m.snapshot()
+	if d.err != nil {
+		return nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrap(d.err, "querying players"))
+	}
+
+	isNational := func(id football.TeamId) bool {
+		return d.teams[id].IsNational
+	}
+
+	players := football.PlayersById{}
+	for _, p := range d.players {
+		if query.Match(p, isNational) {
+			players = append(players, p)
+		}
+	}
+
+	sort.Sort(players)
+
+	return players, nil
+}
+
+func (m *memory) ListTeams(cursor string, limit int) ([]football.Team, string, error) {
+	return m.ListTeamsContext(context.Background(), cursor, limit)
+}
+
+func (m *memory) ListTeamsContext(ctx context.Context, cursor string, limit int) ([]football.Team, string, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, "", storage.NotReady(storage.EntityTeam, nil, errors.Wrap(err, "listing teams"))
+	}
+
+	d := m.snapshot()
+	if d.err != nil {
+		return nil, "", storage.Unavailable(storage.EntityTeam, nil, errors.Wrap(d.err, "listing teams"))
+	}
+
+	start := 0
+	if cursor != "" {
+		after, err := strconv.Atoi(cursor)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "parsing cursor %q", cursor)
+		}
+
+		start = sort.SearchInts(d.teamIds, after+1)
+	}
+
+	end := page(start, limit, len(d.teamIds))
+
+	teams := make([]football.Team, 0, end-start)
+	for _, id := range d.teamIds[start:end] {
+		teams = append(teams, d.teams[football.TeamId(id)])
+	}
+
+	next := ""
+	if end < len(d.teamIds) {
+		next = strconv.Itoa(d.teamIds[end-1])
+	}
+
+	return teams, next, nil
+}
+
+func (m *memory) ListPlayers(cursor string, limit int) ([]football.Player, string, error) {
+	return m.ListPlayersContext(context.Background(), cursor, limit)
+}
+
+func (m *memory) ListPlayersContext(ctx context.Context, cursor string, limit int) ([]football.Player, string, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, "", storage.NotReady(storage.EntityPlayer, nil, errors.Wrap(err, "listing players"))
+	}
+
+	d := m.snapshot()
+	if d.err != nil {
+		return nil, "", storage.Unavailable(storage.EntityPlayer, nil, errors.Wrap(d.err, "listing players"))
+	}
+
+	start := 0
+	if cursor != "" {
+		start = sort.Search(len(d.playerIds), func(i int) bool { return d.playerIds[i] > cursor })
+	}
+
+	end := page(start, limit, len(d.playerIds))
+
+	players := make([]football.Player, 0, end-start)
+	for _, id := range d.playerIds[start:end] {
+		players = append(players, d.players[football.PlayerId(id)])
+	}
+
+	next := ""
+	if end < len(d.playerIds) {
+		next = d.playerIds[end-1]
+	}
+
+	return players, next, nil
+}
+
+func (m *memory) Replay(data chan<- download.Team) error {
+	<-m.init
+
+	d := m.snapshot()
+	if d.err != nil {
+		return storage.Unavailable(storage.EntityTeam, nil, errors.Wrap(d.err, "replaying teams"))
+	}
+
+	for _, id := range d.teamIds {
+		t := d.teams[football.TeamId(id)]
+
+		players := make([]football.Player, 0, len(t.Players))
+		for _, pid := range t.Players {
+			players = append(players, d.players[pid])
+		}
+
+		encoded, err := storage.EncodeTeam(t, players)
+		if err != nil {
+			return err
+		}
+
+		data <- encoded
+	}
+
+	return nil
+}
+
+func (m *memory) Ready() <-chan struct{} {
+	return m.init
+}
+
+func (m *memory) Err() error {
+	select {
+	case <-m.init:
+		if d := m.snapshot(); d.err != nil {
+			return storage.Unavailable("", nil, d.err)
+		}
+	default:
 	}
+
+	return nil
+}
+
+func (m *memory) Status() storage.Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.status
 }
 
 func (m *memory) Close() error {
+	m.once.Do(func() {
+		close(m.done)
+	})
+
 	return nil
 }
 
+// wait blocks until the repository is initialized, or the context is done.
+func (m *memory) wait(ctx context.Context) error {
+	select {
+	case <-m.init:
+		return nil
+	default:
+	}
+
+	select {
+	case <-m.init:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// snapshot returns the data currently served. It may only be called once the
+// repository is initialized.
+func (m *memory) snapshot() *snapshot {
+	return m.data.Load().(*snapshot)
+}
+
 func (m *memory) initialize(data <-chan download.Team) {
 	defer close(m.init)
 
-	for d := range data {
-		var j storage.JsonData
+	d := m.build(data, true)
+	m.data.Store(d)
 
-		err := json.Unmarshal(d.Bytes, &j)
-		if err != nil {
-			m.initError = errors.Wrapf(err, "parsing team data for %d", d.Id)
+	if d.err == nil {
+		m.mu.Lock()
+		m.status.RefreshedAt = time.Now()
+		m.mu.Unlock()
+	}
+}
+
+// refresh rebuilds the data from the replay, periodically or when signaled,
+// swapping in each successful rebuild, until the repository is closed.
+func (m *memory) refresh() {
+	<-m.init
+
+	var tick <-chan time.Time
+	if m.opts.interval > 0 {
+		ticker := time.NewTicker(m.opts.interval)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-tick:
+		case <-m.opts.signal:
+		}
+
+		data := make(chan download.Team)
+		replayed := make(chan error, 1)
+
+		go func() {
+			replayed <- m.opts.replay(data)
+			close(data)
+		}()
+
+		d := m.build(data, false)
+
+		// Let the replay finish instead of blocking it, or the download
+		// workers it reads from.
+		for range data {
+		}
+
+		if d.err != nil || <-replayed != nil {
+			continue
+		}
+
+		select {
+		case <-m.done:
 			return
+		default:
 		}
 
-		td := j.Data.Team
+		previous := m.snapshot()
+		m.data.Store(d)
 
-		playerIds := []football.PlayerId{}
+		m.mu.Lock()
+		m.status.Teams = len(d.teams)
+		m.status.Players = len(d.players)
+		m.status.RefreshedAt = time.Now()
+		m.mu.Unlock()
 
-		for _, p := range td.Players {
-			playerIds = append(playerIds, p.Id)
+		m.publish(previous, d)
+	}
+}
 
-			if player, ok := m.players[p.Id]; ok {
-				player.Teams = append(player.Teams, td.Id)
-				m.players[p.Id] = player
+func (m *memory) Subscribe(ctx context.Context) <-chan football.Change {
+	return m.publisher.Subscribe(ctx)
+}
+
+// publish sends the changes between the previous and current snapshots to
+// the subscribers.
+func (m *memory) publish(previous, current *snapshot) {
+	if !m.publisher.Subscribed() {
+		return
+	}
+
+	// A failed initialization leaves partial data, which is never served.
+	if previous.err != nil {
+		previous = &snapshot{}
+	}
+
+	m.publisher.Publish(football.Changes(previous.teams, current.teams, previous.players, current.players))
+}
+
+// build creates a snapshot from the download data. If progress is true, the
+// status is updated as the teams are ingested. The snapshot error is set if
+// any team cannot be parsed.
+func (m *memory) build(data <-chan download.Team, progress bool) *snapshot {
+	d := &snapshot{
+		teams:           make(map[football.TeamId]football.Team),
+		players:         make(map[football.PlayerId]football.Player),
+		teamNameIndex:   make(map[string][]football.TeamId),
+		aliasIndex:      make(map[string][]football.TeamId),
+		shortIndex:      make(map[string][]football.TeamId),
+		playerNameIndex: make(map[string][]football.PlayerId),
+	}
+
+	for t := range data {
+		span := m.opts.tracer.Start("memory.ingest").Set("team.id", t.Id)
+		parse := span.Child("parse")
+
+		team, players, err := storage.ParseTeam(t)
+		parse.Fail(err).End()
+		if err != nil {
+			span.Fail(err).End()
+			d.err = err
+			return d
+		}
+
+		for _, p := range players {
+			if player, ok := d.players[p.Id]; ok {
+				player.Teams = append(player.Teams, team.Id)
+				d.players[p.Id] = player
 			} else {
-				var age int
-				switch v := p.Age.(type) {
-				case int:
-					age = v
-				case string:
-					// Ignore the error, we can't do anything if the string
-					// isn't numerical
-					age, _ = strconv.Atoi(v)
-				}
-				m.players[p.Id] = football.Player{
-					Id: p.Id, Name: p.Name,
-					Age: age, Teams: []football.TeamId{td.Id},
-				}
+				d.players[p.Id] = p
+
+				name := alias.Normalize(p.Name)
+				d.playerNameIndex[name] = append(d.playerNameIndex[name], p.Id)
 			}
 		}
 
-		m.teams[td.Id] = football.Team{
-			Id: td.Id, Name: td.Name,
-			IsNational: td.IsNational, Players: playerIds,
+		d.teams[team.Id] = team
+
+		d.teamNameIndex[team.Name] = addId(d.teamNameIndex[team.Name], team.Id)
+		for _, v := range alias.Variants(team.Name) {
+			d.aliasIndex[v] = addId(d.aliasIndex[v], team.Id)
+		}
+
+		if short := alias.Short(team.Name); short != "" {
+			d.shortIndex[short] = addId(d.shortIndex[short], team.Id)
+		}
+
+		if progress {
+			m.mu.Lock()
+			m.status.Teams = len(d.teams)
+			m.status.Players = len(d.players)
+			m.mu.Unlock()
 		}
 
-		m.teamNameIndex[td.Name] = td.Id
+		span.Set("team.players", len(players)).End()
+	}
+
+	d.teamIds = make([]int, 0, len(d.teams))
+	for id := range d.teams {
+		d.teamIds = append(d.teamIds, int(id))
+	}
+	sort.Ints(d.teamIds)
+
+	d.playerIds = make([]string, 0, len(d.players))
+	for id := range d.players {
+		d.playerIds = append(d.playerIds, string(id))
 	}
+	sort.Strings(d.playerIds)
+
+	return d
 }
 
-func (m *memory) getTeam(id football.TeamId) (football.Team, error) {
-	if t, ok := m.teams[id]; ok {
+// lookup returns the ids of the teams matching the name, trying the exact
+// name, the user maintained aliases, the name variants and the short names, in
+// that order.
+func (m *memory) lookup(d *snapshot, name string) []football.TeamId {
+	if ids, ok := d.teamNameIndex[name]; ok {
+		return ids
+	}
+
+	if canonical, ok := m.opts.aliases.Canonical(name); ok {
+		if ids, ok := d.teamNameIndex[canonical]; ok {
+			return ids
+		}
+	}
+
+	for _, v := range alias.Forms(name) {
+		if ids, ok := d.aliasIndex[v]; ok {
+			return ids
+		}
+	}
+
+	return d.shortIndex[alias.Normalize(name)]
+}
+
+func (d *snapshot) getTeam(id football.TeamId) (football.Team, error) {
+	if t, ok := d.teams[id]; ok {
 		return t, nil
 	} else {
-		return football.Team{}, notFoundError{errors.Errorf("no team for %d", id)}
+		return football.Team{}, storage.NotFound(storage.EntityTeam, id, nil)
+	}
+}
+
+// page returns the end of a page of at most limit entries, starting at start.
+// A limit less than 1 covers all remaining entries.
+func page(start, limit, total int) int {
+	if limit < 1 || start+limit > total {
+		return total
+	}
+
+	return start + limit
+}
+
+// addId adds the id to the sorted index entry, unless already present.
+func addId(ids []football.TeamId, id football.TeamId) []football.TeamId {
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	if i < len(ids) && ids[i] == id {
+		return ids
+	}
+
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+
+	return ids
+}
+
+func (o *options) apply(opts []Option) {
+	for _, op := range opts {
+		op.f(o)
 	}
 }
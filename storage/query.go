@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/football"
+)
+
+// GetTeamsByName looks for all teams matching the name, ordered by id. A
+// repository that isn't a football.Disambiguator only returns the single team
+// matching the name, or an ambiguous error.
+func GetTeamsByName(repo football.TeamRepository, name string) ([]football.Team, error) {
+	if d, ok := repo.(football.Disambiguator); ok {
+		return d.GetTeamsByName(name)
+	}
+
+	team, err := repo.GetTeamByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return []football.Team{team}, nil
+}
+
+// GetTeams looks for the teams with the given ids, as described by
+// football.BatchGetter. The teams of a repository that isn't one are looked
+// up one at a time.
+func GetTeams(repo football.TeamRepository, ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	if b, ok := repo.(football.BatchGetter); ok {
+		return b.GetTeams(ids)
+	}
+
+	teams, missing := []football.Team{}, []football.TeamId{}
+	for _, id := range ids {
+		team, err := repo.GetTeam(id)
+		if IsNotFound(err) {
+			missing = append(missing, id)
+			continue
+		} else if err != nil {
+			return nil, nil, errors.Wrapf(err, "getting %d teams", len(ids))
+		}
+
+		teams = append(teams, team)
+	}
+
+	return teams, missing, nil
+}
+
+// GetPlayers looks for the players with the given ids, as described by
+// football.BatchGetter. The players of a repository that isn't one are looked
+// up one at a time.
+func GetPlayers(repo football.TeamRepository, ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	if b, ok := repo.(football.BatchGetter); ok {
+		return b.GetPlayers(ids)
+	}
+
+	players, missing := []football.Player{}, []football.PlayerId{}
+	for _, id := range ids {
+		player, err := repo.GetPlayer(id)
+		if IsNotFound(err) {
+			missing = append(missing, id)
+			continue
+		} else if err != nil {
+			return nil, nil, errors.Wrapf(err, "getting %d players", len(ids))
+		}
+
+		players = append(players, player)
+	}
+
+	return players, missing, nil
+}
+
+// Search looks for the teams loosely matching the query, or returns an
+// unsupported error if the repository isn't a football.Searcher.
+func Search(repo football.TeamRepository, query string, limit int) ([]football.Team, error) {
+	if s, ok := repo.(football.Searcher); ok {
+		return s.Search(query, limit)
+	}
+
+	return nil, Unsupported(EntityTeam, nil, errors.Errorf("searching teams %s", query))
+}
+
+// SearchPlayers looks for the players loosely matching the query, or returns
+// an unsupported error if the repository isn't a football.Searcher.
+func SearchPlayers(repo football.TeamRepository, query string, limit int) ([]football.Player, error) {
+	if s, ok := repo.(football.Searcher); ok {
+		return s.SearchPlayers(query, limit)
+	}
+
+	return nil, Unsupported(EntityPlayer, nil, errors.Errorf("searching players %s", query))
+}
+
+// QueryPlayers looks for the players matching the query, or returns an
+// unsupported error if the repository isn't a football.Querier.
+func QueryPlayers(repo football.TeamRepository, query football.PlayerQuery) ([]football.Player, error) {
+	if q, ok := repo.(football.Querier); ok {
+		return q.QueryPlayers(query)
+	}
+
+	return nil, Unsupported(EntityPlayer, nil, errors.New("querying players"))
+}
+
+// ListTeams returns a page of teams, or an unsupported error if the
+// repository isn't a football.Lister.
+func ListTeams(repo football.TeamRepository, cursor string, limit int) ([]football.Team, string, error) {
+	if l, ok := repo.(football.Lister); ok {
+		return l.ListTeams(cursor, limit)
+	}
+
+	return nil, "", Unsupported(EntityTeam, nil, errors.New("listing teams"))
+}
+
+// ListPlayers returns a page of players, or an unsupported error if the
+// repository isn't a football.Lister.
+func ListPlayers(repo football.TeamRepository, cursor string, limit int) ([]football.Player, string, error) {
+	if l, ok := repo.(football.Lister); ok {
+		return l.ListPlayers(cursor, limit)
+	}
+
+	return nil, "", Unsupported(EntityPlayer, nil, errors.New("listing players"))
+}
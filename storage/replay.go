@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+)
+
+// Replayer is a team repository that can feed its stored contents back in
+// the download data format, so that they can be used to initialize another
+// repository.
+type Replayer interface {
+	football.TeamRepository
+
+	// Replay waits for the repository to initialize, and then sends all of
+	// its teams through the data channel, ordered by their id. The channel
+	// is not closed.
+	Replay(data chan<- download.Team) error
+}
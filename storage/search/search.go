@@ -0,0 +1,245 @@
+// Package search ranks teams and players against a loosely typed query, for
+// use by the search methods of the repositories.
+//
+// Queries and names are compared in their normalized form, as described in
+// the alias package, so matching disregards case, accents and punctuation. A
+// name matches a query if it, or for teams one of its variants, equals the
+// query, starts with it, has a word starting with it, or is within a small
+// edit distance of it, in order of preference.
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage/alias"
+)
+
+const (
+	exactScore      = 100
+	prefixScore     = 80
+	wordPrefixScore = 60
+	fuzzyScore      = 40
+)
+
+// Matcher scores team names against a query.
+type Matcher struct {
+	query []rune
+}
+
+type result struct {
+	team  football.Team
+	score int
+}
+
+type playerResult struct {
+	player football.Player
+	score  int
+}
+
+// NewMatcher creates a matcher for the query.
+func NewMatcher(query string) Matcher {
+	return Matcher{query: []rune(alias.Normalize(query))}
+}
+
+// Score returns how well the team name matches the query, with 0 denoting no
+// match at all.
+func (m Matcher) Score(name string) int {
+	best := 0
+
+	for _, v := range alias.Variants(name) {
+		if score := m.ScoreNormalized(v); score > best {
+			best = score
+		}
+	}
+
+	return best
+}
+
+// ScoreNormalized returns how well the already normalized name matches the
+// query, with 0 denoting no match at all. It is meant for names coming from
+// an index keyed by normalized names.
+func (m Matcher) ScoreNormalized(name string) int {
+	if len(m.query) == 0 {
+		return 0
+	}
+
+	q := string(m.query)
+
+	switch {
+	case name == q:
+		return exactScore
+	case strings.HasPrefix(name, q):
+		return prefixScore
+	case strings.Contains(" "+name, " "+q):
+		return wordPrefixScore
+	}
+
+	// Allow roughly one typo for every four characters.
+	allowed := len(m.query) / 4
+	if allowed < 1 {
+		allowed = 1
+	}
+
+	if d := distance(m.query, []rune(name)); d <= allowed {
+		return fuzzyScore - d
+	}
+
+	return 0
+}
+
+// Rank returns at most limit teams that match the query, best matches first.
+// Teams with the same score are ordered national teams first, as they are
+// the ones usually referred to by a bare country name, followed by shorter
+// names and then alphabetically. A limit less than 1 returns all matches.
+func Rank(query string, teams []football.Team, limit int) []football.Team {
+	m := NewMatcher(query)
+
+	results := []result{}
+	for _, t := range teams {
+		if score := m.Score(t.Name); score > 0 {
+			results = append(results, result{t, score})
+		}
+	}
+
+	sort.Sort(byRank{results, collate.New(language.English, collate.Loose)})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	ranked := make([]football.Team, len(results))
+	for i, r := range results {
+		ranked[i] = r.team
+	}
+
+	return ranked
+}
+
+// RankPlayers returns at most limit players whose names match the query,
+// best matches first. Players with the same score are ordered alphabetically.
+// A limit less than 1 returns all matches.
+func RankPlayers(query string, players []football.Player, limit int) []football.Player {
+	m := NewMatcher(query)
+
+	results := []playerResult{}
+	for _, p := range players {
+		if score := m.ScoreNormalized(alias.Normalize(p.Name)); score > 0 {
+			results = append(results, playerResult{p, score})
+		}
+	}
+
+	sort.Sort(byPlayerRank{results, collate.New(language.English, collate.Loose)})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	ranked := make([]football.Player, len(results))
+	for i, r := range results {
+		ranked[i] = r.player
+	}
+
+	return ranked
+}
+
+type byRank struct {
+	results  []result
+	collator *collate.Collator
+}
+
+func (r byRank) Len() int {
+	return len(r.results)
+}
+
+func (r byRank) Less(i int, j int) bool {
+	a, b := r.results[i], r.results[j]
+
+	switch {
+	case a.score != b.score:
+		return a.score > b.score
+	case a.team.IsNational != b.team.IsNational:
+		return a.team.IsNational
+	case len(a.team.Name) != len(b.team.Name):
+		return len(a.team.Name) < len(b.team.Name)
+	}
+
+	if c := r.collator.CompareString(a.team.Name, b.team.Name); c != 0 {
+		return c < 0
+	}
+
+	return a.team.Id < b.team.Id
+}
+
+func (r byRank) Swap(i int, j int) {
+	r.results[i], r.results[j] = r.results[j], r.results[i]
+}
+
+type byPlayerRank struct {
+	results  []playerResult
+	collator *collate.Collator
+}
+
+func (r byPlayerRank) Len() int {
+	return len(r.results)
+}
+
+func (r byPlayerRank) Less(i int, j int) bool {
+	a, b := r.results[i], r.results[j]
+
+	if a.score != b.score {
+		return a.score > b.score
+	}
+
+	if c := r.collator.CompareString(a.player.Name, b.player.Name); c != 0 {
+		return c < 0
+	}
+
+	return a.player.Id < b.player.Id
+}
+
+func (r byPlayerRank) Swap(i int, j int) {
+	r.results[i], r.results[j] = r.results[j], r.results[i]
+}
+
+// distance computes the Levenshtein distance between a and b.
+func distance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minimum(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minimum(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,76 @@
+// +build go1.7
+
+package search_test
+
+import (
+	"testing"
+
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage/search"
+)
+
+func TestScore(t *testing.T) {
+	for _, tc := range []struct {
+		query, name string
+		matches     bool
+	}{
+		{"Atletico Madrid", "Atlético Madrid", true},
+		{"atl", "Atlético Madrid", true},
+		{"madrid", "Atlético Madrid", true},
+		{"Barcelna", "FC Barcelona", true},
+		{"Bayren Munich", "FC Bayern Munich", true},
+		{"drid", "Real Madrid", false},
+		{"Chelsea", "Arsenal", false},
+		{"", "Arsenal", false},
+	} {
+		if score := search.NewMatcher(tc.query).Score(tc.name); (score > 0) != tc.matches {
+			t.Fatalf("expected %q matching %q to be %v, got score %d", tc.query, tc.name, tc.matches, score)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	teams := []football.Team{
+		{Id: 1, Name: "Germany U21", IsNational: true},
+		{Id: 2, Name: "Germany", IsNational: true},
+		{Id: 3, Name: "Germania Halberstadt"},
+		{Id: 4, Name: "Germany", IsNational: false},
+		{Id: 5, Name: "Arsenal"},
+	}
+
+	ranked := search.Rank("germany", teams, 0)
+
+	expected := []football.TeamId{2, 4, 1}
+	if len(ranked) != len(expected) {
+		t.Fatalf("expected %d results, got %+v", len(expected), ranked)
+	}
+
+	for i, id := range expected {
+		if ranked[i].Id != id {
+			t.Fatalf("expected team %d at %d, got %+v", id, i, ranked)
+		}
+	}
+
+	if ranked := search.Rank("germ", teams, 2); len(ranked) != 2 || ranked[0].Id != 2 {
+		t.Fatalf("expected the two best prefix matches, got %+v", ranked)
+	}
+}
+
+func TestRankPlayers(t *testing.T) {
+	players := []football.Player{
+		{Id: "1", Name: "Aleksandar Tonev"},
+		{Id: "2", Name: "Aleksandar Aleksandrov"},
+		{Id: "3", Name: "Alexander Tonev"},
+		{Id: "4", Name: "Nuno Morais"},
+	}
+
+	ranked := search.RankPlayers("aleksandar tonev", players, 0)
+	if len(ranked) != 2 || ranked[0].Id != "1" || ranked[1].Id != "3" {
+		t.Fatalf("expected the exact match followed by the fuzzy one, got %+v", ranked)
+	}
+
+	ranked = search.RankPlayers("aleks", players, 0)
+	if len(ranked) != 2 || ranked[0].Id != "2" || ranked[1].Id != "1" {
+		t.Fatalf("expected prefix matches ordered by name, got %+v", ranked)
+	}
+}
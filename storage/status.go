@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/urandom/team-search-test/football"
+)
+
+const (
+	// SourceDownload denotes a repository initialized from its download
+	// data channel.
+	SourceDownload = "download"
+	// SourceLeveldb denotes a repository initialized from previously stored
+	// goleveldb data.
+	SourceLeveldb = "leveldb"
+)
+
+// Status is a snapshot of the initialization progress of a repository.
+type Status struct {
+	// Teams and Players are the number of teams and players ingested so
+	// far, or found in the stored data.
+	Teams   int
+	Players int
+	// StartedAt is when the initialization started.
+	StartedAt time.Time
+	// RefreshedAt is when the repository data was last refreshed. It is
+	// zero until the first refresh completes.
+	RefreshedAt time.Time
+	// Source is where the repository data comes from, such as
+	// SourceDownload.
+	Source string
+	// Err is why newer stored data cannot be served, such as it being
+	// encrypted with a different key. It is nil otherwise.
+	Err error
+}
+
+// Monitor is a team repository that reports its initialization progress
+// without blocking.
+type Monitor interface {
+	football.TeamRepository
+
+	// Ready returns a channel that is closed once the repository is
+	// initialized, successfully or not.
+	Ready() <-chan struct{}
+	// Err returns the initializer error, if the initialization has failed.
+	Err() error
+	// Status returns a snapshot of the initialization progress.
+	Status() Status
+}
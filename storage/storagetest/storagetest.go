@@ -0,0 +1,845 @@
+// Package storagetest provides a behavioral test suite that every
+// football.TeamRepository implementation is expected to pass.
+package storagetest
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
+)
+
+// Constructor creates a new team repository from the download data. Each
+// invocation is expected to return an independent repository.
+type Constructor func(data <-chan download.Team) football.TeamRepository
+
+type team struct {
+	id       football.TeamId
+	name     string
+	national bool
+	players  []football.PlayerId
+}
+
+type player struct {
+	id      football.PlayerId
+	name    string
+	age     int
+	teamIds []football.TeamId
+}
+
+var (
+	data = []download.Team{
+		{Bytes: []byte(team1), Id: 1},
+		{Bytes: []byte(team2), Id: 50},
+		{Bytes: []byte(team3), Id: 100},
+		{Bytes: []byte(team4), Id: 200},
+	}
+
+	duplicates = append(data[:len(data):len(data)], download.Team{Bytes: []byte(team5), Id: 300})
+
+	garbage = []download.Team{
+		{Bytes: []byte(team2), Id: 50},
+		{Bytes: []byte("asdasdsad"), Id: 1},
+		{Bytes: []byte(team3), Id: 100},
+	}
+
+	teams = []team{
+		{1, "Apoel FC", false, nil},
+		{50, "D2", false, []football.PlayerId{}},
+		{100, "Czech Republic", true, nil},
+		{200, "Test 1", true, []football.PlayerId{"235", "6"}},
+	}
+
+	players = []player{
+		{"6", "Nuno Morais", 32, []football.TeamId{1, 200}},
+		{"235", "Jaroslav Plasil", 34, []football.TeamId{100, 200}},
+		{"19492", "Tomás Vaclik", 27, []football.TeamId{100}},
+	}
+)
+
+// TestTeamRepository runs the full behavioral suite against repositories
+// created by the given constructor. Every repository is closed once its
+// subtest is done.
+func TestTeamRepository(t *testing.T, newRepo Constructor) {
+	t.Run("lookups", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		for _, td := range teams {
+			team, err := repo.GetTeam(td.id)
+			if err != nil {
+				t.Fatalf("error looking for team %d: %+v", td.id, err)
+			}
+
+			checkTeam(t, td, team)
+
+			team, err = repo.GetTeamByName(td.name)
+			if err != nil {
+				t.Fatalf("error looking for team %s: %+v", td.name, err)
+			}
+
+			checkTeam(t, td, team)
+		}
+
+		for _, pd := range players {
+			player, err := repo.GetPlayer(pd.id)
+			if err != nil {
+				t.Fatalf("error looking for player %s: %+v", pd.id, err)
+			}
+
+			checkPlayer(t, pd, player)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		_, err := repo.GetTeam(2500)
+		if !storage.IsNotFound(err) {
+			t.Fatalf("expected not found error for team id, got %+v", err)
+		}
+
+		var serr *storage.Error
+		if !errors.As(err, &serr) || serr.Entity != storage.EntityTeam || serr.Key != "2500" {
+			t.Fatalf("expected the error to concern team 2500, got %+v", err)
+		}
+
+		if _, err := repo.GetTeamByName("sdd"); !storage.IsNotFound(err) {
+			t.Fatalf("expected not found error for team name, got %+v", err)
+		}
+
+		if _, err := repo.GetTeamByName("Apoel Nicosia"); !storage.IsNotFound(err) {
+			t.Fatalf("expected not found error for unknown alias, got %+v", err)
+		}
+
+		if _, err := repo.GetPlayer("sdasd"); !storage.IsNotFound(err) {
+			t.Fatalf("expected not found error for player id, got %+v", err)
+		}
+	})
+
+	t.Run("name variants", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		for name, id := range map[string]football.TeamId{
+			"apoel fc":       1,
+			"APOEL":          1,
+			"Apoel F.C.":     1,
+			"czech-republic": 100,
+			"Czéch Republic": 100,
+			"test 1":         200,
+		} {
+			team, err := repo.GetTeamByName(name)
+			if err != nil {
+				t.Fatalf("error looking for team %s: %+v", name, err)
+			}
+
+			if team.Id != id {
+				t.Fatalf("expected %s to resolve to team %d, got %d", name, id, team.Id)
+			}
+		}
+	})
+
+	t.Run("batch lookups", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		batch, ok := repo.(football.BatchGetter)
+		if !ok {
+			t.Skip("repository doesn't implement football.BatchGetter")
+		}
+
+		found, missing, err := batch.GetTeams([]football.TeamId{200, 2500, 1, 50})
+		if err != nil {
+			t.Fatalf("error looking for teams: %+v", err)
+		}
+
+		if len(found) != 3 || found[0].Id != 200 || found[1].Id != 1 || found[2].Id != 50 {
+			t.Fatalf("expected teams 200, 1 and 50, got %+v", found)
+		}
+
+		checkTeam(t, teams[3], found[0])
+
+		if len(missing) != 1 || missing[0] != 2500 {
+			t.Fatalf("expected team 2500 to be missing, got %v", missing)
+		}
+
+		foundPlayers, missingPlayers, err := batch.GetPlayers([]football.PlayerId{"19492", "sdasd", "6"})
+		if err != nil {
+			t.Fatalf("error looking for players: %+v", err)
+		}
+
+		if len(foundPlayers) != 2 {
+			t.Fatalf("expected 2 players, got %+v", foundPlayers)
+		}
+
+		checkPlayer(t, players[2], foundPlayers[0])
+		checkPlayer(t, players[0], foundPlayers[1])
+
+		if len(missingPlayers) != 1 || missingPlayers[0] != "sdasd" {
+			t.Fatalf("expected player sdasd to be missing, got %v", missingPlayers)
+		}
+
+		if found, missing, err := batch.GetTeams(nil); err != nil || len(found) != 0 || len(missing) != 0 {
+			t.Fatalf("expected no teams for no ids, got %+v, %v, %+v", found, missing, err)
+		}
+	})
+
+	t.Run("listing", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		lister, ok := repo.(football.Lister)
+		if !ok {
+			t.Skip("repository doesn't implement football.Lister")
+		}
+
+		all, next, err := lister.ListTeams("", 0)
+		if err != nil {
+			t.Fatalf("error listing teams: %+v", err)
+		}
+
+		if len(all) != len(data) || next != "" {
+			t.Fatalf("expected all %d teams in one page, got %+v, %q", len(data), all, next)
+		}
+
+		// Every storage lists the teams in the same, numeric id order.
+		for i, id := range []football.TeamId{1, 50, 100, 200} {
+			if all[i].Id != id {
+				t.Fatalf("expected team %d at %d, got %+v", id, i, all)
+			}
+		}
+
+		seen := map[football.TeamId]bool{}
+		for cursor, pages := "", 0; ; pages++ {
+			if pages > len(data) {
+				t.Fatalf("expected at most %d pages of teams", len(data))
+			}
+
+			page, next, err := lister.ListTeams(cursor, 2)
+			if err != nil {
+				t.Fatalf("error listing teams after %q: %+v", cursor, err)
+			}
+
+			for _, team := range page {
+				if seen[team.Id] {
+					t.Fatalf("team %d listed twice", team.Id)
+				}
+
+				if expected := all[len(seen)]; team.Id != expected.Id {
+					t.Fatalf("expected team %d after %q, got %d", expected.Id, cursor, team.Id)
+				}
+				seen[team.Id] = true
+			}
+
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if len(seen) != len(data) {
+			t.Fatalf("expected %d teams, got %d", len(data), len(seen))
+		}
+
+		// Some players are members of two of the teams.
+		total := 27 + 34 + 2 - 2
+		seenPlayers := map[football.PlayerId]bool{}
+		for cursor, pages := "", 0; ; pages++ {
+			if pages > total {
+				t.Fatalf("expected at most %d pages of players", total)
+			}
+
+			page, next, err := lister.ListPlayers(cursor, 7)
+			if err != nil {
+				t.Fatalf("error listing players after %q: %+v", cursor, err)
+			}
+
+			for _, p := range page {
+				if seenPlayers[p.Id] {
+					t.Fatalf("player %s listed twice", p.Id)
+				}
+				seenPlayers[p.Id] = true
+			}
+
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if len(seenPlayers) != total {
+			t.Fatalf("expected %d players, got %d", total, len(seenPlayers))
+		}
+
+		if page, next, err := lister.ListPlayers("", 0); err != nil || len(page) != total || next != "" {
+			t.Fatalf("expected all %d players in one page, got %d, %q, %+v", total, len(page), next, err)
+		}
+	})
+
+	t.Run("duplicate names", func(t *testing.T) {
+		repo := newRepo(feed(duplicates))
+		defer closeRepo(t, repo)
+
+		disambiguator, ok := repo.(football.Disambiguator)
+		if !ok {
+			t.Skip("repository doesn't implement football.Disambiguator")
+		}
+
+		for _, name := range []string{"Czech Republic", "czech-republic"} {
+			if _, err := repo.GetTeamByName(name); !storage.IsAmbiguous(err) {
+				t.Fatalf("expected ambiguous error for %s, got %+v", name, err)
+			}
+
+			found, err := disambiguator.GetTeamsByName(name)
+			if err != nil {
+				t.Fatalf("error looking for teams %s: %+v", name, err)
+			}
+
+			if len(found) != 2 || found[0].Id != 100 || found[1].Id != 300 {
+				t.Fatalf("expected teams 100 and 300 for %s, got %+v", name, found)
+			}
+
+			if !found[0].IsNational || found[1].IsNational {
+				t.Fatalf("expected only team 100 to be national, got %+v", found)
+			}
+		}
+
+		found, err := disambiguator.GetTeamsByName("Apoel FC")
+		if err != nil {
+			t.Fatalf("error looking for teams Apoel FC: %+v", err)
+		}
+
+		if len(found) != 1 || found[0].Id != 1 {
+			t.Fatalf("expected only team 1, got %+v", found)
+		}
+
+		if _, err := disambiguator.GetTeamsByName("sdd"); !storage.IsNotFound(err) {
+			t.Fatalf("expected not found error for team name, got %+v", err)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		repo := newRepo(feed(duplicates))
+		defer closeRepo(t, repo)
+
+		searcher, ok := repo.(football.Searcher)
+		if !ok {
+			t.Skip("repository doesn't implement football.Searcher")
+		}
+
+		for query, ids := range map[string][]football.TeamId{
+			"Apoel":          {1},
+			"czech":          {100, 300},
+			"Czech Repbulic": {100, 300},
+			"republic":       {100, 300},
+			"tst 1":          {200},
+			"xyz":            {},
+			"":               {},
+		} {
+			found, err := searcher.Search(query, 5)
+			if err != nil {
+				t.Fatalf("error searching for %s: %+v", query, err)
+			}
+
+			if len(found) != len(ids) {
+				t.Fatalf("expected %d results for %s, got %+v", len(ids), query, found)
+			}
+
+			for i, id := range ids {
+				if found[i].Id != id {
+					t.Fatalf("expected result %d for %s to be team %d, got %d", i, query, id, found[i].Id)
+				}
+			}
+		}
+
+		if found, err := searcher.Search("czech", 1); err != nil || len(found) != 1 {
+			t.Fatalf("expected a single result, got %+v, %+v", found, err)
+		}
+	})
+
+	t.Run("player search", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		searcher, ok := repo.(football.Searcher)
+		if !ok {
+			t.Skip("repository doesn't implement football.Searcher")
+		}
+
+		for query, ids := range map[string][]football.PlayerId{
+			"Jaroslav Plasil": {"235"},
+			"tomas vaclik":    {"19492"},
+			"nuno":            {"6"},
+			"vaclik":          {"19492"},
+			"Jaroslav Plasl":  {"235"},
+			"xyz":             {},
+		} {
+			found, err := searcher.SearchPlayers(query, 5)
+			if err != nil {
+				t.Fatalf("error searching for %s: %+v", query, err)
+			}
+
+			if len(found) != len(ids) {
+				t.Fatalf("expected %d results for %s, got %+v", len(ids), query, found)
+			}
+
+			for i, id := range ids {
+				if found[i].Id != id {
+					t.Fatalf("expected result %d for %s to be player %s, got %s", i, query, id, found[i].Id)
+				}
+			}
+		}
+
+		found, err := searcher.SearchPlayers("Nuno Morais", 1)
+		if err != nil {
+			t.Fatalf("error searching for Nuno Morais: %+v", err)
+		}
+
+		if len(found) != 1 || len(found[0].Teams) != 2 {
+			t.Fatalf("expected a player with two teams, got %+v", found)
+		}
+	})
+
+	t.Run("player queries", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		querier, ok := repo.(football.Querier)
+		if !ok {
+			t.Skip("repository doesn't implement football.Querier")
+		}
+
+		for _, tc := range []struct {
+			query football.PlayerQuery
+			ids   []football.PlayerId
+		}{
+			{football.PlayerQuery{MinTeams: 2}, []football.PlayerId{"235", "6"}},
+			{football.PlayerQuery{MinAge: 35}, []football.PlayerId{"1857", "1860", "27915", "46"}},
+			{football.PlayerQuery{MaxAge: 17}, []football.PlayerId{"185884"}},
+			{football.PlayerQuery{Club: true, MaxAge: 18}, []football.PlayerId{"185880", "185884"}},
+			{football.PlayerQuery{National: true, Club: true}, []football.PlayerId{"6"}},
+			{football.PlayerQuery{National: true, MinAge: 34, MaxAge: 34}, []football.PlayerId{"235"}},
+			{football.PlayerQuery{Teams: []football.TeamId{200}}, []football.PlayerId{"235", "6"}},
+			{football.PlayerQuery{Teams: []football.TeamId{100}, MinAge: 36}, []football.PlayerId{"1857", "46"}},
+			{football.PlayerQuery{Teams: []football.TeamId{50}}, []football.PlayerId{}},
+		} {
+			found, err := querier.QueryPlayers(tc.query)
+			if err != nil {
+				t.Fatalf("error querying players %+v: %+v", tc.query, err)
+			}
+
+			if len(found) != len(tc.ids) {
+				t.Fatalf("expected %d players for %+v, got %+v", len(tc.ids), tc.query, found)
+			}
+
+			for i, id := range tc.ids {
+				if found[i].Id != id {
+					t.Fatalf("expected player %d for %+v to be %s, got %s", i, tc.query, id, found[i].Id)
+				}
+			}
+		}
+
+		all, err := querier.QueryPlayers(football.PlayerQuery{})
+		if err != nil {
+			t.Fatalf("error querying all players: %+v", err)
+		}
+
+		// The players of teams 1, 100 and 200, two of them in two teams.
+		if len(all) != 27+34+2-2 {
+			t.Fatalf("expected all %d players, got %d", 27+34+2-2, len(all))
+		}
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		pending := make(chan download.Team)
+
+		repo := storage.WithContext(newRepo(pending))
+		defer closeRepo(t, repo)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if _, err := repo.GetTeamContext(ctx, 1); !storage.IsNotReady(err) {
+			t.Fatalf("expected not ready error for team id, got %+v", err)
+		}
+
+		if _, err := repo.GetTeamByNameContext(ctx, "D2"); !storage.IsNotReady(err) {
+			t.Fatalf("expected not ready error for team name, got %+v", err)
+		}
+
+		if _, err := repo.GetPlayerContext(ctx, "235"); !storage.IsNotReady(err) {
+			t.Fatalf("expected not ready error for player id, got %+v", err)
+		}
+
+		if d, ok := repo.(football.ContextDisambiguator); ok {
+			if _, err := d.GetTeamsByNameContext(ctx, "D2"); !storage.IsNotReady(err) {
+				t.Fatalf("expected not ready error for team names, got %+v", err)
+			}
+		}
+
+		if s, ok := repo.(football.ContextSearcher); ok {
+			if _, err := s.SearchContext(ctx, "D2", 1); !storage.IsNotReady(err) {
+				t.Fatalf("expected not ready error for search, got %+v", err)
+			}
+
+			if _, err := s.SearchPlayersContext(ctx, "Plasil", 1); !storage.IsNotReady(err) {
+				t.Fatalf("expected not ready error for player search, got %+v", err)
+			}
+		}
+
+		if q, ok := repo.(football.ContextQuerier); ok {
+			if _, err := q.QueryPlayersContext(ctx, football.PlayerQuery{}); !storage.IsNotReady(err) {
+				t.Fatalf("expected not ready error for player query, got %+v", err)
+			}
+		}
+
+		if b, ok := repo.(football.ContextBatchGetter); ok {
+			if _, _, err := b.GetTeamsContext(ctx, []football.TeamId{50}); !storage.IsNotReady(err) {
+				t.Fatalf("expected not ready error for team ids, got %+v", err)
+			}
+
+			if _, _, err := b.GetPlayersContext(ctx, []football.PlayerId{"235"}); !storage.IsNotReady(err) {
+				t.Fatalf("expected not ready error for player ids, got %+v", err)
+			}
+		}
+
+		if l, ok := repo.(football.ContextLister); ok {
+			if _, _, err := l.ListTeamsContext(ctx, "", 1); !storage.IsNotReady(err) {
+				t.Fatalf("expected not ready error for team listing, got %+v", err)
+			}
+
+			if _, _, err := l.ListPlayersContext(ctx, "", 1); !storage.IsNotReady(err) {
+				t.Fatalf("expected not ready error for player listing, got %+v", err)
+			}
+		}
+
+		for _, d := range data {
+			pending <- d
+		}
+		close(pending)
+
+		team, err := repo.GetTeamByNameContext(context.Background(), "D2")
+		if err != nil {
+			t.Fatalf("error looking for team D2: %+v", err)
+		}
+
+		if team.Id != 50 {
+			t.Fatalf("expected id 50, got %d", team.Id)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		pending := make(chan download.Team)
+
+		repo, ok := newRepo(pending).(storage.Monitor)
+		if !ok {
+			close(pending)
+			t.Skip("repository doesn't implement storage.Monitor")
+		}
+		defer closeRepo(t, repo)
+
+		select {
+		case <-repo.Ready():
+			t.Fatalf("expected repository to be initializing")
+		default:
+		}
+
+		if err := repo.Err(); err != nil {
+			t.Fatalf("expected no error while initializing, got %+v", err)
+		}
+
+		if status := repo.Status(); status.StartedAt.IsZero() || !status.RefreshedAt.IsZero() {
+			t.Fatalf("expected only the start time to be set, got %+v", status)
+		}
+
+		for _, d := range data {
+			pending <- d
+		}
+		close(pending)
+
+		<-repo.Ready()
+
+		if err := repo.Err(); err != nil {
+			t.Fatalf("expected no initializer error, got %+v", err)
+		}
+
+		status := repo.Status()
+		if status.Teams != len(data) || status.Players != 27+34+2-2 {
+			t.Fatalf("expected %d teams and %d players, got %+v", len(data), 27+34+2-2, status)
+		}
+
+		if status.Source != storage.SourceDownload || status.RefreshedAt.Before(status.StartedAt) {
+			t.Fatalf("expected a refresh from the download, got %+v", status)
+		}
+
+		failed, ok := newRepo(feed(garbage)).(storage.Monitor)
+		if !ok {
+			t.Fatalf("expected all repositories to implement storage.Monitor")
+		}
+		defer closeRepo(t, failed)
+
+		<-failed.Ready()
+
+		if err := failed.Err(); !storage.IsInitializer(err) {
+			t.Fatalf("expected init error, got %+v", err)
+		}
+	})
+
+	t.Run("initializer", func(t *testing.T) {
+		repo := newRepo(feed(garbage))
+		defer closeRepo(t, repo)
+
+		if _, err := repo.GetTeam(50); !storage.IsInitializer(err) {
+			t.Fatalf("expected init error for team id, got %+v", err)
+		}
+
+		if _, err := repo.GetTeamByName("D2"); !storage.IsInitializer(err) {
+			t.Fatalf("expected init error for team name, got %+v", err)
+		}
+
+		if d, ok := repo.(football.Disambiguator); ok {
+			if _, err := d.GetTeamsByName("D2"); !storage.IsInitializer(err) {
+				t.Fatalf("expected init error for team names, got %+v", err)
+			}
+		}
+
+		if s, ok := repo.(football.Searcher); ok {
+			if _, err := s.Search("D2", 1); !storage.IsInitializer(err) {
+				t.Fatalf("expected init error for search, got %+v", err)
+			}
+
+			if _, err := s.SearchPlayers("Plasil", 1); !storage.IsInitializer(err) {
+				t.Fatalf("expected init error for player search, got %+v", err)
+			}
+		}
+
+		if q, ok := repo.(football.Querier); ok {
+			if _, err := q.QueryPlayers(football.PlayerQuery{}); !storage.IsInitializer(err) {
+				t.Fatalf("expected init error for player query, got %+v", err)
+			}
+		}
+
+		if b, ok := repo.(football.BatchGetter); ok {
+			if _, _, err := b.GetTeams([]football.TeamId{50}); !storage.IsInitializer(err) {
+				t.Fatalf("expected init error for team ids, got %+v", err)
+			}
+
+			if _, _, err := b.GetPlayers([]football.PlayerId{"235"}); !storage.IsInitializer(err) {
+				t.Fatalf("expected init error for player ids, got %+v", err)
+			}
+		}
+
+		if l, ok := repo.(football.Lister); ok {
+			if _, _, err := l.ListTeams("", 1); !storage.IsInitializer(err) {
+				t.Fatalf("expected init error for team listing, got %+v", err)
+			}
+
+			if _, _, err := l.ListPlayers("", 1); !storage.IsInitializer(err) {
+				t.Fatalf("expected init error for player listing, got %+v", err)
+			}
+		}
+
+		if _, err := repo.GetPlayer("235"); !storage.IsInitializer(err) {
+			t.Fatalf("expected init error for player id, got %+v", err)
+		}
+	})
+
+	t.Run("multi-team players", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		for _, pd := range players {
+			player, err := repo.GetPlayer(pd.id)
+			if err != nil {
+				t.Fatalf("error looking for player %s: %+v", pd.id, err)
+			}
+
+			for _, tid := range player.Teams {
+				team, err := repo.GetTeam(tid)
+				if err != nil {
+					t.Fatalf("error looking for team %d of player %s: %+v", tid, pd.id, err)
+				}
+
+				if !hasPlayer(team, pd.id) {
+					t.Fatalf("expected team %d to list player %s", tid, pd.id)
+				}
+			}
+		}
+	})
+
+	t.Run("concurrent readers", func(t *testing.T) {
+		repo := newRepo(feed(data))
+		defer closeRepo(t, repo)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				td := teams[i%len(teams)]
+				if team, err := repo.GetTeamByName(td.name); err != nil {
+					t.Errorf("error looking for team %s: %+v", td.name, err)
+				} else if team.Id != td.id {
+					t.Errorf("expected id %d, got %d", td.id, team.Id)
+				}
+
+				pd := players[i%len(players)]
+				if player, err := repo.GetPlayer(pd.id); err != nil {
+					t.Errorf("error looking for player %s: %+v", pd.id, err)
+				} else if player.Name != pd.name {
+					t.Errorf("expected name %s, got %s", pd.name, player.Name)
+				}
+			}(i)
+		}
+
+		wg.Wait()
+	})
+}
+
+// AliasConstructor creates a new team repository from the download data,
+// which also uses the given user maintained aliases.
+type AliasConstructor func(data <-chan download.Team, aliases *alias.Set) football.TeamRepository
+
+// TestAliases checks that the repositories created by the given constructor
+// resolve user maintained aliases to their canonical teams.
+func TestAliases(t *testing.T, newRepo AliasConstructor) {
+	aliases, err := alias.Load(strings.NewReader(`
+# Comments and empty lines are ignored
+
+Apoel Nicosia = Apoel FC
+Czechia = Czech Republic
+Unknown = Unknown FC
+`))
+	if err != nil {
+		t.Fatalf("error loading aliases: %+v", err)
+	}
+
+	clubs := append(data[:len(data):len(data)],
+		download.Team{Bytes: []byte(`{"status":"ok","code":0,"data":{"team":{"id":400,"name":"Manchester Utd","isNational":false,"players":[]}}}`), Id: 400},
+		download.Team{Bytes: []byte(`{"status":"ok","code":0,"data":{"team":{"id":401,"name":"FC Bayern Munich","isNational":false,"players":[]}}}`), Id: 401},
+	)
+
+	repo := newRepo(feed(clubs), aliases)
+	defer closeRepo(t, repo)
+
+	for name, id := range map[string]football.TeamId{
+		"Apoel Nicosia":     1,
+		"apoel nicosia":     1,
+		"Czechia":           100,
+		"Test 1":            200,
+		"Man Utd":           400,
+		"Manchester United": 400,
+		"Bayern":            401,
+		"Bayern Munich FC":  401,
+	} {
+		team, err := repo.GetTeamByName(name)
+		if err != nil {
+			t.Fatalf("error looking for team %s: %+v", name, err)
+		}
+
+		if team.Id != id {
+			t.Fatalf("expected %s to resolve to team %d, got %d", name, id, team.Id)
+		}
+	}
+
+	if _, err := repo.GetTeamByName("Unknown"); !storage.IsNotFound(err) {
+		t.Fatalf("expected not found error for alias of unknown team, got %+v", err)
+	}
+
+	if _, err := repo.GetTeamByName("Bayern Leverkusen"); !storage.IsNotFound(err) {
+		t.Fatalf("expected not found error for Bayern Leverkusen, got %+v", err)
+	}
+}
+
+// feed sends the given teams through a channel, closing it once done.
+func feed(teams []download.Team) <-chan download.Team {
+	data := make(chan download.Team)
+
+	go func() {
+		for _, d := range teams {
+			data <- d
+		}
+		close(data)
+	}()
+
+	return data
+}
+
+func closeRepo(t *testing.T, repo io.Closer) {
+	if err := repo.Close(); err != nil {
+		t.Fatalf("error closing repository: %+v", err)
+	}
+}
+
+func checkTeam(t *testing.T, td team, team football.Team) {
+	if team.Id != td.id {
+		t.Fatalf("expected id %d, got %d", td.id, team.Id)
+	}
+
+	if team.Name != td.name {
+		t.Fatalf("expected name %s, got %s", td.name, team.Name)
+	}
+
+	if team.IsNational != td.national {
+		t.Fatalf("expected isNational to be %v, got %v", td.national, team.IsNational)
+	}
+
+	if td.players == nil {
+		return
+	}
+
+	if len(team.Players) != len(td.players) {
+		t.Fatalf("expected %d players, got %d", len(td.players), len(team.Players))
+	}
+
+	for i, pid := range td.players {
+		if team.Players[i] != pid {
+			t.Fatalf("expected player id %s, got %s", pid, team.Players[i])
+		}
+	}
+}
+
+func checkPlayer(t *testing.T, pd player, player football.Player) {
+	if player.Id != pd.id {
+		t.Fatalf("expected id %s, got %s", pd.id, player.Id)
+	}
+
+	if player.Name != pd.name {
+		t.Fatalf("expected name %s, got %s", pd.name, player.Name)
+	}
+
+	if player.Age != pd.age {
+		t.Fatalf("expected age %d, got %d", pd.age, player.Age)
+	}
+
+	if len(player.Teams) != len(pd.teamIds) {
+		t.Fatalf("expected %d teams, got %d", len(pd.teamIds), len(player.Teams))
+	}
+
+	for i, tid := range pd.teamIds {
+		if player.Teams[i] != tid {
+			t.Fatalf("expected team id %d, got %d", tid, player.Teams[i])
+		}
+	}
+}
+
+func hasPlayer(team football.Team, id football.PlayerId) bool {
+	for _, pid := range team.Players {
+		if pid == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,415 @@
+// Package tiered provides a team repository that serves queries from memory,
+// while persisting the data in a goleveldb store.
+package tiered
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/goleveldb"
+	"github.com/urandom/team-search-test/storage/memory"
+)
+
+type tiered struct {
+	front front
+	back  storage.Replayer
+
+	init      chan struct{}
+	initError error
+}
+
+// front is the in-memory tier, which implements every context query.
+type front interface {
+	football.ContextTeamRepository
+	football.ContextDisambiguator
+	football.ContextBatchGetter
+	football.ContextSearcher
+	football.ContextQuerier
+	football.ContextLister
+}
+
+// NewTeamRepository creates a two tier team repository. The persistent tier
+// is a goleveldb repository, created with the download data and the given
+// options. It will only consume the download data if a refresh is due, and
+// the fresh data will end up in both tiers. Once the persistent tier is
+// initialized, its contents are used to warm up the in-memory tier, which
+// then serves all queries. The in-memory tier is warmed up again whenever the
+// persistent tier switches to a newer generation, as stored by this or any
+// other process, and keeps serving the previous data meanwhile. Subscribers
+// receive the changes once the in-memory tier serves them. Team names not
+// found in the memory tier are also looked up in the persistent tier, which
+// is the only one to use any user maintained aliases given through the
+// options.
+//
+// If either tier fails to initialize, all repository methods will return an
+// initializer error. The returned repository implements all optional football
+// and storage interfaces, whose context methods return a not-ready error if
+// the context is done before the repository is initialized. The team history
+// is only kept by the persistent tier.
+func NewTeamRepository(data <-chan download.Team, opts ...goleveldb.Option) football.TeamRepository {
+	back := goleveldb.NewTeamRepository(data, opts...).(storage.Replayer)
+
+	// Subscribing before the warm-up makes sure no generation is missed.
+	changes := back.(storage.Watcher).Subscribe(context.Background())
+	switched := make(chan struct{}, 1)
+
+	replay := make(chan download.Team)
+
+	t := &tiered{
+		front: memory.NewTeamRepository(replay, memory.Reload(switched, back.Replay)).(front),
+		back:  back,
+		init:  make(chan struct{}),
+	}
+
+	go t.initialize(replay)
+	go follow(changes, switched)
+
+	return t
+}
+
+func (t *tiered) GetTeam(id football.TeamId) (football.Team, error) {
+	return t.GetTeamContext(context.Background(), id)
+}
+
+func (t *tiered) GetTeamContext(ctx context.Context, id football.TeamId) (football.Team, error) {
+	if err := t.wait(ctx); err != nil {
+		return football.Team{}, storage.NotReady(storage.EntityTeam, id, err)
+	}
+
+	if t.initError != nil {
+		return football.Team{}, storage.Unavailable(storage.EntityTeam, id, t.initError)
+	}
+
+	return t.front.GetTeamContext(ctx, id)
+}
+
+func (t *tiered) GetTeamByName(name string) (football.Team, error) {
+	return t.GetTeamByNameContext(context.Background(), name)
+}
+
+func (t *tiered) GetTeamByNameContext(ctx context.Context, name string) (football.Team, error) {
+	if err := t.wait(ctx); err != nil {
+		return football.Team{}, storage.NotReady(storage.EntityTeam, name, err)
+	}
+
+	if t.initError != nil {
+		return football.Team{}, storage.Unavailable(storage.EntityTeam, name, t.initError)
+	}
+
+	team, err := t.front.GetTeamByNameContext(ctx, name)
+	if storage.IsNotFound(err) {
+		// Only the persistent tier knows about the user maintained aliases.
+		if team, err := t.back.GetTeamByName(name); err == nil {
+			return t.front.GetTeamContext(ctx, team.Id)
+		} else if storage.IsAmbiguous(err) {
+			return team, err
+		}
+	}
+
+	return team, err
+}
+
+func (t *tiered) GetTeamsByName(name string) ([]football.Team, error) {
+	return t.GetTeamsByNameContext(context.Background(), name)
+}
+
+func (t *tiered) GetTeamsByNameContext(ctx context.Context, name string) ([]football.Team, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, name, err)
+	}
+
+	if t.initError != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, name, t.initError)
+	}
+
+	teams, err := t.front.GetTeamsByNameContext(ctx, name)
+	if storage.IsNotFound(err) {
+		back, backErr := storage.GetTeamsByName(t.back, name)
+		if backErr != nil {
+			return teams, err
+		}
+
+		teams = make([]football.Team, 0, len(back))
+		for _, b := range back {
+			team, err := t.front.GetTeamContext(ctx, b.Id)
+			if err != nil {
+				return nil, err
+			}
+
+			teams = append(teams, team)
+		}
+
+		return teams, nil
+	}
+
+	return teams, err
+}
+
+func (t *tiered) Search(query string, limit int) ([]football.Team, error) {
+	return t.SearchContext(context.Background(), query, limit)
+}
+
+func (t *tiered) SearchContext(ctx context.Context, query string, limit int) ([]football.Team, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, nil, errors.Wrapf(err, "searching teams %s", query))
+	}
+
+	if t.initError != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, nil, errors.Wrapf(t.initError, "searching teams %s", query))
+	}
+
+	return t.front.SearchContext(ctx, query, limit)
+}
+
+func (t *tiered) GetPlayer(id football.PlayerId) (football.Player, error) {
+	return t.GetPlayerContext(context.Background(), id)
+}
+
+func (t *tiered) GetPlayerContext(ctx context.Context, id football.PlayerId) (football.Player, error) {
+	if err := t.wait(ctx); err != nil {
+		return football.Player{}, storage.NotReady(storage.EntityPlayer, id, err)
+	}
+
+	if t.initError != nil {
+		return football.Player{}, storage.Unavailable(storage.EntityPlayer, id, t.initError)
+	}
+
+	return t.front.GetPlayerContext(ctx, id)
+}
+
+func (t *tiered) GetTeams(ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	return t.GetTeamsContext(context.Background(), ids)
+}
+
+func (t *tiered) GetTeamsContext(ctx context.Context, ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, nil, storage.NotReady(storage.EntityTeam, nil, errors.Wrapf(err, "getting %d teams", len(ids)))
+	}
+
+	if t.initError != nil {
+		return nil, nil, storage.Unavailable(storage.EntityTeam, nil, errors.Wrapf(t.initError, "getting %d teams", len(ids)))
+	}
+
+	return t.front.GetTeamsContext(ctx, ids)
+}
+
+func (t *tiered) GetPlayers(ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	return t.GetPlayersContext(context.Background(), ids)
+}
+
+func (t *tiered) GetPlayersContext(ctx context.Context, ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrapf(err, "getting %d players", len(ids)))
+	}
+
+	if t.initError != nil {
+		return nil, nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrapf(t.initError, "getting %d players", len(ids)))
+	}
+
+	return t.front.GetPlayersContext(ctx, ids)
+}
+
+func (t *tiered) SearchPlayers(query string, limit int) ([]football.Player, error) {
+	return t.SearchPlayersContext(context.Background(), query, limit)
+}
+
+func (t *tiered) SearchPlayersContext(ctx context.Context, query string, limit int) ([]football.Player, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrapf(err, "searching players %s", query))
+	}
+
+	if t.initError != nil {
+		return nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrapf(t.initError, "searching players %s", query))
+	}
+
+	return t.front.SearchPlayersContext(ctx, query, limit)
+}
+
+func (t *tiered) QueryPlayers(query football.PlayerQuery) ([]football.Player, error) {
+	return t.QueryPlayersContext(context.Background(), query)
+}
+
+func (t *tiered) QueryPlayersContext(ctx context.Context, query football.PlayerQuery) ([]football.Player, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityPlayer, nil, errors.Wrap(err, "querying players"))
+	}
+
+	if t.initError != nil {
+		return nil, storage.Unavailable(storage.EntityPlayer, nil, errors.Wrap(t.initError, "querying players"))
+	}
+
+	return t.front.QueryPlayersContext(ctx, query)
+}
+
+func (t *tiered) ListTeams(cursor string, limit int) ([]football.Team, string, error) {
+	return t.ListTeamsContext(context.Background(), cursor, limit)
+}
+
+func (t *tiered) ListTeamsContext(ctx context.Context, cursor string, limit int) ([]football.Team, string, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, "", storage.NotReady(storage.EntityTeam, nil, errors.Wrap(err, "listing teams"))
+	}
+
+	if t.initError != nil {
+		return nil, "", storage.Unavailable(storage.EntityTeam, nil, errors.Wrap(t.initError, "listing teams"))
+	}
+
+	return t.front.ListTeamsContext(ctx, cursor, limit)
+}
+
+func (t *tiered) ListPlayers(cursor string, limit int) ([]football.Player, string, error) {
+	return t.ListPlayersContext(context.Background(), cursor, limit)
+}
+
+func (t *tiered) ListPlayersContext(ctx context.Context, cursor string, limit int) ([]football.Player, string, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, "", storage.NotReady(storage.EntityPlayer, nil, errors.Wrap(err, "listing players"))
+	}
+
+	if t.initError != nil {
+		return nil, "", storage.Unavailable(storage.EntityPlayer, nil, errors.Wrap(t.initError, "listing players"))
+	}
+
+	return t.front.ListPlayersContext(ctx, cursor, limit)
+}
+
+func (t *tiered) Replay(data chan<- download.Team) error {
+	<-t.init
+
+	if t.initError != nil {
+		return storage.Unavailable(storage.EntityTeam, nil, errors.Wrap(t.initError, "replaying teams"))
+	}
+
+	return t.back.Replay(data)
+}
+
+func (t *tiered) GetTeamAt(id football.TeamId, at time.Time) (storage.TeamVersion, error) {
+	return t.GetTeamAtContext(context.Background(), id, at)
+}
+
+func (t *tiered) GetTeamAtContext(ctx context.Context, id football.TeamId, at time.Time) (storage.TeamVersion, error) {
+	if err := t.wait(ctx); err != nil {
+		return storage.TeamVersion{}, storage.NotReady(storage.EntityTeam, id, errors.Wrapf(err, "getting team %d at %s", id, at))
+	}
+
+	if t.initError != nil {
+		return storage.TeamVersion{}, storage.Unavailable(storage.EntityTeam, id, t.initError)
+	}
+
+	return t.back.(storage.ContextHistorian).GetTeamAtContext(ctx, id, at)
+}
+
+func (t *tiered) GetTeamHistory(id football.TeamId) ([]storage.TeamVersion, error) {
+	return t.GetTeamHistoryContext(context.Background(), id)
+}
+
+func (t *tiered) GetTeamHistoryContext(ctx context.Context, id football.TeamId) ([]storage.TeamVersion, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, id, errors.Wrapf(err, "getting team %d history", id))
+	}
+
+	if t.initError != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, id, t.initError)
+	}
+
+	return t.back.(storage.ContextHistorian).GetTeamHistoryContext(ctx, id)
+}
+
+func (t *tiered) GetTeamIdsByPastName(name string) ([]football.TeamId, error) {
+	return t.GetTeamIdsByPastNameContext(context.Background(), name)
+}
+
+func (t *tiered) GetTeamIdsByPastNameContext(ctx context.Context, name string) ([]football.TeamId, error) {
+	if err := t.wait(ctx); err != nil {
+		return nil, storage.NotReady(storage.EntityTeam, name, errors.Wrapf(err, "getting teams formerly named %s", name))
+	}
+
+	if t.initError != nil {
+		return nil, storage.Unavailable(storage.EntityTeam, name, t.initError)
+	}
+
+	return t.back.(storage.ContextHistorian).GetTeamIdsByPastNameContext(ctx, name)
+}
+
+// Subscribe returns the changes of the memory tier, which are those of the
+// persistent tier, once the memory tier serves them.
+func (t *tiered) Subscribe(ctx context.Context) <-chan football.Change {
+	return t.front.(storage.Watcher).Subscribe(ctx)
+}
+
+func (t *tiered) Ready() <-chan struct{} {
+	return t.init
+}
+
+func (t *tiered) Err() error {
+	select {
+	case <-t.init:
+		if t.initError != nil {
+			return storage.Unavailable("", nil, t.initError)
+		}
+	default:
+	}
+
+	return nil
+}
+
+// Status reports the progress of the persistent tier, which holds the same
+// data as the memory tier once both are initialized.
+func (t *tiered) Status() storage.Status {
+	return t.back.(storage.Monitor).Status()
+}
+
+func (t *tiered) Close() error {
+	if err := t.front.Close(); err != nil {
+		return errors.Wrap(err, "closing memory tier")
+	}
+
+	if err := t.back.Close(); err != nil {
+		return errors.Wrap(err, "closing persistent tier")
+	}
+
+	return nil
+}
+
+// wait blocks until the repository is initialized, or the context is done.
+func (t *tiered) wait(ctx context.Context) error {
+	select {
+	case <-t.init:
+		return nil
+	default:
+	}
+
+	select {
+	case <-t.init:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *tiered) initialize(replay chan download.Team) {
+	defer close(t.init)
+
+	err := t.back.Replay(replay)
+	close(replay)
+
+	if err != nil {
+		t.initError = errors.Wrap(err, "warming up memory tier")
+	}
+}
+
+// follow signals the switches of the persistent tier to a newer generation,
+// as told by its changes, so that the memory tier is warmed up again. The
+// signals are coalesced until the memory tier takes them.
+func follow(changes <-chan football.Change, switched chan<- struct{}) {
+	for range changes {
+		select {
+		case switched <- struct{}{}:
+		default:
+		}
+	}
+}
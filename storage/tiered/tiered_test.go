@@ -0,0 +1,180 @@
+// +build go1.7
+
+package tiered_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+	"github.com/urandom/team-search-test/storage/alias"
+	"github.com/urandom/team-search-test/storage/goleveldb"
+	"github.com/urandom/team-search-test/storage/storagetest"
+	"github.com/urandom/team-search-test/storage/tiered"
+)
+
+func TestTeamRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	i := 0
+	storagetest.TestTeamRepository(t, func(data <-chan download.Team) football.TeamRepository {
+		i++
+		return tiered.NewTeamRepository(data, goleveldb.Path(filepath.Join(dir, fmt.Sprintf("%d.db", i))))
+	})
+}
+
+func TestAliases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	storagetest.TestAliases(t, func(data <-chan download.Team, aliases *alias.Set) football.TeamRepository {
+		return tiered.NewTeamRepository(data, goleveldb.Path(dir), goleveldb.Aliases(aliases))
+	})
+}
+
+func TestWarmFromPersistentTier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	path := goleveldb.Path(filepath.Join(dir, "teams.db"))
+
+	data := make(chan download.Team, 1)
+	data <- download.Team{Bytes: []byte(teamData), Id: 200}
+	close(data)
+
+	repo := goleveldb.NewTeamRepository(data, path)
+	if _, err := repo.GetTeam(200); err != nil {
+		t.Fatalf("error looking for team: %+v", err)
+	}
+
+	if err := repo.Close(); err != nil {
+		t.Fatalf("error closing repository: %+v", err)
+	}
+
+	// The data is fresh, so the download channel will not be consumed.
+	repo = tiered.NewTeamRepository(make(chan download.Team), path)
+	defer repo.Close()
+
+	team, err := repo.GetTeamByName("Test 1")
+	if err != nil {
+		t.Fatalf("error looking for team: %+v", err)
+	}
+
+	if team.Id != 200 || len(team.Players) != 2 {
+		t.Fatalf("expected team 200 with 2 players, got %+v", team)
+	}
+
+	player, err := repo.GetPlayer("235")
+	if err != nil {
+		t.Fatalf("error looking for player: %+v", err)
+	}
+
+	if player.Name != "Jaroslav Plasil" || player.Age != 34 {
+		t.Fatalf("expected Jaroslav Plasil, aged 34, got %+v", player)
+	}
+}
+
+func TestFollowPersistentTier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "football-teams")
+	if err != nil {
+		t.Fatalf("error creating temporary dir: %+v", err)
+	}
+
+	defer func() {
+		os.RemoveAll(dir)
+	}()
+
+	path := goleveldb.Path(filepath.Join(dir, "teams.db"))
+	poll := goleveldb.Poll(10 * time.Millisecond)
+
+	data := make(chan download.Team, 1)
+	data <- download.Team{Bytes: []byte(teamData), Id: 200}
+	close(data)
+
+	repo := tiered.NewTeamRepository(data, path, poll)
+	defer repo.Close()
+
+	if _, err := repo.GetTeam(200); err != nil {
+		t.Fatalf("error looking for team: %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := repo.(storage.Watcher).Subscribe(ctx)
+
+	// Another process refreshes the persistent tier.
+	data = make(chan download.Team, 2)
+	data <- download.Team{Bytes: []byte(teamData), Id: 200}
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 201, "name": "Test 2", "players": [
+		{"id": "7", "name": "Tomas Sivok", "age": "33"}
+	]}}}`), Id: 201}
+	close(data)
+
+	refresher := goleveldb.NewTeamRepository(data, path, goleveldb.Refresh, poll)
+	defer refresher.Close()
+
+	if _, err := refresher.GetTeam(201); err != nil {
+		t.Fatalf("error looking for refreshed team: %+v", err)
+	}
+
+	expected := []struct {
+		kind   football.ChangeKind
+		team   football.TeamId
+		player football.PlayerId
+	}{
+		{football.TeamAdded, 201, ""},
+		{football.PlayerJoined, 201, "7"},
+	}
+
+	for _, e := range expected {
+		select {
+		case c := <-changes:
+			if c.Kind != e.kind || c.Team.Id != e.team || c.Player.Id != e.player {
+				t.Fatalf("expected %s of team %d and player %s, got %+v", e.kind, e.team, e.player, c)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %s of team %d and player %s", e.kind, e.team, e.player)
+		}
+	}
+
+	// The changes are published once the memory tier serves them.
+	if team, err := repo.GetTeamByName("Test 2"); err != nil || team.Id != 201 {
+		t.Fatalf("expected the memory tier to serve the refreshed team, got %+v, %+v", team, err)
+	}
+
+	if player, err := repo.GetPlayer("7"); err != nil || len(player.Teams) != 1 || player.Teams[0] != 201 {
+		t.Fatalf("expected the memory tier to serve the refreshed player, got %+v, %+v", player, err)
+	}
+}
+
+const teamData = `{"data": {"team": {"id": 200, "name": "Test 1", "IsNational": true, "players": [
+	{"id": "235", "name": "Jaroslav Plasil", "age": 34},
+	{"id": "6", "name": "Nuno Morais", "age": "32"}
+]}}}`
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/urandom/team-search-test/football"
+)
+
+// Watcher is a team repository that publishes the changes of its data.
+type Watcher interface {
+	football.TeamRepository
+
+	// Subscribe returns a channel receiving the changes of every refresh of
+	// the repository data, in the order given by football.Changes. The
+	// channel is closed once the context is done or the repository is
+	// closed.
+	Subscribe(ctx context.Context) <-chan football.Change
+}
+
+// Publisher keeps the subscriptions of a Watcher, and sends them its
+// changes.
+type Publisher struct {
+	done <-chan struct{}
+
+	// mu is held while publishing changes to the subscribers.
+	mu          sync.Mutex
+	subscribers map[*subscription]struct{}
+}
+
+type subscription struct {
+	ctx     context.Context
+	changes chan football.Change
+}
+
+// NewPublisher creates a publisher, whose subscriptions are closed once the
+// done channel is.
+func NewPublisher(done <-chan struct{}) *Publisher {
+	return &Publisher{done: done, subscribers: make(map[*subscription]struct{})}
+}
+
+// Subscribe returns a channel receiving the published changes, until the
+// context or the done channel of the publisher is done.
+func (p *Publisher) Subscribe(ctx context.Context) <-chan football.Change {
+	s := &subscription{ctx: ctx, changes: make(chan football.Change)}
+
+	p.mu.Lock()
+	p.subscribers[s] = struct{}{}
+	p.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-p.done:
+		}
+
+		// Once the lock is held, nothing is being sent to the subscriber.
+		p.mu.Lock()
+		delete(p.subscribers, s)
+		close(s.changes)
+		p.mu.Unlock()
+	}()
+
+	return s.changes
+}
+
+// Subscribed checks whether there are any subscribers, so that the changes
+// need not be computed otherwise.
+func (p *Publisher) Subscribed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.subscribers) > 0
+}
+
+// Publish sends the changes to all subscribers, skipping the ones whose
+// context is done. A slow subscriber blocks it until the changes are
+// received.
+func (p *Publisher) Publish(changes []football.Change) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for s := range p.subscribers {
+	send:
+		for _, c := range changes {
+			select {
+			case s.changes <- c:
+			case <-s.ctx.Done():
+				break send
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+type jsonExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONExporter creates an exporter that writes every span as a single line
+// of JSON.
+func NewJSONExporter(w io.Writer) Exporter {
+	return &jsonExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *jsonExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Tracing is best-effort, a failed write shouldn't affect the traced
+	// operation.
+	e.enc.Encode(span)
+}
@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"time"
+
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/football"
+	"github.com/urandom/team-search-test/storage"
+)
+
+type repository struct {
+	repo   football.TeamRepository
+	tracer *Tracer
+}
+
+type downloadObserver struct {
+	tracer *Tracer
+}
+
+// NewTeamRepository wraps the given repository, creating a span for every
+// query. The optional queries are delegated through the storage query
+// functions.
+func NewTeamRepository(repo football.TeamRepository, tracer *Tracer) football.TeamRepository {
+	return repository{repo: repo, tracer: tracer}
+}
+
+// NewDownloadObserver creates a download.Observer that creates a span for
+// every request, including the team id and the response status code.
+func NewDownloadObserver(tracer *Tracer) download.Observer {
+	return downloadObserver{tracer: tracer}
+}
+
+func (r repository) GetTeam(id football.TeamId) (football.Team, error) {
+	span := r.tracer.Start("repository.GetTeam").Set("team.id", id)
+	defer span.End()
+
+	team, err := r.repo.GetTeam(id)
+	span.Fail(err)
+
+	return team, err
+}
+
+func (r repository) GetTeamByName(name string) (football.Team, error) {
+	span := r.tracer.Start("repository.GetTeamByName").Set("team.name", name)
+	defer span.End()
+
+	team, err := r.repo.GetTeamByName(name)
+	span.Fail(err)
+
+	return team, err
+}
+
+func (r repository) GetTeamsByName(name string) ([]football.Team, error) {
+	span := r.tracer.Start("repository.GetTeamsByName").Set("team.name", name)
+	defer span.End()
+
+	teams, err := storage.GetTeamsByName(r.repo, name)
+	span.Fail(err).Set("team.count", len(teams))
+
+	return teams, err
+}
+
+func (r repository) Search(query string, limit int) ([]football.Team, error) {
+	span := r.tracer.Start("repository.Search").Set("query", query).Set("limit", limit)
+	defer span.End()
+
+	teams, err := storage.Search(r.repo, query, limit)
+	span.Fail(err).Set("team.count", len(teams))
+
+	return teams, err
+}
+
+func (r repository) GetPlayer(id football.PlayerId) (football.Player, error) {
+	span := r.tracer.Start("repository.GetPlayer").Set("player.id", id)
+	defer span.End()
+
+	player, err := r.repo.GetPlayer(id)
+	span.Fail(err)
+
+	return player, err
+}
+
+func (r repository) GetTeams(ids []football.TeamId) ([]football.Team, []football.TeamId, error) {
+	span := r.tracer.Start("repository.GetTeams").Set("team.ids", len(ids))
+	defer span.End()
+
+	teams, missing, err := storage.GetTeams(r.repo, ids)
+	span.Fail(err).Set("team.missing", len(missing))
+
+	return teams, missing, err
+}
+
+func (r repository) GetPlayers(ids []football.PlayerId) ([]football.Player, []football.PlayerId, error) {
+	span := r.tracer.Start("repository.GetPlayers").Set("player.ids", len(ids))
+	defer span.End()
+
+	players, missing, err := storage.GetPlayers(r.repo, ids)
+	span.Fail(err).Set("player.missing", len(missing))
+
+	return players, missing, err
+}
+
+func (r repository) SearchPlayers(query string, limit int) ([]football.Player, error) {
+	span := r.tracer.Start("repository.SearchPlayers").Set("query", query).Set("limit", limit)
+	defer span.End()
+
+	players, err := storage.SearchPlayers(r.repo, query, limit)
+	span.Fail(err).Set("player.count", len(players))
+
+	return players, err
+}
+
+func (r repository) QueryPlayers(query football.PlayerQuery) ([]football.Player, error) {
+	span := r.tracer.Start("repository.QueryPlayers").Set("query", query)
+	defer span.End()
+
+	players, err := storage.QueryPlayers(r.repo, query)
+	span.Fail(err).Set("player.count", len(players))
+
+	return players, err
+}
+
+func (r repository) ListTeams(cursor string, limit int) ([]football.Team, string, error) {
+	span := r.tracer.Start("repository.ListTeams").Set("cursor", cursor).Set("limit", limit)
+	defer span.End()
+
+	teams, next, err := storage.ListTeams(r.repo, cursor, limit)
+	span.Fail(err).Set("team.count", len(teams))
+
+	return teams, next, err
+}
+
+func (r repository) ListPlayers(cursor string, limit int) ([]football.Player, string, error) {
+	span := r.tracer.Start("repository.ListPlayers").Set("cursor", cursor).Set("limit", limit)
+	defer span.End()
+
+	players, next, err := storage.ListPlayers(r.repo, cursor, limit)
+	span.Fail(err).Set("player.count", len(players))
+
+	return players, next, err
+}
+
+func (r repository) Close() error {
+	return r.repo.Close()
+}
+
+func (o downloadObserver) Request(id int) {
+}
+
+func (o downloadObserver) Response(id int, status int, elapsed time.Duration) {
+	end := time.Now()
+
+	o.tracer.StartAt("download.getTeam", end.Add(-elapsed)).
+		Set("team.id", id).
+		Set("http.status_code", status).
+		EndAt(end)
+}
+
+func (o downloadObserver) Retry(id int) {
+}
@@ -0,0 +1,160 @@
+// Package tracing provides lightweight, OpenTelemetry style spans for timing
+// the download, ingestion and querying of team data.
+//
+// A nil *Tracer and a nil *Span are valid, and do nothing, so that traced
+// code doesn't have to check whether tracing is enabled.
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Tracer creates spans and hands them to an exporter once they end.
+type Tracer struct {
+	exporter Exporter
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// Span is a single timed operation. A span without a parent starts a new
+// trace.
+type Span struct {
+	tracer *Tracer
+	data   SpanData
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// SpanData is the exported representation of an ended span.
+type SpanData struct {
+	TraceId    string                 `json:"trace_id"`
+	SpanId     string                 `json:"span_id"`
+	ParentId   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Duration   float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Exporter receives spans once they end. It may be called concurrently.
+type Exporter interface {
+	Export(span SpanData)
+}
+
+// New creates a tracer that sends ended spans to the exporter.
+func New(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Start starts a new root span.
+func (t *Tracer) Start(name string) *Span {
+	return t.StartAt(name, time.Now())
+}
+
+// StartAt starts a new root span with the given start time. It is meant for
+// operations which were timed by other means.
+func (t *Tracer) StartAt(name string, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+
+	return &Span{tracer: t, data: SpanData{
+		TraceId: t.id(16), SpanId: t.id(8),
+		Name: name, Start: start,
+	}}
+}
+
+// Child starts a new span, whose parent is the current one.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return &Span{tracer: s.tracer, data: SpanData{
+		TraceId: s.data.TraceId, SpanId: s.tracer.id(8), ParentId: s.data.SpanId,
+		Name: name, Start: time.Now(),
+	}}
+}
+
+// Set sets an attribute of the span.
+func (s *Span) Set(key string, value interface{}) *Span {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[key] = value
+
+	return s
+}
+
+// Fail marks the span as failed if the error is not nil.
+func (s *Span) Fail(err error) *Span {
+	if s == nil || err == nil {
+		return s
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Error = err.Error()
+
+	return s
+}
+
+// End ends the span and exports it. Subsequent calls have no effect.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt ends the span with the given end time.
+func (s *Span) EndAt(end time.Time) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.data.End = end
+	s.data.Duration = float64(end.Sub(s.data.Start)) / float64(time.Millisecond)
+	data := s.data
+	s.mu.Unlock()
+
+	s.tracer.exporter.Export(data)
+}
+
+func (s *Span) String() string {
+	if s == nil {
+		return "<nil>"
+	}
+
+	return fmt.Sprintf("%s/%s %s", s.data.TraceId, s.data.SpanId, s.data.Name)
+}
+
+func (t *Tracer) id(size int) string {
+	b := make([]byte, size)
+
+	t.mu.Lock()
+	t.rand.Read(b)
+	t.mu.Unlock()
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,106 @@
+package tracing_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/urandom/team-search-test/download"
+	"github.com/urandom/team-search-test/storage/memory"
+	"github.com/urandom/team-search-test/tracing"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *recorder) Export(span tracing.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.spans = append(r.spans, span)
+}
+
+func (r *recorder) names() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := map[string]int{}
+	for _, s := range r.spans {
+		names[s.Name]++
+	}
+
+	return names
+}
+
+func TestSpans(t *testing.T) {
+	var b bytes.Buffer
+	tracer := tracing.New(tracing.NewJSONExporter(&b))
+
+	root := tracer.Start("root").Set("key", "value")
+	root.Child("child").End()
+	root.End()
+	root.End()
+
+	dec := json.NewDecoder(&b)
+
+	var child, parent tracing.SpanData
+	if err := dec.Decode(&child); err != nil {
+		t.Fatalf("error decoding child span: %+v", err)
+	}
+
+	if err := dec.Decode(&parent); err != nil {
+		t.Fatalf("error decoding root span: %+v", err)
+	}
+
+	if dec.More() {
+		t.Fatalf("expected a span to only be exported once")
+	}
+
+	if child.TraceId != parent.TraceId || child.ParentId != parent.SpanId {
+		t.Fatalf("expected %+v to be a child of %+v", child, parent)
+	}
+
+	if parent.Attributes["key"] != "value" {
+		t.Fatalf("expected attribute value, got %v", parent.Attributes["key"])
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *tracing.Tracer
+
+	span := tracer.Start("root")
+	span.Child("child").Set("key", "value").End()
+	span.End()
+}
+
+func TestTeamRepository(t *testing.T) {
+	rec := &recorder{}
+	tracer := tracing.New(rec)
+
+	data := make(chan download.Team, 2)
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 1, "name": "Apoel FC"}}}`), Id: 1}
+	data <- download.Team{Bytes: []byte(`{"data": {"team": {"id": 2, "name": "D2"}}}`), Id: 2}
+	close(data)
+
+	repo := tracing.NewTeamRepository(memory.NewTeamRepository(data, memory.Tracer(tracer)), tracer)
+
+	repo.GetTeam(1)
+	repo.GetTeamByName("D2")
+	repo.GetPlayer("6")
+
+	names := rec.names()
+	for name, count := range map[string]int{
+		"memory.ingest":            2,
+		"parse":                    2,
+		"repository.GetTeam":       1,
+		"repository.GetTeamByName": 1,
+		"repository.GetPlayer":     1,
+	} {
+		if names[name] != count {
+			t.Fatalf("expected %d %s spans, got %d", count, name, names[name])
+		}
+	}
+}